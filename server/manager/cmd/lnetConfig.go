@@ -0,0 +1,189 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+LNet settings shared by the commands, from flags or the manager config:
+
+	lnet:
+	  networks: tcp0(eth0),tcp1(eth1)  # or ip2nets: "tcp0 192.168.0.*; tcp1 10.0.*.*"
+	  routes: "tcp1 10.0.0.[1-2]@tcp0"  # remote networks and their gateways
+	  forwarding: false  # act as an LNet router
+	  discovery: true
+	  peer_credits: 8  # tx credits per peer NI, and its messages handled at a time
+	  credits: 256  # tx credits per local NI
+	  router_buffers: 512  # buffers for forwarded messages
+	  health_sensitivity: 100  # health lost on each failure (0 disables health)
+	  retry_count: 2  # resends of a failed send
+	  transaction_timeout: 50s
+	  checksum: verify
+	  timeouts:
+	    accept: 5s
+	    hello: 5s
+	    read: 50s
+	    write: 50s
+	    idle: 180s
+	    keepalive: 30s
+*/
+package cmd
+
+import (
+	"time"
+
+	"github.com/glimmerfs/glimmer/wire/lnet"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Config keys for the LNet settings
+const (
+	configNetworks      = "lnet.networks"
+	configIP2Nets       = "lnet.ip2nets"
+	configRoutes        = "lnet.routes"
+	configForwarding    = "lnet.forwarding"
+	configDiscovery     = "lnet.discovery"
+	configPeerCredits   = "lnet.peer_credits"
+	configCredits       = "lnet.credits"
+	configRouterBuffers = "lnet.router_buffers"
+	configHealth        = "lnet.health_sensitivity"
+	configRetryCount    = "lnet.retry_count"
+	configTransaction   = "lnet.transaction_timeout"
+	configChecksum      = "lnet.checksum"
+	configAcceptTimeout = "lnet.timeouts.accept"
+	configHelloTimeout  = "lnet.timeouts.hello"
+	configReadTimeout   = "lnet.timeouts.read"
+	configWriteTimeout  = "lnet.timeouts.write"
+	configIdleTimeout   = "lnet.timeouts.idle"
+	configKeepalive     = "lnet.timeouts.keepalive"
+)
+
+// addLNetFlags adds flags for the LNet settings to the command, overriding the config.
+func addLNetFlags(cmd *cobra.Command) {
+	cmd.Flags().String("networks", "", "LNet networks and their interfaces (or sockets), e.g., tcp0(eth0),tcp1(eth1),unix0(/run/glimmer/lnet.sock) (default: listen on all addresses)")
+	cobra.CheckErr(viper.BindPFlag(configNetworks, cmd.Flags().Lookup("networks")))
+	cmd.Flags().String("ip2nets", "", "LNet networks selected by host address, e.g., \"tcp0 192.168.0.*; tcp1 10.0.*.*\"")
+	cobra.CheckErr(viper.BindPFlag(configIP2Nets, cmd.Flags().Lookup("ip2nets")))
+	cmd.Flags().String("routes", "", "LNet routes to remote networks, e.g., \"tcp1 10.0.0.[1-2]@tcp0; o2ib0 2 10.0.0.3@tcp0:1\"")
+	cobra.CheckErr(viper.BindPFlag(configRoutes, cmd.Flags().Lookup("routes")))
+	cmd.Flags().Bool("forwarding", false, "Forward messages between networks, as an LNet router")
+	cobra.CheckErr(viper.BindPFlag(configForwarding, cmd.Flags().Lookup("forwarding")))
+	cmd.Flags().Bool("discovery", true, "Discover the NIDs of peers, and push ours to them")
+	cobra.CheckErr(viper.BindPFlag(configDiscovery, cmd.Flags().Lookup("discovery")))
+	cmd.Flags().Int("peer-credits", lnet.DEFAULT_PEER_CREDITS, "Tx credits of each peer NI, and its messages handled at a time (Lustre's peer_credits)")
+	cobra.CheckErr(viper.BindPFlag(configPeerCredits, cmd.Flags().Lookup("peer-credits")))
+	cmd.Flags().Int("credits", lnet.DEFAULT_NI_CREDITS, "Tx credits of each local NI (Lustre's credits)")
+	cobra.CheckErr(viper.BindPFlag(configCredits, cmd.Flags().Lookup("credits")))
+	cmd.Flags().Int("router-buffers", lnet.DEFAULT_ROUTER_BUFFERS, "Buffers for messages forwarded as a router")
+	cobra.CheckErr(viper.BindPFlag(configRouterBuffers, cmd.Flags().Lookup("router-buffers")))
+	cmd.Flags().Int("health-sensitivity", lnet.DEFAULT_HEALTH_SENSITIVITY, "Health lost by an NI on each failure (0 disables health)")
+	cobra.CheckErr(viper.BindPFlag(configHealth, cmd.Flags().Lookup("health-sensitivity")))
+	cmd.Flags().Int("retry-count", lnet.DEFAULT_RETRY_COUNT, "Number of times a failed send is resent")
+	cobra.CheckErr(viper.BindPFlag(configRetryCount, cmd.Flags().Lookup("retry-count")))
+	cmd.Flags().Duration("transaction-timeout", lnet.DEFAULT_TRANSACTION_TIMEOUT, "Time for a message to be sent and answered, including resends")
+	cobra.CheckErr(viper.BindPFlag(configTransaction, cmd.Flags().Lookup("transaction-timeout")))
+	cmd.Flags().String("checksum", lnet.CHECKSUM_VERIFY.String(), "Use of ksock checksums: on, off or verify (only check those sent by peers)")
+	cobra.CheckErr(viper.BindPFlag(configChecksum, cmd.Flags().Lookup("checksum")))
+
+	defaults := lnet.DefaultTimeouts()
+	flags := []struct {
+		name  string
+		key   string
+		value time.Duration
+		usage string
+	}{
+		{"accept-timeout", configAcceptTimeout, defaults.Accept, "Time for the acceptor connection request"},
+		{"hello-timeout", configHelloTimeout, defaults.Hello, "Time for the HELLO exchange"},
+		{"read-timeout", configReadTimeout, defaults.Read, "Time to read a frame once it started arriving"},
+		{"write-timeout", configWriteTimeout, defaults.Write, "Time to write a frame"},
+		{"idle-timeout", configIdleTimeout, defaults.Idle, "Time without frames before a peer is considered dead (0 for no limit)"},
+		{"keepalive", configKeepalive, defaults.Keepalive, "Time without frames before sending a NOOP to the peer (0 to disable)"},
+	}
+	for _, flag := range flags {
+		cmd.Flags().Duration(flag.name, flag.value, flag.usage)
+		cobra.CheckErr(viper.BindPFlag(flag.key, cmd.Flags().Lookup(flag.name)))
+	}
+}
+
+// lnetTimeouts returns the LNet timeouts from the config (or flags).
+func lnetTimeouts() lnet.Timeouts {
+	timeouts := lnet.DefaultTimeouts()
+	durations := []struct {
+		key   string
+		value *time.Duration
+	}{
+		{configAcceptTimeout, &timeouts.Accept},
+		{configHelloTimeout, &timeouts.Hello},
+		{configReadTimeout, &timeouts.Read},
+		{configWriteTimeout, &timeouts.Write},
+		{configIdleTimeout, &timeouts.Idle},
+		{configKeepalive, &timeouts.Keepalive},
+	}
+	for _, duration := range durations {
+		if viper.IsSet(duration.key) {
+			*duration.value = viper.GetDuration(duration.key)
+		}
+	}
+	return timeouts
+}
+
+// lnetNetworks returns the networks= or ip2nets= setting, if any.
+func lnetNetworks() (lnet.NetworkConfig, bool, error) {
+	networks, ip2nets := viper.GetString(configNetworks), viper.GetString(configIP2Nets)
+	if networks == "" && ip2nets == "" {
+		return lnet.NetworkConfig{}, false, nil
+	}
+	config, err := lnet.ParseNetworkConfig(networks, ip2nets)
+	return config, err == nil, err
+}
+
+// configureLNetServer applies all LNet settings from the config (or flags) to the client of a server:
+// those of configureLNetClient, then discovery, forwarding and routes.
+func configureLNetServer(client *lnet.LNetClient) error {
+	if err := configureLNetClient(client); err != nil {
+		return err
+	}
+	client.Discovery = viper.GetBool(configDiscovery)
+	client.Forwarding = viper.GetBool(configForwarding)
+	routes, err := lnet.ParseRoutes(viper.GetString(configRoutes))
+	if err != nil {
+		return err
+	}
+	return client.AddRoutes(routes)
+}
+
+// configureLNetClient applies the LNet settings from the config (or flags) that suit any client,
+// such as remote-ping: discovery, forwarding and routes are left to configureLNetServer, so that
+// a probe is not discovered by its target, and does not act as a router.
+func configureLNetClient(client *lnet.LNetClient) error {
+	client.Timeouts = lnetTimeouts()
+	if viper.IsSet(configHealth) {
+		client.HealthSensitivity = viper.GetInt(configHealth)
+	}
+	if viper.IsSet(configRetryCount) {
+		client.RetryCount = viper.GetInt(configRetryCount)
+	}
+	if viper.IsSet(configTransaction) {
+		client.TransactionTimeout = viper.GetDuration(configTransaction)
+	}
+	client.SetCredits(lnet.Credits{
+		Peer:          viper.GetInt(configPeerCredits),
+		NI:            viper.GetInt(configCredits),
+		RouterBuffers: viper.GetInt(configRouterBuffers),
+	})
+	networks, ok, err := lnetNetworks()
+	if err != nil {
+		return err
+	}
+	if ok {
+		if err := client.ConfigureNetworks(networks); err != nil {
+			return err
+		}
+	}
+	if viper.IsSet(configChecksum) {
+		checksum, err := lnet.ParseChecksumMode(viper.GetString(configChecksum))
+		if err != nil {
+			return err
+		}
+		client.Checksum = checksum
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+LNet status of a running server, like "lnetctl net show" and "lnetctl peer show":
+serve publishes it over HTTP with --status-addr, and lnet-status fetches and prints it.
+*/
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/glimmerfs/glimmer/wire/lnet"
+	"github.com/spf13/cobra"
+)
+
+// Path of the LNet status on the status address
+const lnetStatusPath = "/lnet/status"
+
+// lnetStatusCmd represents the lnet-status command
+var lnetStatusCmd = &cobra.Command{
+	Use:   "lnet-status",
+	Short: "Show the LNet NIs, peers and routes of a running server",
+	Long: `Show the LNet NIs, peers and routes of a running server, with their health
+and credits. The server must be started with --status-addr.
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		addr, _ := cmd.Flags().GetString("addr")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		ctx, cancel := context.WithTimeout(cmd.Context(), timeout)
+		defer cancel()
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+lnetStatusPath, nil)
+		if err != nil {
+			return err
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			return fmt.Errorf("failed to get LNet status: %w", err)
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to get LNet status: %s", response.Status)
+		}
+		var status lnetStatusReport
+		if err := json.NewDecoder(response.Body).Decode(&status); err != nil {
+			return fmt.Errorf("failed to decode LNet status: %w", err)
+		}
+		return status.Print(cmd.OutOrStdout(), asJSON)
+	},
+}
+
+// lnetStatusReport is the state of the NIs, peers and routes of a client.
+type lnetStatusReport struct {
+	NIs           []lnetNIStatus    `json:"nis"`
+	Peers         []lnetPeerStatus  `json:"peers"`
+	Routes        []lnetRouteStatus `json:"routes,omitempty"`
+	RouterBuffers lnetCredits       `json:"router_buffers"`
+}
+
+// lnetCredits is a credit pool; min goes negative when messages had to queue.
+type lnetCredits struct {
+	Max       int `json:"max"`
+	Available int `json:"available"`
+	Min       int `json:"min"`
+	Queued    int `json:"queued"`
+}
+
+func (credits lnetCredits) String() string {
+	return lnet.CreditStats(credits).String()
+}
+
+type lnetNIStatus struct {
+	NID       string      `json:"nid"`
+	Interface string      `json:"interface"`
+	Status    string      `json:"status"`
+	Health    int         `json:"health"`
+	Credits   lnetCredits `json:"credits"`
+}
+
+type lnetPeerStatus struct {
+	NID       string      `json:"nid"`
+	Health    int         `json:"health"`
+	Conns     int         `json:"conns"`
+	Credits   lnetCredits `json:"credits"`
+	RxCredits lnetCredits `json:"rx_credits"`
+}
+
+type lnetRouteStatus struct {
+	Net      string `json:"net"`
+	Gateway  string `json:"gateway"`
+	Hops     uint32 `json:"hops"`
+	Priority uint32 `json:"priority"`
+	Alive    bool   `json:"alive"`
+}
+
+// lnetStatus takes a snapshot of the state of the client.
+func lnetStatus(client *lnet.LNetClient) lnetStatusReport {
+	status := lnetStatusReport{
+		NIs:           []lnetNIStatus{},
+		Peers:         []lnetPeerStatus{},
+		RouterBuffers: lnetCredits(client.RouterBuffers()),
+	}
+	for _, info := range client.NIs.Info() {
+		nid, err := info.NID(client.Port)
+		if err != nil {
+			continue
+		}
+		status.NIs = append(status.NIs, lnetNIStatus{
+			NID:       nid.String(),
+			Interface: info.Interface,
+			Status:    info.Status.String(),
+			Health:    info.Health,
+			Credits:   lnetCredits(info.Credits),
+		})
+	}
+	for _, peer := range client.Peers.Peers() {
+		status.Peers = append(status.Peers, lnetPeerStatus{
+			NID:       peer.NID.String(),
+			Health:    peer.Health(),
+			Conns:     len(peer.Conns()),
+			Credits:   lnetCredits(peer.Credits()),
+			RxCredits: lnetCredits(peer.RxCredits()),
+		})
+	}
+	slices.SortFunc(status.Peers, func(a, b lnetPeerStatus) int { return strings.Compare(a.NID, b.NID) })
+	for _, route := range client.Routes.Routes() {
+		status.Routes = append(status.Routes, lnetRouteStatus{
+			Net:      fmt.Sprintf("%s%d", route.NetType, route.NetNum),
+			Gateway:  route.Gateway.String(),
+			Hops:     route.Hops,
+			Priority: route.Priority,
+			Alive:    route.Alive,
+		})
+	}
+	return status
+}
+
+// serveLNetStatus publishes the LNet status of the client on addr until ctx is cancelled.
+func serveLNetStatus(ctx context.Context, addr string, client *lnet.LNetClient) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+lnetStatusPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(lnetStatus(client)); err != nil {
+			slog.Warn("failed to write LNet status", "error", err)
+		}
+	})
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	context.AfterFunc(ctx, func() { _ = server.Close() })
+	slog.Info("serving LNet status", "addr", addr, "path", lnetStatusPath)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve LNet status: %w", err)
+	}
+	return nil
+}
+
+// Print writes the status as JSON or as human-readable text.
+func (status lnetStatusReport) Print(out io.Writer, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(out).Encode(status)
+	}
+	var b strings.Builder
+	b.WriteString("nis:\n")
+	for _, ni := range status.NIs {
+		fmt.Fprintf(&b, "    %s (%s) %s health=%d credits=%s\n", ni.NID, ni.Interface, ni.Status, ni.Health, ni.Credits)
+	}
+	b.WriteString("peers:\n")
+	for _, peer := range status.Peers {
+		fmt.Fprintf(&b, "    %s health=%d conns=%d credits=%s rx_credits=%s\n", peer.NID, peer.Health, peer.Conns, peer.Credits, peer.RxCredits)
+	}
+	if len(status.Routes) > 0 {
+		b.WriteString("routes:\n")
+		for _, route := range status.Routes {
+			state := "down"
+			if route.Alive {
+				state = "up"
+			}
+			fmt.Fprintf(&b, "    %s via %s hops=%d priority=%d %s\n", route.Net, route.Gateway, route.Hops, route.Priority, state)
+		}
+	}
+	fmt.Fprintf(&b, "router buffers: %s\n", status.RouterBuffers)
+	_, err := io.WriteString(out, b.String())
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(lnetStatusCmd)
+
+	lnetStatusCmd.Flags().String("addr", "127.0.0.1:9988", "Status address of the server (its --status-addr)")
+	lnetStatusCmd.Flags().DurationP("timeout", "t", 5*time.Second, "Time to wait for the status")
+	lnetStatusCmd.Flags().Bool("json", false, "Print the status as JSON")
+}
@@ -5,8 +5,12 @@ SPDX-License-Identifier: GPL-2.0
 package cmd
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
+	"time"
 
 	"github.com/glimmerfs/glimmer/wire/lnet"
 	"github.com/spf13/cobra"
@@ -20,7 +24,10 @@ var remotePingCmd = &cobra.Command{
 
 This can verify local network connectivity to help with troubleshooting.
 Unlike lnetctl ping, this does not require binding to port 1023 and supports
-specifying a custom port.
+specifying a custom port. Services on the same node can also be pinged on
+their Unix-domain socket, e.g., /run/glimmer/lnet.sock@unix0.
+
+The command fails if any ping fails, so it can be used as a readiness probe.
 `,
 	ValidArgs: []string{"<remote_address>"}, // Placeholder for argument validation
 	Args:      cobra.ExactArgs(1),           // Expect exactly one argument (the remote address)
@@ -35,12 +42,116 @@ specifying a custom port.
 		if NID.IsAny() {
 			return fmt.Errorf("cannot ping 'any' NID")
 		}
+		count, _ := cmd.Flags().GetInt("count")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		asJSON, _ := cmd.Flags().GetBool("json")
+		if count < 1 {
+			return fmt.Errorf("--count must be at least 1")
+		}
+
 		slog.Info("pinging remote service", "nid", NID)
-		// TODO: implement actual ping logic using LNetClient
+		client := lnet.NewLNetClient()
+		if err := configureLNetClient(&client); err != nil {
+			return err
+		}
+		ctx := cmd.Context()
+		failures := 0
+		for seq := 1; seq <= count; seq++ {
+			if seq > 1 {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(interval):
+				}
+			}
+			result := remotePing(ctx, &client, NID, timeout)
+			result.Sequence = seq
+			if result.Error != "" {
+				failures++
+			}
+			if err := result.Print(cmd.OutOrStdout(), asJSON); err != nil {
+				return err
+			}
+		}
+		if failures > 0 {
+			return fmt.Errorf("%d of %d pings to %s failed", failures, count, NID)
+		}
 		return nil
 	},
 }
 
+// remotePingResult is the outcome of a single ping.
+type remotePingResult struct {
+	Sequence int                 `json:"seq"`
+	NID      string              `json:"nid"`
+	RTT      time.Duration       `json:"rtt_ns,omitempty"`
+	PID      lnet.PID32          `json:"pid,omitempty"`
+	Features []string            `json:"features,omitempty"`
+	Peers    []remotePingPeerNID `json:"nids,omitempty"`
+	Error    string              `json:"error,omitempty"`
+}
+
+type remotePingPeerNID struct {
+	NID    string `json:"nid"`
+	Status string `json:"status"`
+}
+
+func remotePing(ctx context.Context, client *lnet.LNetClient, nid lnet.NID, timeout time.Duration) remotePingResult {
+	result := remotePingResult{NID: nid.String()}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	remote, err := client.Dial(ctx, nid)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer func() {
+		if err := remote.Close(); err != nil {
+			slog.Warn("error closing connection", "error", err, "nid", nid)
+		}
+	}()
+	// The round-trip time only covers the GET/REPLY exchange, not connecting
+	start := time.Now()
+	ping, err := client.PingRemote(ctx, remote)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.RTT = time.Since(start)
+	result.PID = ping.PID
+	result.Features = lnet.PingFeature(ping.Features).Names()
+	for _, nidStatus := range ping.NIDStatuses {
+		result.Peers = append(result.Peers, remotePingPeerNID{NID: nidStatus.NID.String(), Status: nidStatus.Status.String()})
+	}
+	return result
+}
+
+// Print writes the result as a JSON line or as human-readable text.
+func (result remotePingResult) Print(out io.Writer, asJSON bool) error {
+	if asJSON {
+		return json.NewEncoder(out).Encode(result)
+	}
+	if result.Error != "" {
+		_, err := fmt.Fprintf(out, "ping %s: seq=%d error: %s\n", result.NID, result.Sequence, result.Error)
+		return err
+	}
+	if _, err := fmt.Fprintf(out, "ping %s: seq=%d time=%s pid=%d features=%v\n", result.NID, result.Sequence, result.RTT, result.PID, result.Features); err != nil {
+		return err
+	}
+	for _, peer := range result.Peers {
+		if _, err := fmt.Fprintf(out, "    %s %s\n", peer.NID, peer.Status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func init() {
 	rootCmd.AddCommand(remotePingCmd)
+
+	remotePingCmd.Flags().IntP("count", "c", 1, "Number of pings to send")
+	remotePingCmd.Flags().DurationP("interval", "i", time.Second, "Time to wait between pings")
+	remotePingCmd.Flags().DurationP("timeout", "t", 5*time.Second, "Time to wait for each ping (including connecting)")
+	remotePingCmd.Flags().Bool("json", false, "Print one JSON object per ping")
 }
@@ -0,0 +1,67 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for the remote-ping command.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/glimmerfs/glimmer/wire/lnet"
+	"github.com/spf13/viper"
+)
+
+// TestRemotePingLeavesNoPeer checks that a probe is neither discovered nor kept as a peer by its target,
+// even with discovery enabled in the config.
+func TestRemotePingLeavesNoPeer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	discovery := viper.Get(configDiscovery)
+	viper.Set(configDiscovery, true)
+	t.Cleanup(func() { viper.Set(configDiscovery, discovery) })
+
+	server := lnet.NewLNetServer()
+	server.Client.Discovery = true
+	server.Client.NIs.Set([]lnet.LocalNI{{NetType: lnet.NETWORK_TYPE_TCP, Addr: netip.MustParseAddr("127.0.0.1")}})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	go func() { _ = server.Serve(ctx, listener) }()
+	defer server.Shutdown(ctx)
+	nid, err := lnet.ParseNID(fmt.Sprintf("127.0.0.1@tcp0#%d", listener.Addr().(*net.TCPAddr).Port))
+	if err != nil {
+		t.Fatalf("ParseNID failed: %v", err)
+	}
+	peers := len(server.Client.Peers.Peers())
+
+	client := lnet.NewLNetClient()
+	if err := configureLNetClient(&client); err != nil {
+		t.Fatalf("configureLNetClient failed: %v", err)
+	}
+	// As with networks= in the config, which makes the probe multi-rail
+	client.NIs.Set([]lnet.LocalNI{{NetType: lnet.NETWORK_TYPE_TCP, Addr: netip.MustParseAddr("127.0.0.2")}})
+	if client.Discovery || client.Forwarding {
+		t.Errorf("Probe has discovery %v and forwarding %v; expected neither", client.Discovery, client.Forwarding)
+	}
+	if result := remotePing(ctx, &client, nid, time.Second); result.Error != "" {
+		t.Fatalf("remotePing failed: %s", result.Error)
+	}
+
+	for len(server.Client.Peers.Peers()) != peers {
+		if ctx.Err() != nil {
+			t.Fatalf("Target has %d peers after the ping; expected %d", len(server.Client.Peers.Peers()), peers)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if mrPeers := server.Client.Peers.MultiRailPeers(); len(mrPeers) != 0 {
+		t.Errorf("Target discovered %d multi-rail peers; expected none", len(mrPeers))
+	}
+}
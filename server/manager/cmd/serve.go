@@ -0,0 +1,80 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+*/
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/glimmerfs/glimmer/wire/lnet"
+	"github.com/spf13/cobra"
+)
+
+// serveCmd represents the serve command
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve LNet connections",
+	Long: `Serve LNet connections until interrupted.
+
+On SIGINT or SIGTERM, the server stops accepting connections and drains the
+existing ones, waiting at most --shutdown-timeout. A second signal exits immediately.
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		port, _ := cmd.Flags().GetUint16("port")
+		shutdownTimeout, _ := cmd.Flags().GetDuration("shutdown-timeout")
+		statusAddr, _ := cmd.Flags().GetString("status-addr")
+
+		server := lnet.NewLNetServer().WithPort(port)
+		if err := configureLNetServer(&server.Client); err != nil {
+			return err
+		}
+		ctx := cmd.Context()
+		if networks, ok, _ := lnetNetworks(); ok {
+			// Listeners and ping replies follow address changes of the interfaces
+			go func() { _ = server.Client.WatchInterfaces(ctx, networks, 0) }()
+		}
+		if server.Client.Discovery {
+			// Peers learn about changes of our NIs
+			go func() { _ = server.Client.RunDiscovery(ctx) }()
+		}
+		if len(server.Client.Routes.Routes()) > 0 {
+			// Routes through dead gateways are not used
+			go func() { _ = server.Client.RunRouterChecks(ctx, 0) }()
+		}
+		if server.Client.HealthSensitivity > 0 {
+			// Unhealthy NIs are used again once they recover
+			go func() { _ = server.Client.RunHealthRecovery(ctx, 0) }()
+		}
+		if statusAddr != "" {
+			go func() {
+				if err := serveLNetStatus(ctx, statusAddr, &server.Client); err != nil {
+					slog.Error("LNet status unavailable", "error", err)
+				}
+			}()
+		}
+		// Listen returns once ctx is cancelled, leaving the connections to Shutdown
+		listenErr := server.Listen(ctx)
+		if errors.Is(listenErr, lnet.ErrServerClosed) {
+			listenErr = nil
+		}
+
+		slog.Info("shutting down LNet server", "timeout", shutdownTimeout)
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+		defer cancel()
+		return errors.Join(listenErr, server.Shutdown(shutdownCtx))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	serveCmd.Flags().Uint16P("port", "p", lnet.DEFAULT_PORT, "Port to listen on for LNet connections")
+	serveCmd.Flags().Duration("shutdown-timeout", 30*time.Second, "Time to wait for connections to drain on shutdown")
+	serveCmd.Flags().String("status-addr", "", "Address to serve the LNet status on over HTTP, for lnet-status (default: none)")
+	addLNetFlags(serveCmd)
+}
@@ -28,6 +28,9 @@ import (
 func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
+	// Commands shut down gracefully on the first signal.
+	// Restoring the default handling lets a second signal exit immediately.
+	context.AfterFunc(ctx, stop)
 	err := cmd.ExecuteContext(ctx)
 	if err != nil {
 		os.Exit(1)
@@ -0,0 +1,147 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+ksock message checksums.
+
+socklnd (protocol V2 and later) can checksum each frame: the ksock header (with its checksum
+field zeroed), the LNet header and the payload. A checksum of 0 means the sender did not
+compute one. Lustre uses crc32_le with a seed of ~0 and no final inversion, which is the
+complement of the usual CRC-32 (IEEE), and closes connections with a mismatch.
+*/
+package lnet
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"log/slog"
+	"sync/atomic"
+)
+
+// ChecksumMode selects how a connection uses ksock checksums.
+type ChecksumMode uint32
+
+const (
+	// Verify checksums sent by the peer, but do not send any (like Lustre's default)
+	CHECKSUM_VERIFY ChecksumMode = iota
+	// Send checksums and verify those sent by the peer (Lustre's enable_csum)
+	CHECKSUM_ON
+	// Neither send nor verify checksums
+	CHECKSUM_OFF
+)
+
+func (mode ChecksumMode) String() string {
+	switch mode {
+	case CHECKSUM_VERIFY:
+		return "verify"
+	case CHECKSUM_ON:
+		return "on"
+	case CHECKSUM_OFF:
+		return "off"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint32(mode))
+	}
+}
+
+// ParseChecksumMode parses "on", "off" or "verify".
+func ParseChecksumMode(s string) (ChecksumMode, error) {
+	for _, mode := range []ChecksumMode{CHECKSUM_VERIFY, CHECKSUM_ON, CHECKSUM_OFF} {
+		if s == mode.String() {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid checksum mode %q: expected on, off or verify", s)
+}
+
+// ChecksumError is returned for a received frame whose checksum does not match its contents.
+type ChecksumError struct {
+	// Checksum sent by the peer
+	Received uint32
+	// Checksum of the frame as received
+	Computed uint32
+}
+
+func (err *ChecksumError) Error() string {
+	return fmt.Sprintf("ksock checksum mismatch: received 0x%08x, computed 0x%08x", err.Received, err.Computed)
+}
+
+// ChecksumStats counts checksums of sent and received frames.
+type ChecksumStats struct {
+	// Frames sent with a checksum
+	Sent uint64
+	// Received frames whose checksum matched
+	Verified uint64
+	// Received frames whose checksum did not match
+	Mismatched uint64
+}
+
+type checksumCounter int
+
+const (
+	checksumSent checksumCounter = iota
+	checksumVerified
+	checksumMismatched
+)
+
+type checksumCounters [3]atomic.Uint64
+
+func (counters *checksumCounters) stats() ChecksumStats {
+	return ChecksumStats{
+		Sent:       counters[checksumSent].Load(),
+		Verified:   counters[checksumVerified].Load(),
+		Mismatched: counters[checksumMismatched].Load(),
+	}
+}
+
+// countChecksum adds to the counters of the connection and of its client.
+func (remote *RemoteConn) countChecksum(counter checksumCounter) {
+	remote.checksums[counter].Add(1)
+	if remote.clientChecksums != nil {
+		remote.clientChecksums[counter].Add(1)
+	}
+}
+
+// ChecksumStats returns the checksum counters of the connection.
+func (remote *RemoteConn) ChecksumStats() ChecksumStats {
+	return remote.checksums.stats()
+}
+
+// ChecksumStats returns the checksum counters of all connections of the client.
+func (client *LNetClient) ChecksumStats() ChecksumStats {
+	return client.checksums.stats()
+}
+
+// Offset of the checksum in the ksock header
+const ksockChecksumOffset = 4
+
+// ksockChecksum returns the socklnd checksum of a frame.
+// The checksum field of the frame must be zero.
+func ksockChecksum(frame []byte) uint32 {
+	return ^crc32.ChecksumIEEE(frame)
+}
+
+// setChecksum fills in the checksum of an outgoing frame, if the connection sends them.
+func (remote *RemoteConn) setChecksum(frame []byte, byteOrder binary.ByteOrder) {
+	if remote.Checksum != CHECKSUM_ON || remote.Version < KSOCK_PROTO_V2 {
+		return
+	}
+	byteOrder.PutUint32(frame[ksockChecksumOffset:], ksockChecksum(frame))
+	remote.countChecksum(checksumSent)
+}
+
+// verifyChecksum checks the checksum of a received frame, computed by the frameReader.
+func (remote *RemoteConn) verifyChecksum(received uint32, reader *frameReader) error {
+	if received == 0 || remote.Checksum == CHECKSUM_OFF {
+		return nil
+	}
+	computed := ^reader.crc
+	if computed != received {
+		remote.countChecksum(checksumMismatched)
+		err := &ChecksumError{Received: received, Computed: computed}
+		slog.Error("dropping connection with corrupted frame", "error", err, "remote", remote)
+		return err
+	}
+	remote.countChecksum(checksumVerified)
+	return nil
+}
@@ -0,0 +1,106 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for ksock checksums.
+*/
+package lnet
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestKsockChecksum(t *testing.T) {
+	// crc32_le(~0, "123456789") without the final inversion of CRC-32
+	if checksum := ksockChecksum([]byte("123456789")); checksum != 0x340bc6d9 {
+		t.Errorf("ksockChecksum = 0x%08x; expected 0x340bc6d9", checksum)
+	}
+}
+
+func TestParseChecksumMode(t *testing.T) {
+	for _, mode := range []ChecksumMode{CHECKSUM_VERIFY, CHECKSUM_ON, CHECKSUM_OFF} {
+		parsed, err := ParseChecksumMode(mode.String())
+		if err != nil || parsed != mode {
+			t.Errorf("ParseChecksumMode(%q) = %v, %v; expected %v", mode.String(), parsed, err, mode)
+		}
+	}
+	if _, err := ParseChecksumMode("sometimes"); err == nil {
+		t.Errorf("ParseChecksumMode accepted an invalid mode")
+	}
+}
+
+func TestChecksumSendAndVerify(t *testing.T) {
+	local, peer := net.Pipe()
+	defer local.Close()
+	defer peer.Close()
+	client := NewLNetClient()
+	sender := client.newRemoteConn(local)
+	sender.Version = KSOCK_PROTO_V3
+	sender.Checksum = CHECKSUM_ON
+	receiver := &RemoteConn{Conn: &peer, ByteOrder: DEFAULT_BYTE_ORDER}
+
+	message := testPutMessage(t, []byte("glimmering"))
+	sent := make(chan error, 1)
+	go func() { sent <- client.SendMessage(context.Background(), sender, message) }()
+	_, received, err := readMessage(context.Background(), receiver)
+	if err != nil {
+		t.Fatalf("readMessage failed: %v", err)
+	}
+	if string(received.Payload) != "glimmering" {
+		t.Errorf("Received payload %q; expected %q", received.Payload, "glimmering")
+	}
+	if err := <-sent; err != nil {
+		t.Errorf("SendMessage failed: %v", err)
+	}
+	if stats := client.ChecksumStats(); stats.Sent != 1 {
+		t.Errorf("Client counted %d sent checksums; expected 1", stats.Sent)
+	}
+	if stats := receiver.ChecksumStats(); stats.Verified != 1 || stats.Mismatched != 0 {
+		t.Errorf("Receiver counted %+v; expected 1 verified checksum", stats)
+	}
+}
+
+func TestChecksumMismatch(t *testing.T) {
+	frame := encodeFrame(t, testPutMessage(t, []byte("glimmering")))
+	DEFAULT_BYTE_ORDER.PutUint32(frame[ksockChecksumOffset:], ksockChecksum(frame))
+	// Corrupt the payload
+	frame[len(frame)-1] ^= 0x01
+
+	tests := []struct {
+		mode     ChecksumMode
+		mismatch bool
+	}{
+		{CHECKSUM_VERIFY, true},
+		{CHECKSUM_ON, true},
+		{CHECKSUM_OFF, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			local, peer := net.Pipe()
+			defer local.Close()
+			go func() {
+				defer peer.Close()
+				_, _ = peer.Write(frame)
+			}()
+			remote := &RemoteConn{Conn: &local, ByteOrder: DEFAULT_BYTE_ORDER, Checksum: tt.mode}
+			_, _, err := readMessage(context.Background(), remote)
+			var checksumErr *ChecksumError
+			if errors.As(err, &checksumErr) != tt.mismatch {
+				t.Errorf("readMessage returned %v; expected a mismatch: %v", err, tt.mismatch)
+			}
+			if !tt.mismatch && err != nil {
+				t.Errorf("readMessage failed: %v", err)
+			}
+			expected := uint64(0)
+			if tt.mismatch {
+				expected = 1
+			}
+			if stats := remote.ChecksumStats(); stats.Mismatched != expected {
+				t.Errorf("Counted %d mismatches; expected %d", stats.Mismatched, expected)
+			}
+		})
+	}
+}
@@ -8,10 +8,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"log/slog"
 	"net"
 	"net/netip"
+	"time"
 )
 
 // A client for communication via LNet.
@@ -20,18 +24,82 @@ type LNetClient struct {
 	CompatMode bool             // Stricter communication with Lustre
 	ByteOrder  binary.ByteOrder // we use native-endian on our side (receiver). on the remote side, we need to detect and do flipping if needed.
 	LocalAddrs []netip.Addr     // Known local IPs (validates destinations)
+	// Local network interfaces from ConfigureNetworks, which take precedence over LocalAddrs
+	NIs *NITable
 	// Port to listen on for incoming LNet connections
 	// As a special change, we allow NIDs to have a #PORT suffix to change the default
 	Port uint16
 	// Command registry for handling different LNet message types
 	Commands CommandRegistry
+	// Process identifier advertised to peers
+	PID PID32
+	// Incarnation advertised to peers, which lets them detect restarts
+	Incarnation uint64
+	// Configuration for outgoing connections
+	Dialer net.Dialer
+	// Network drivers by network type (SockLND for tcp, LoLND for lo and UnixLND for unix by default)
+	LNDs *LNDTable
+	// Match entries and memory descriptors for incoming PUTs and GETs
+	Portals *PortalTable
+	// Time to wait for the ACK or REPLY of our operations (unless the context has a deadline)
+	TransactionTimeout time.Duration
+	// Maximum number of frames queued per connection (DEFAULT_SEND_QUEUE_SIZE if 0)
+	SendQueueSize int
+	// Maximum size of a received LNet header and payload (DEFAULT_MAX_MESSAGE_SIZE if 0)
+	MaxMessageSize int
+	// Limits for the I/O on each connection
+	Timeouts Timeouts
+	// Use of ksock checksums on each connection
+	Checksum ChecksumMode
+	// Known peers and their connections
+	Peers *PeerTable
+	// Open separate CONTROL and BULK_OUT connections in PeerConn (Lustre's typed_conns)
+	TypedConns bool
+	// Discover the NIDs of new peers, and answer their discovery pushes (PING_FEATURE_DISCOVERY)
+	Discovery bool
+	// Routes to remote networks
+	Routes *RouteTable
+	// Forward messages for other nodes to their next hop, as an LNet router
+	Forwarding bool
+	// Health lost by an NI on each failure (0 disables health)
+	HealthSensitivity int
+	// Number of times a failed send is resent
+	RetryCount int
+	// Outstanding PUTs and GETs
+	operations *operationTable
+	// Handlers for PeerEvents
+	peerEvents *peerEventSubscribers
+	// Checksum counters of all connections
+	checksums *checksumCounters
+	// Buffers for messages being forwarded
+	routerBuffers *creditPool
 }
 
 // NewLNetClient creates a new LNetClient with default settings.
 func NewLNetClient() LNetClient {
-	client := LNetClient{ByteOrder: DEFAULT_BYTE_ORDER, Port: DEFAULT_PORT}
+	client := LNetClient{
+		ByteOrder:          DEFAULT_BYTE_ORDER,
+		Port:               DEFAULT_PORT,
+		PID:                PID_LUSTRE,
+		Incarnation:        uint64(time.Now().UnixNano()),
+		TransactionTimeout: DEFAULT_TRANSACTION_TIMEOUT,
+		HealthSensitivity:  DEFAULT_HEALTH_SENSITIVITY,
+		RetryCount:         DEFAULT_RETRY_COUNT,
+		Timeouts:           DefaultTimeouts(),
+	}
 	client.Commands = make(CommandRegistry)
-	client.Commands[LNET_MSG_GET] = client.HandleGet
+	client.Portals = NewPortalTable()
+	client.Peers = NewPeerTable()
+	client.NIs = NewNITable()
+	client.Routes = NewRouteTable()
+	client.LNDs = NewLNDTable()
+	client.LNDs.Register(SockLND{})
+	client.LNDs.Register(LoLND{})
+	client.LNDs.Register(UnixLND{})
+	client.operations = newOperationTable()
+	client.peerEvents = newPeerEventSubscribers()
+	client.checksums = &checksumCounters{}
+	client.routerBuffers = newCreditPool(DEFAULT_ROUTER_BUFFERS)
 	return client
 }
 
@@ -42,91 +110,222 @@ func (client LNetClient) WithPort(port uint16) LNetClient {
 	return client
 }
 
-// SendCommand sends an LNet command to the remote connection.
+// SendMessage sends an LNet message to the remote connection, through the LND of the connection.
+// The message is queued for the connection's writer, and SendMessage returns once it is written.
 // TODO: in Lustre, remote may need to be looked up.
 func (client *LNetClient) SendMessage(ctx context.Context, remote *RemoteConn, message LNetMessage) error {
-	_ = ctx
 	if message.LNetCommand == nil {
 		return fmt.Errorf("cannot send LNET message with nil command")
 	}
-	databuf := new(bytes.Buffer)
 	slog.Info("Sending LNET message", "message", message)
-	data, err := message.ToBytes(remote.ByteOrder)
+	release, err := client.takeCredits(ctx, remote)
 	if err != nil {
-		return fmt.Errorf("failed to convert LNet message to bytes: %w", err)
+		return err
 	}
-	messageHeader := KSockMessageHeader{
-		Type:     KSOCK_MSG_LNET,
-		Checksum: 0,
+	defer release()
+	if err := remote.driver().Send(ctx, remote, message); err != nil {
+		if ctx.Err() == nil {
+			client.connFailed(remote, err)
+		}
+		return &sendError{err}
 	}
-	if err := binary.Write(databuf, remote.ByteOrder, messageHeader); err != nil {
-		return fmt.Errorf("failed to write message header: %w", err)
+	client.sendSucceeded(remote)
+	return nil
+}
+
+// readMessage reads the next ksock message from the remote connection.
+// For KSOCK_MSG_NOOP, the returned LNetMessage is empty.
+// io.EOF is only returned if the connection ends between frames.
+// Waiting for a frame is bounded by remote.Timeouts.Idle (returning ErrPeerDead),
+// reading the rest of it by remote.Timeouts.Read.
+func readMessage(ctx context.Context, remote *RemoteConn) (uint32, LNetMessage, error) {
+	conn := *remote.Conn
+	reader := &frameReader{reader: conn}
+	var header [24]byte
+	err := withDeadline(ctx, remote.Timeouts.Idle, conn.SetReadDeadline, func() error {
+		// Checked after setting the deadline, so that stopReading cannot be missed
+		if remote.readStopped.Load() {
+			return ErrConnClosed
+		}
+		return reader.readFull("ksock header", header[:])
+	})
+	if isIdleTimeout(ctx, remote, reader, err) {
+		return 0, LNetMessage{}, fmt.Errorf("%w: nothing received for %v: %w", ErrPeerDead, remote.Timeouts.Idle, err)
+	}
+	if err != nil {
+		return 0, LNetMessage{}, err
 	}
-	databuf.Write(data)
-	if _, err := (*remote.Conn).Write(databuf.Bytes()); err != nil {
-		return fmt.Errorf("failed to write LNet message: %w", err)
+	remote.markReceived()
+	var messageHeader KSockMessageHeader
+	if _, err := binary.Decode(header[:], remote.ByteOrder, &messageHeader); err != nil {
+		return 0, LNetMessage{}, fmt.Errorf("error decoding message header: %w", err)
+	}
+	// The checksum covers the header with a zero checksum field
+	clear(header[ksockChecksumOffset : ksockChecksumOffset+4])
+	reader.crc = crc32.Update(0, crc32.IEEETable, header[:])
+	switch messageHeader.Type {
+	case KSOCK_MSG_NOOP:
+		slog.Info("received NOOP message", "remote", remote)
+		return messageHeader.Type, LNetMessage{}, remote.verifyChecksum(messageHeader.Checksum, reader)
+	case KSOCK_MSG_LNET:
+		slog.Info("received LNET message", "remote", remote)
+		var message LNetMessage
+		err := withDeadline(ctx, remote.Timeouts.Read, conn.SetReadDeadline, func() error {
+			var err error
+			message, err = readCommand(reader, remote)
+			return err
+		})
+		if err != nil {
+			return messageHeader.Type, message, fmt.Errorf("error reading LNET message: %w", err)
+		}
+		if err := remote.verifyChecksum(messageHeader.Checksum, reader); err != nil {
+			message.release()
+			return messageHeader.Type, LNetMessage{}, err
+		}
+		return messageHeader.Type, message, nil
+	default:
+		return messageHeader.Type, LNetMessage{}, fmt.Errorf("unsupported message type: %d", messageHeader.Type)
 	}
-	return nil
 }
 
+// handleCommands reads and handles messages until the connection fails or ends.
+// While it runs, NOOPs keep the connection alive, and a dead peer is reported with PEER_EVENT_DEAD.
 func (client *LNetClient) handleCommands(ctx context.Context, remote *RemoteConn) error {
-	_ = ctx
+	remote.markReceived()
+	done := make(chan struct{})
+	defer close(done)
+	go client.keepalive(ctx, remote, done)
 	for {
-		var messageHeader KSockMessageHeader
-		if err := binary.Read(*remote.Conn, remote.ByteOrder, &messageHeader); err != nil {
-			slog.Error("error reading message header", "error", err, "remote", remote)
+		message, err := remote.driver().Receive(ctx, remote)
+		if errors.Is(err, ErrPeerDead) {
+			slog.Warn("LNet peer is dead", "error", err, "remote", remote)
+			client.connFailed(remote, err)
+			client.publishPeerEvent(PeerEvent{Type: PEER_EVENT_DEAD, NID: remote.NID, Remote: remote, Err: err})
 			return err
 		}
-		switch messageHeader.Type {
-		case KSOCK_MSG_NOOP:
-			slog.Info("received NOOP message", "remote", remote)
-		case KSOCK_MSG_LNET:
-			slog.Info("received LNET message", "remote", remote)
-			if messageHeader.Checksum != 0 {
-				slog.Warn("LNET message has non-zero checksum, which is unsupported", "checksum", messageHeader.Checksum, "remote", remote)
-			}
-			message, err := ReadCommand(ctx, remote)
-			if err != nil {
-				slog.Error("error reading LNET message", "error", err, "remote", remote)
-				return err
-			}
-			handler, ok := client.Commands[message.MessageType]
-			if !ok {
-				slog.Warn("no handler registered for message type, ignoring message", "messageType", message.MessageType, "remote", remote)
-				continue
-			}
-			if err := handler(ctx, remote, message); err != nil {
-				slog.Error("error handling message", "error", err, "messageType", message.MessageType, "remote", remote)
-				return err
+		if err != nil {
+			// Closing between frames (by either side) is normal
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) && !errors.Is(err, ErrConnClosed) {
+				slog.Error("error reading message", "error", err, "remote", remote)
 			}
-		default:
-			return fmt.Errorf("unsupported message type: %d", messageHeader.Type)
+			return err
+		}
+		release, err := client.takeRxCredit(ctx, remote)
+		if err != nil {
+			message.release()
+			return err
+		}
+		err = client.dispatch(ctx, remote, message)
+		release()
+		if err != nil {
+			return err
 		}
 	}
 }
 
-func (client *LNetClient) handleConnection(ctx context.Context, conn net.Conn) {
-	_ = ctx
-	defer func() {
-		err := conn.Close()
-		if err != nil {
-			slog.Warn("error closing connection", "error", err, "remote", conn.RemoteAddr())
+// dispatch forwards a message that is not for us, or hands it to its handler, and releases it.
+// Only handler errors are returned, as they end the connection.
+func (client *LNetClient) dispatch(ctx context.Context, remote *RemoteConn, message LNetMessage) error {
+	defer message.release()
+	if !client.isLocal(remote, message.DestNID) {
+		if err := client.forward(ctx, remote, message); err != nil {
+			slog.Warn("dropping message for another node", "error", err, "source", message.SourceNID, "remote", remote)
 		}
-	}()
-	slog.Info("LNetClient accepted connection", "remote", conn.RemoteAddr())
+		return nil
+	}
+	handler, ok := client.commandHandler(message.MessageType)
+	if !ok {
+		slog.Warn("no handler registered for message type, ignoring message", "messageType", message.MessageType, "remote", remote)
+		return nil
+	}
+	if err := handler(ctx, remote, message); err != nil {
+		slog.Error("error handling message", "error", err, "messageType", message.MessageType, "remote", remote)
+		return err
+	}
+	return nil
+}
 
-	remote := RemoteConn{Conn: &conn, ByteOrder: client.ByteOrder}
-	err := Negotiate(ctx, &remote)
-	if err != nil {
-		slog.Error("LNetClient negotiation failed", "error", err, "remote", remote)
-		return
+// commandHandler returns the handler for a message type.
+// Handlers in Commands take precedence over the built-in handlers, which are bound here
+// (rather than in NewLNetClient) so that they see the settings of this copy of the client.
+func (client *LNetClient) commandHandler(messageType CommandType) (CommandHandler, bool) {
+	if handler, ok := client.Commands[messageType]; ok {
+		return handler, true
+	}
+	switch messageType {
+	case LNET_MSG_ACK:
+		return client.HandleAck, true
+	case LNET_MSG_PUT:
+		return client.HandlePut, true
+	case LNET_MSG_GET:
+		return client.HandleGet, true
+	case LNET_MSG_REPLY:
+		return client.HandleReply, true
+	}
+	return nil, false
+}
+
+// ConnError reports the failure of a single connection.
+type ConnError struct {
+	// What failed: "negotiate" or "handle"
+	Op string
+	// Address of the remote end
+	Addr net.Addr
+	// NID of the remote, if the handshake got that far
+	NID NID
+	Err error
+}
+
+func (err *ConnError) Error() string {
+	if err.NID == nil {
+		return fmt.Sprintf("LNet connection with %v: %s failed: %v", err.Addr, err.Op, err.Err)
+	}
+	return fmt.Sprintf("LNet connection with %v (%v): %s failed: %v", err.Addr, err.NID, err.Op, err.Err)
+}
+
+func (err *ConnError) Unwrap() error {
+	return err.Err
+}
+
+// handleConnection negotiates an accepted connection and handles its messages until it ends.
+// A peer closing the connection between frames is not an error, other failures are returned as *ConnError.
+// The connection is left open for the caller to close.
+func (client *LNetClient) handleConnection(ctx context.Context, remote *RemoteConn) error {
+	addr := (*remote.Conn).RemoteAddr()
+	slog.Info("LNetClient accepted connection", "remote", addr)
+	defer client.removeConn(remote)
+	if err := remote.driver().Accept(ctx, remote); err != nil {
+		if errors.Is(err, ErrGenericProtocol) {
+			slog.Info("LNetClient answered generic protocol query", "remote", addr)
+			return nil
+		}
+		return &ConnError{Op: "negotiate", Addr: addr, Err: err}
 	}
 	slog.Info("LNetClient negotiation succeeded", "remote", remote)
+	// Not in admitConn, as discovery must not send before the HELLO reply
+	client.discoverNew(remote)
 
-	err = client.handleCommands(ctx, &remote)
-	if err != nil {
-		slog.Error("LNetClient command handling failed", "error", err, "remote", remote)
-		panic(err) // abort to limit traffic for now
-		// return
+	err := client.handleCommands(ctx, remote)
+	switch {
+	case errors.Is(err, io.EOF):
+		slog.Info("LNetClient connection closed by peer", "remote", remote)
+		return nil
+	case errors.Is(err, net.ErrClosed):
+		// Closed on our side, e.g., because the peer restarted
+		slog.Info("LNetClient connection closed", "remote", remote)
+		return nil
 	}
+	return &ConnError{Op: "handle", Addr: addr, NID: remote.NID, Err: err}
+}
+
+// SendNoop sends a KSOCK_MSG_NOOP, which carries no LNet message.
+func (client *LNetClient) SendNoop(ctx context.Context, remote *RemoteConn) error {
+	databuf := new(bytes.Buffer)
+	if err := binary.Write(databuf, remote.ByteOrder, KSockMessageHeader{Type: KSOCK_MSG_NOOP}); err != nil {
+		return fmt.Errorf("failed to write message header: %w", err)
+	}
+	if err := remote.enqueue(ctx, databuf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write NOOP: %w", err)
+	}
+	return nil
 }
@@ -80,6 +80,8 @@ type LNetHelloCommand struct {
 	Type        uint32
 }
 
+// CommandHandler handles a received message.
+// The payload is reused once the handler returns, so handlers must copy what they keep.
 type CommandHandler func(ctx context.Context, remote *RemoteConn, message LNetMessage) error
 type CommandRegistry map[CommandType]CommandHandler
 
@@ -90,20 +92,38 @@ func init() {
 }
 
 // ReadCommand reads an LNet command from the specified remote connection.
+// The ksock header of the frame must already have been read.
+// Reading is bounded by remote.Timeouts.Read and by ctx.
 func ReadCommand(ctx context.Context, remote *RemoteConn) (LNetMessage, error) {
-	_ = ctx
+	var message LNetMessage
+	err := withDeadline(ctx, remote.Timeouts.Read, (*remote.Conn).SetReadDeadline, func() error {
+		var err error
+		message, err = readCommand(&frameReader{reader: *remote.Conn}, remote)
+		return err
+	})
+	return message, err
+}
+
+// readCommand reads the LNet header and the payload of a frame.
+// The payload comes from the buffer pool, see LNetMessage.release.
+func readCommand(reader *frameReader, remote *RemoteConn) (LNetMessage, error) {
 	message := LNetMessage{}
-	destNID, err := ReadNID(*remote.Conn, remote.ByteOrder, 0)
+	start := reader.read
+	destNID, err := ReadNID(reader, remote.ByteOrder, remote.largeHeaders())
 	if err != nil {
-		return message, err
+		return message, reader.wrap("destination NID", err)
 	}
-	sourceNID, err := ReadNID(*remote.Conn, remote.ByteOrder, 0)
+	sourceNID, err := ReadNID(reader, remote.ByteOrder, remote.largeHeaders())
 	if err != nil {
+		return message, reader.wrap("source NID", err)
+	}
+	var header [16 + LNET_MSG_UNION_SIZE]byte
+	if err := reader.readFull("header", header[:]); err != nil {
 		return message, err
 	}
 	var messageTail LNetHeaderEmbed
-	if err := binary.Read(*remote.Conn, remote.ByteOrder, &messageTail); err != nil {
-		return message, fmt.Errorf("error reading message tail: %w", err)
+	if _, err := binary.Decode(header[:16], remote.ByteOrder, &messageTail); err != nil {
+		return message, fmt.Errorf("error decoding message tail: %w", err)
 	}
 	slog.Info("received LNET message header", "destNID", destNID, "sourceNID", sourceNID, "messageTail", messageTail, "remote", remote)
 	message = LNetMessage{DestNID: destNID, SourceNID: sourceNID, LNetHeaderEmbed: messageTail}
@@ -122,27 +142,47 @@ func ReadCommand(ctx context.Context, remote *RemoteConn) (LNetMessage, error) {
 		slog.Warn("Unsupported LNET message type", "messageType", message.MessageType)
 		return message, fmt.Errorf("unsupported LNET message type: %d", message.MessageType)
 	}
-	if err := binary.Read(*remote.Conn, remote.ByteOrder, message.LNetCommand); err != nil {
-		return message, fmt.Errorf("error reading LNET %v message: %w", message.MessageType, err)
+	if _, err := binary.Decode(header[16:], remote.ByteOrder, message.LNetCommand); err != nil {
+		return message, fmt.Errorf("error decoding LNET %v message: %w", message.MessageType, err)
+	}
+	size := reader.read - start + int(message.PayloadLength)
+	if maxSize := remote.maxMessageSize(); size > maxSize {
+		return message, &FrameTooLargeError{Size: size, Max: maxSize}
 	}
 	if message.PayloadLength > 0 {
-		message.Payload = make([]byte, message.PayloadLength)
-		if _, err := (*remote.Conn).Read(message.Payload); err != nil {
-			return message, fmt.Errorf("error reading LNET message payload: %w", err)
+		message.Payload = getBuffer(int(message.PayloadLength))
+		if err := reader.readFull("payload", message.Payload); err != nil {
+			message.release()
+			return message, err
 		}
 	}
 	return message, nil
 }
 
+// release returns the payload of a received message to the buffer pool.
+func (message *LNetMessage) release() {
+	putBuffer(message.Payload)
+	message.Payload = nil
+}
+
+// ToBytes encodes the LNet header and payload of the message.
+// The header has large NIDs (lnet_hdr_nid16) if either NID does not fit in 64 bits.
 func (message *LNetMessage) ToBytes(byteOrder binary.ByteOrder) ([]byte, error) {
+	_, destLarge := message.DestNID.(ExtendedNID)
+	_, sourceLarge := message.SourceNID.(ExtendedNID)
+	return message.encode(byteOrder, destLarge || sourceLarge)
+}
+
+// encode encodes the message with a lnet_hdr_nid16 header if large, otherwise with a lnet_hdr_nid4.
+func (message *LNetMessage) encode(byteOrder binary.ByteOrder, large bool) ([]byte, error) {
 	buf := new(bytes.Buffer)
-	if data, err := message.DestNID.ToBytes(byteOrder); err != nil {
-		return nil, fmt.Errorf("failed to write NID64: %w", err)
+	if data, err := encodeNID(message.DestNID, byteOrder, large); err != nil {
+		return nil, fmt.Errorf("failed to write destination NID: %w", err)
 	} else {
 		buf.Write(data)
 	}
-	if data, err := message.SourceNID.ToBytes(byteOrder); err != nil {
-		return nil, fmt.Errorf("failed to write NID64: %w", err)
+	if data, err := encodeNID(message.SourceNID, byteOrder, large); err != nil {
+		return nil, fmt.Errorf("failed to write source NID: %w", err)
 	} else {
 		buf.Write(data)
 	}
@@ -153,9 +193,15 @@ func (message *LNetMessage) ToBytes(byteOrder binary.ByteOrder) ([]byte, error)
 	if message.LNetCommand == nil {
 		return nil, fmt.Errorf("cannot write LNet message with nil command")
 	}
+	commandSize := binary.Size(message.LNetCommand)
+	if commandSize < 0 || commandSize > LNET_MSG_UNION_SIZE {
+		return nil, fmt.Errorf("cannot write LNet command %T of %d bytes", message.LNetCommand, commandSize)
+	}
 	if err := binary.Write(buf, byteOrder, message.LNetCommand); err != nil {
 		return nil, fmt.Errorf("failed to write LNetCommand: %w", err)
 	}
+	// The header always contains the whole message union
+	buf.Write(make([]byte, LNET_MSG_UNION_SIZE-commandSize))
 	if err := binary.Write(buf, byteOrder, message.Payload); err != nil {
 		return nil, fmt.Errorf("failed to write Payload: %w", err)
 	}
@@ -191,11 +237,35 @@ func (message *LNetMessage) SetPayload(byteOrder binary.ByteOrder, payload any)
 	message.PayloadLength = uint32(buf.Len())
 }
 
+// HandleGet handles a GET command.
+// Pings are answered directly, other GETs are served from the matching MD in the portal table.
 func (client *LNetClient) HandleGet(ctx context.Context, remote *RemoteConn, message LNetMessage) error {
 	slog.Info("Handling GET command", "remote", remote, "message", message)
 	command := message.LNetCommand.(*LNetGetCommand)
-	if command.MatchBits == LNET_PROTO_PING_MATCHBITS {
+	if command.MatchBits == LNET_PROTO_PING_MATCHBITS && command.PortalIndex == LNET_RESERVED_PORTAL {
 		return client.HandlePing(ctx, remote, message, *command)
 	}
+	source := ProcessID{NID: message.SourceNID, PID: message.SourcePID}
+	result, err := client.Portals.Match(LNET_MD_OP_GET, command.PortalIndex, source, command.MatchBits, int(command.SourceOffset), int(command.SinkLength))
+	if err != nil {
+		// Like Lustre, unmatched GETs are dropped, and the peer times out
+		slog.Warn("dropping GET", "error", err, "portal", command.PortalIndex, "matchBits", command.MatchBits, "source", message.SourceNID)
+		return nil
+	}
+	replyMessage := message.GetReply()
+	replyMessage.Payload = result.Data()
+	if err := client.SendMessage(ctx, remote, replyMessage); err != nil {
+		return err
+	}
+	result.MD.notify(Event{
+		Type:            EVENT_GET,
+		Initiator:       source,
+		Portal:          command.PortalIndex,
+		MatchBits:       command.MatchBits,
+		RequestedLength: int(command.SinkLength),
+		Length:          result.Length,
+		Offset:          result.Offset,
+		Unlinked:        result.Unlinked,
+	})
 	return nil
 }
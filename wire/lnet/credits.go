@@ -0,0 +1,223 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Credit-based flow control (lnet_post_send_locked and lnet_post_routed_recv_locked in lib-move.c).
+
+Each send takes a tx credit from the peer NI (peer_credits) and from the local NI (credits),
+and each forwarded message takes a router buffer. When a pool runs out, its credits go
+negative and the messages queue in order until credits are returned. The lowest value
+the credits reached is kept, like the "min" credits that lnetctl shows.
+
+On receive, the messages of a peer NI are handled (or forwarded) up to peer_credits at a
+time. A peer that keeps to its credits never sends more, so a peer flooding us from many
+connections only slows itself down.
+*/
+package lnet
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Router buffers for forwarded messages (lnet's tiny_router_buffers)
+const DEFAULT_ROUTER_BUFFERS = 512
+
+// Credits are the flow control limits, like Lustre's module parameters.
+// Zero values are replaced by the defaults.
+type Credits struct {
+	// Tx credits of each peer NI, and messages of each peer NI handled at a time (peer_credits, DEFAULT_PEER_CREDITS)
+	Peer int
+	// Tx credits of each local NI (credits, DEFAULT_NI_CREDITS)
+	NI int
+	// Buffers for messages being forwarded (router buffers, DEFAULT_ROUTER_BUFFERS)
+	RouterBuffers int
+}
+
+// CreditStats is a snapshot of a credit pool.
+type CreditStats struct {
+	Max int
+	// Available credits, negative when messages are queued for them
+	Available int
+	// Lowest value Available reached
+	Min int
+	// Number of messages waiting for a credit
+	Queued int
+}
+
+func (stats CreditStats) String() string {
+	return fmt.Sprintf("%d/%d (min %d, queued %d)", stats.Available, stats.Max, stats.Min, stats.Queued)
+}
+
+// creditPool hands out credits in the order they are asked for.
+type creditPool struct {
+	mu        sync.Mutex
+	max       int
+	available int
+	min       int
+	// Closed to hand a credit to the waiting message
+	waiters []chan struct{}
+}
+
+func newCreditPool(size int) *creditPool {
+	return &creditPool{max: size, available: size, min: size}
+}
+
+// acquire takes a credit, waiting behind earlier messages if there is none.
+func (pool *creditPool) acquire(ctx context.Context) error {
+	pool.mu.Lock()
+	pool.available--
+	pool.min = min(pool.min, pool.available)
+	if pool.available >= 0 {
+		pool.mu.Unlock()
+		return nil
+	}
+	granted := make(chan struct{})
+	pool.waiters = append(pool.waiters, granted)
+	pool.mu.Unlock()
+
+	select {
+	case <-granted:
+		return nil
+	case <-ctx.Done():
+	}
+	pool.mu.Lock()
+	if i := slices.Index(pool.waiters, granted); i >= 0 {
+		pool.waiters = slices.Delete(pool.waiters, i, i+1)
+		pool.available++
+		pool.mu.Unlock()
+		return ctx.Err()
+	}
+	pool.mu.Unlock()
+	// The credit was handed to us concurrently
+	pool.release()
+	return ctx.Err()
+}
+
+// release returns a credit, handing it to the first waiting message if any.
+func (pool *creditPool) release() {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.grantLocked()
+}
+
+// resize changes the number of credits, keeping those in use.
+func (pool *creditPool) resize(size int) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	for ; pool.max < size; pool.max++ {
+		pool.grantLocked()
+	}
+	if pool.max > size {
+		pool.available -= pool.max - size
+		pool.min = min(pool.min, pool.available)
+		pool.max = size
+	}
+}
+
+// grantLocked adds a credit, handing it to the first waiting message if any.
+// After shrinking, credits in use beyond the new size are returned before messages get them.
+func (pool *creditPool) grantLocked() {
+	pool.available++
+	if len(pool.waiters) > 0 && len(pool.waiters) > -pool.available {
+		close(pool.waiters[0])
+		pool.waiters = pool.waiters[1:]
+	}
+}
+
+func (pool *creditPool) stats() CreditStats {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	return CreditStats{Max: pool.max, Available: pool.available, Min: pool.min, Queued: len(pool.waiters)}
+}
+
+// SetCredits changes the flow control limits, for existing and new peers and NIs.
+func (client *LNetClient) SetCredits(credits Credits) {
+	if credits.Peer <= 0 {
+		credits.Peer = DEFAULT_PEER_CREDITS
+	}
+	if credits.NI <= 0 {
+		credits.NI = DEFAULT_NI_CREDITS
+	}
+	if credits.RouterBuffers <= 0 {
+		credits.RouterBuffers = DEFAULT_ROUTER_BUFFERS
+	}
+	client.Peers.setCredits(credits.Peer)
+	client.NIs.setCredits(credits.NI)
+	client.routerBuffers.resize(credits.RouterBuffers)
+}
+
+// RouterBuffers returns the state of the router buffers.
+func (client *LNetClient) RouterBuffers() CreditStats {
+	return client.routerBuffers.stats()
+}
+
+func (table *PeerTable) setCredits(credits int) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	table.credits = credits
+	for _, peer := range table.peers {
+		peer.credits.resize(credits)
+		peer.rxCredits.resize(credits)
+	}
+}
+
+func (table *NITable) setCredits(credits int) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	table.credits = credits
+	for _, state := range table.states {
+		state.credits.resize(credits)
+	}
+}
+
+// takeCredits takes a tx credit from the peer NI and the local NI of the connection,
+// waiting for them if needed, and returns the function that gives them back once the message is sent.
+func (client *LNetClient) takeCredits(ctx context.Context, remote *RemoteConn) (release func(), err error) {
+	var pools []*creditPool
+	release = func() {
+		for _, pool := range pools {
+			pool.release()
+		}
+	}
+	if remote.NID != nil {
+		if peer := client.Peers.Peer(remote.NID); peer != nil {
+			if err := peer.credits.acquire(ctx); err != nil {
+				return nil, fmt.Errorf("no tx credits for peer %s: %w", remote.NID, err)
+			}
+			pools = append(pools, peer.credits)
+		}
+	}
+	if ni, ok := client.NIs.lookup(remote.LocalNID); ok {
+		client.NIs.mu.Lock()
+		state := client.NIs.states[ni]
+		client.NIs.mu.Unlock()
+		if state != nil {
+			if err := state.credits.acquire(ctx); err != nil {
+				release()
+				return nil, fmt.Errorf("no tx credits for NI %s: %w", ni, err)
+			}
+			pools = append(pools, state.credits)
+		}
+	}
+	return release, nil
+}
+
+// takeRxCredit takes a credit of the peer NI of the connection for a received message, waiting
+// while the peer has peer_credits messages being handled, and returns the function that gives it back.
+// Our own loopback messages are not limited.
+func (client *LNetClient) takeRxCredit(ctx context.Context, remote *RemoteConn) (release func(), err error) {
+	if remote.NID == nil || remote.NID.Header().Type == NETWORK_TYPE_LO {
+		return func() {}, nil
+	}
+	peer := client.Peers.Peer(remote.NID)
+	if peer == nil {
+		return func() {}, nil
+	}
+	if err := peer.rxCredits.acquire(ctx); err != nil {
+		return nil, fmt.Errorf("no rx credits for peer %s: %w", remote.NID, err)
+	}
+	return peer.rxCredits.release, nil
+}
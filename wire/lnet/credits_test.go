@@ -0,0 +1,195 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for credit-based flow control.
+*/
+package lnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCreditPool(t *testing.T) {
+	ctx := context.Background()
+	pool := newCreditPool(1)
+	if err := pool.acquire(ctx); err != nil {
+		t.Fatalf("acquire failed: %v", err)
+	}
+
+	// Messages queue in order once the credits run out
+	order := make(chan int, 2)
+	for i := range 2 {
+		go func() {
+			if err := pool.acquire(ctx); err == nil {
+				order <- i
+			}
+		}()
+		for pool.stats().Queued != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if stats := pool.stats(); stats.Available != -2 || stats.Min != -2 || stats.Queued != 2 {
+		t.Errorf("Pool is %v; expected -2/1 (min -2, queued 2)", stats)
+	}
+	for i := range 2 {
+		pool.release()
+		if got := <-order; got != i {
+			t.Errorf("Credit %d went to queued message %d; expected message %d", i, got, i)
+		}
+	}
+
+	// A cancelled wait gives its place back
+	cancelled, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := pool.acquire(cancelled); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquire returned %v; expected %v", err, context.DeadlineExceeded)
+	}
+	if stats := pool.stats(); stats.Available != 0 || stats.Queued != 0 {
+		t.Errorf("Pool is %v after a cancelled wait; expected 0/1 (queued 0)", stats)
+	}
+
+	// Shrinking keeps the credits in use, growing hands new ones out
+	pool.resize(0)
+	pool.release()
+	if stats := pool.stats(); stats.Available != 0 || stats.Max != 0 {
+		t.Errorf("Pool is %v after shrinking; expected 0/0", stats)
+	}
+	done := make(chan error)
+	go func() { done <- pool.acquire(ctx) }()
+	select {
+	case <-done:
+		t.Fatalf("acquire succeeded without credits")
+	case <-time.After(10 * time.Millisecond):
+	}
+	pool.resize(1)
+	if err := <-done; err != nil {
+		t.Errorf("acquire failed after growing: %v", err)
+	}
+}
+
+// TestCredits checks that sends to a peer wait for its tx credits.
+func TestCredits(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server := NewLNetClient()
+	nid := serveOnce(t, ctx, &server)
+	client := NewLNetClient()
+	client.SetCredits(Credits{Peer: 1})
+	remote, err := client.Dial(ctx, nid)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer remote.Close()
+	peer := client.Peers.Peer(remote.NID)
+	if stats := peer.Credits(); stats.Max != 1 || stats.Available != 1 {
+		t.Fatalf("Peer credits are %v; expected 1/1", stats)
+	}
+
+	// Hold the only credit, so that a send has to queue for it
+	release, err := client.takeCredits(ctx, remote)
+	if err != nil {
+		t.Fatalf("takeCredits failed: %v", err)
+	}
+	done := make(chan error)
+	go func() { done <- client.SendNoop(ctx, remote) }()
+	go func() {
+		_, err := client.PingRemote(ctx, remote)
+		done <- err
+	}()
+	for peer.Credits().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	<-done // the NOOP does not need a credit
+	release()
+	if err := <-done; err != nil {
+		t.Errorf("PingRemote failed: %v", err)
+	}
+	if stats := peer.Credits(); stats.Available != 1 || stats.Min != -1 {
+		t.Errorf("Peer credits are %v; expected 1/1 (min -1)", stats)
+	}
+}
+
+// TestRxCredits checks that a peer flooding us from many connections has peer_credits messages
+// handled at a time, and that other peers are still served meanwhile.
+func TestRxCredits(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	server := NewLNetServer()
+	server.Client.SetCredits(Credits{Peer: 2})
+	floodNID := mustParseNID(t, "127.0.0.2@tcp0")
+	handling := make(chan NID, 8)
+	unblock := make(chan struct{})
+	server.Client.Commands[LNET_MSG_PUT] = func(ctx context.Context, remote *RemoteConn, message LNetMessage) error {
+		handling <- remote.NID
+		if SameNID(remote.NID, floodNID) {
+			<-unblock
+		}
+		return nil
+	}
+	release := sync.OnceFunc(func() { close(unblock) })
+	go func() { _ = server.Serve(ctx, listener) }()
+	defer server.Shutdown(ctx)
+	// Before Shutdown, which waits for the handlers
+	defer release()
+	nid := mustParseNID(t, fmt.Sprintf("127.0.0.1@tcp0#%d", listener.Addr().(*net.TCPAddr).Port))
+	handled := func() NID {
+		select {
+		case nid := <-handling:
+			return nid
+		case <-ctx.Done():
+			t.Fatalf("Timed out waiting for a message to be handled")
+			return nil
+		}
+	}
+
+	// The flooding peer sends a message on each of its connections
+	flood := NewLNetClient()
+	flood.LocalAddrs = []netip.Addr{netip.MustParseAddr("127.0.0.2")}
+	for range 4 {
+		remote, err := flood.DialType(ctx, nid, SOCKLND_CONN_ANY)
+		if err != nil {
+			t.Fatalf("DialType failed: %v", err)
+		}
+		if _, err := flood.Put(ctx, remote, 0, 0, 0, 0, []byte("flood"), false); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	for range 2 {
+		handled()
+	}
+	peer := server.Client.Peers.Peer(floodNID)
+	for peer.RxCredits().Queued != 2 {
+		if ctx.Err() != nil {
+			t.Fatalf("Peer rx credits are %v; expected 2 messages queued", peer.RxCredits())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	other := NewLNetClient()
+	other.LocalAddrs = []netip.Addr{netip.MustParseAddr("127.0.0.3")}
+	if _, err := other.PutTo(ctx, nid, 0, 0, 0, 0, []byte("hello"), false); err != nil {
+		t.Fatalf("PutTo failed: %v", err)
+	}
+	if from := handled(); from.String() != "127.0.0.3@tcp0#988" {
+		t.Errorf("Handled a message from %v; expected the other peer while the flood waits", from)
+	}
+	release()
+	for range 2 {
+		handled()
+	}
+	if stats := peer.RxCredits(); stats.Available != 2 || stats.Min != -2 {
+		t.Errorf("Peer rx credits are %v; expected 2/2 (min -2)", stats)
+	}
+}
@@ -0,0 +1,305 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Outbound LNet connections (the initiator side of Negotiate).
+*/
+package lnet
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+)
+
+// lnet-idl.h: struct lnet_acceptor_connreq
+type acceptorConnRequest struct {
+	Magic   ProtocolMagic
+	Version uint32
+	NID     RawNID64
+}
+
+// lnet-idl.h: struct lnet_acceptor_connreq_v2
+type acceptorConnRequestV2 struct {
+	Magic   ProtocolMagic
+	Version uint32
+	NID     RawExtendedNID
+}
+
+// ksocklnd limits the number of IPs a peer can send in HELLO
+const maxHelloIPs = 16
+
+// Invert returns the connection type as seen by the other side of the connection.
+func (connType ConnType) Invert() ConnType {
+	switch connType {
+	case SOCKLND_CONN_BULK_IN:
+		return SOCKLND_CONN_BULK_OUT
+	case SOCKLND_CONN_BULK_OUT:
+		return SOCKLND_CONN_BULK_IN
+	}
+	return connType
+}
+
+// Dial opens an LNet connection to the peer with the given NID, through the LND of its network.
+// Incoming messages on the connection are handled until it is closed with RemoteConn.Close.
+// The NID may carry a #PORT suffix to reach peers listening on a non-default port.
+func (client *LNetClient) Dial(ctx context.Context, nid NID) (*RemoteConn, error) {
+	return client.DialType(ctx, nid, SOCKLND_CONN_ANY)
+}
+
+// DialType is like Dial, but opens a connection of the given type (from our point of view).
+// The connection is added to client.Peers. If the peer is connecting to us at the same time,
+// it may reject our connection in favour of its own, and DialType returns ErrConnRejected.
+func (client *LNetClient) DialType(ctx context.Context, nid NID, connType ConnType) (*RemoteConn, error) {
+	return client.dialType(ctx, nid, connType, LocalNI{})
+}
+
+// dialType is like DialType, but connects from the local NI (if it has an address).
+func (client *LNetClient) dialType(ctx context.Context, nid NID, connType ConnType, ni LocalNI) (*RemoteConn, error) {
+	if nid == nil || nid.IsAny() {
+		return nil, fmt.Errorf("cannot dial NID %v", nid)
+	}
+	lnd, err := client.lnd(nid.Header().Type)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", nid, err)
+	}
+	// The attempt keeps the peer in the table, and one that fails does not leave it behind
+	peer := client.Peers.lockPeer(nid)
+	peer.connecting[connType]++
+	peer.mu.Unlock()
+	remote, err := lnd.Connect(ctx, client, nid, connType, ni)
+	if err != nil {
+		peer.mu.Lock()
+		peer.connecting[connType]--
+		peer.mu.Unlock()
+		client.forgetIdle(peer)
+		return nil, err
+	}
+	client.addConn(peer, connType, remote)
+	slog.Info("LNetClient connected", "nid", nid, "version", remote.Version, "type", connType, "remote", (*remote.Conn).RemoteAddr())
+	// Handle the peer's messages (including our ACKs and REPLYs) for as long as the connection lives
+	go func() {
+		defer client.removeConn(remote)
+		if err := client.handleCommands(context.WithoutCancel(ctx), remote); err != nil {
+			slog.Debug("LNetClient connection closed", "error", err, "nid", nid)
+			// Senders on a failed connection (e.g., to a dead peer) should fail fast
+			_ = (*remote.Conn).Close()
+		}
+	}()
+	return remote, nil
+}
+
+// Initiate performs the initiator side of the LNet handshake on an established connection:
+// the acceptor connection request followed by the ksock HELLO exchange.
+// This is the counterpart of Negotiate, and is bounded by the same timeouts.
+func (client *LNetClient) Initiate(ctx context.Context, remote *RemoteConn, peer NID, connType ConnType) error {
+	localNID, err := client.localNID(remote, peer)
+	if err != nil {
+		return err
+	}
+	version := KSOCK_PROTO_V3
+	if _, ok := peer.(ExtendedNID); ok {
+		version = KSOCK_PROTO_V4
+	}
+	conn := *remote.Conn
+	err = withDeadline(ctx, remote.Timeouts.Accept, conn.SetDeadline, func() error {
+		return client.sendConnRequest(remote, peer)
+	})
+	if err != nil {
+		return err
+	}
+	err = withDeadline(ctx, remote.Timeouts.Hello, conn.SetDeadline, func() error {
+		if err := client.sendHello(remote, version, localNID, peer, connType); err != nil {
+			return err
+		}
+		return client.readHelloReply(remote, version, peer, connType)
+	})
+	if err != nil {
+		return err
+	}
+	remote.NID = peer
+	remote.LocalNID = localNID
+	return nil
+}
+
+// localNID picks the NID we present to the peer.
+// The NI the connection was made from is preferred, then another NI on the peer's network,
+// then known local addresses, falling back to the address of the connection.
+func (client *LNetClient) localNID(remote *RemoteConn, peer NID) (NID, error) {
+	header := peer.Header()
+	if header.Type == NETWORK_TYPE_UNIX {
+		return client.unixNID(header.NetworkIndex)
+	}
+	if tcpAddr, ok := (*remote.Conn).LocalAddr().(*net.TCPAddr); ok {
+		addr := tcpAddr.AddrPort().Addr().Unmap()
+		for _, ni := range client.NIs.NIs() {
+			if ni.NetType == header.Type && ni.NetNum == header.NetworkIndex && ni.Addr == addr {
+				return ni.NID(client.Port)
+			}
+		}
+	}
+	for _, ni := range client.NIs.NIs() {
+		if ni.NetType == header.Type && ni.NetNum == header.NetworkIndex && ni.Addr.Is4() == peer.NetAddr().Is4() {
+			return ni.NID(client.Port)
+		}
+	}
+	for _, addr := range client.LocalAddrs {
+		if addr.Is4() == peer.NetAddr().Is4() {
+			return NIDFromAddr(addr, header.Type, header.NetworkIndex, client.Port)
+		}
+	}
+	tcpAddr, ok := (*remote.Conn).LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("cannot determine local NID from address %v", (*remote.Conn).LocalAddr())
+	}
+	addr := tcpAddr.AddrPort().Addr().Unmap()
+	if _, ok := peer.(ExtendedNID); ok && addr.Is4() {
+		addr = netip.AddrFrom16(addr.As16())
+	}
+	return NIDFromAddr(addr, header.Type, header.NetworkIndex, client.Port)
+}
+
+// sendConnRequest sends the acceptor connection request for the peer NID.
+// Lustre's acceptor uses the NID to check that the connection is meant for it.
+func (client *LNetClient) sendConnRequest(remote *RemoteConn, peer NID) error {
+	var request any
+	switch nid := peer.(type) {
+	case NID64:
+		request = acceptorConnRequest{Magic: PROTO_MAGIC_ACCEPTOR, Version: ACCEPTOR_VERSION_1, NID: nid.Raw()}
+	case ExtendedNID:
+		request = acceptorConnRequestV2{Magic: PROTO_MAGIC_ACCEPTOR, Version: ACCEPTOR_VERSION_2, NID: nid.Raw()}
+	default:
+		return fmt.Errorf("unsupported NID type for connection request: %T", peer)
+	}
+	if err := binary.Write(*remote.Conn, remote.ByteOrder, request); err != nil {
+		return fmt.Errorf("failed to write acceptor connection request: %w", err)
+	}
+	return nil
+}
+
+// sendHello sends the ksock HELLO message.
+// Since socklnd protocol version 2, HELLO uses the generic protocol magic.
+func (client *LNetClient) sendHello(remote *RemoteConn, version uint32, localNID NID, peer NID, connType ConnType) error {
+	tail := helloResponseCommonTail{
+		SourcePID:         client.PID,
+		DestPID:           PID_LUSTRE,
+		SourceIncarnation: client.Incarnation,
+		ConnType:          connType,
+	}
+	var hello any
+	switch version {
+	case KSOCK_PROTO_V2, KSOCK_PROTO_V3:
+		source, sourceOk := localNID.(NID64)
+		dest, destOk := peer.(NID64)
+		if !sourceOk || !destOk {
+			return fmt.Errorf("protocol version %d requires NID64 addresses", version)
+		}
+		hello = helloResponseV2{
+			Magic:                   PROTO_MAGIC_GENERIC,
+			ProtoVersion:            version,
+			SourceNID:               source.Raw(),
+			DestNID:                 dest.Raw(),
+			helloResponseCommonTail: tail,
+		}
+	case KSOCK_PROTO_V4:
+		source, sourceOk := localNID.(ExtendedNID)
+		dest, destOk := peer.(ExtendedNID)
+		if !sourceOk || !destOk {
+			return fmt.Errorf("protocol version %d requires ExtendedNID addresses", version)
+		}
+		hello = helloResponse{
+			Magic:                   PROTO_MAGIC_GENERIC,
+			ProtoVersion:            version,
+			SourceNID:               source.Raw(),
+			DestNID:                 dest.Raw(),
+			helloResponseCommonTail: tail,
+		}
+	default:
+		return fmt.Errorf("unsupported protocol version: %d", version)
+	}
+	if err := binary.Write(*remote.Conn, remote.ByteOrder, hello); err != nil {
+		return fmt.Errorf("failed to write hello in protocol version %d: %w", version, err)
+	}
+	return nil
+}
+
+// readHelloReply reads and validates the HELLO sent back by the peer.
+func (client *LNetClient) readHelloReply(remote *RemoteConn, version uint32, peer NID, connType ConnType) error {
+	var protocolMagic ProtocolMagic
+	if err := binary.Read(*remote.Conn, remote.ByteOrder, &protocolMagic); err != nil {
+		return fmt.Errorf("failed to read hello magic: %w", err)
+	}
+	switch protocolMagic {
+	case PROTO_MAGIC_GENERIC:
+	case PROTO_MAGIC_GENERIC_REV:
+		slog.Info("Detected reverse byte order from remote, switching byte order for this connection")
+		remote.ByteOrder = GetOppositeByteOrder(remote.ByteOrder)
+	case PROTO_MAGIC_TCP, ProtocolMagic(Swab32(uint32(PROTO_MAGIC_TCP))):
+		return fmt.Errorf("peer replied with socklnd protocol version 1, which is unsupported")
+	default:
+		return fmt.Errorf("invalid hello magic: expected 0x%08x, got 0x%08x", PROTO_MAGIC_GENERIC, protocolMagic)
+	}
+	remote.Protocol = PROTO_MAGIC_TCP
+
+	var peerVersion uint32
+	if err := binary.Read(*remote.Conn, remote.ByteOrder, &peerVersion); err != nil {
+		return fmt.Errorf("failed to read hello version: %w", err)
+	}
+	if peerVersion != version {
+		return fmt.Errorf("peer replied with protocol version %d, expected %d", peerVersion, version)
+	}
+	remote.Version = peerVersion
+
+	var sourceNID NID
+	switch version {
+	case KSOCK_PROTO_V2, KSOCK_PROTO_V3:
+		var rawNIDs [2]RawNID64
+		if err := binary.Read(*remote.Conn, remote.ByteOrder, &rawNIDs); err != nil {
+			return fmt.Errorf("failed to read hello NIDs in protocol version %d: %w", version, err)
+		}
+		sourceNID = rawNIDs[0].ToNID64()
+	case KSOCK_PROTO_V4:
+		var rawNIDs [2]RawExtendedNID
+		if err := binary.Read(*remote.Conn, remote.ByteOrder, &rawNIDs); err != nil {
+			return fmt.Errorf("failed to read hello NIDs in protocol version %d: %w", version, err)
+		}
+		nid, err := rawNIDs[0].ToNID()
+		if err != nil {
+			return fmt.Errorf("invalid source NID in hello: %w", err)
+		}
+		sourceNID = nid
+	}
+
+	var commonTail helloResponseCommonTail
+	if err := binary.Read(*remote.Conn, remote.ByteOrder, &commonTail); err != nil {
+		return fmt.Errorf("failed to read common tail: %w", err)
+	}
+	if commonTail.NIPs > maxHelloIPs {
+		return fmt.Errorf("peer sent too many IPs in hello: %d", commonTail.NIPs)
+	}
+	// Older peers advertise their IPs, which we have no use for
+	ips := make([]uint32, commonTail.NIPs)
+	if err := binary.Read(*remote.Conn, remote.ByteOrder, ips); err != nil {
+		return fmt.Errorf("failed to read hello IPs: %w", err)
+	}
+
+	if commonTail.ConnType == SOCKLND_CONN_NONE {
+		return ErrConnRejected
+	}
+	if commonTail.ConnType != connType.Invert() {
+		return fmt.Errorf("peer replied with connection type %v, expected %v", commonTail.ConnType, connType.Invert())
+	}
+	if !SameNID(sourceNID, peer) {
+		return fmt.Errorf("connected to %s, but peer claims to be %s", peer, sourceNID)
+	}
+	if commonTail.DestIncarnation != 0 && commonTail.DestIncarnation != client.Incarnation {
+		slog.Warn("peer replied with a different incarnation", "expected", client.Incarnation, "got", commonTail.DestIncarnation)
+	}
+	remote.PID = commonTail.SourcePID
+	remote.Incarnation = commonTail.SourceIncarnation
+	remote.ConnType = connType
+	return nil
+}
@@ -0,0 +1,124 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for outbound LNet connections.
+*/
+package lnet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// acceptOnce runs the responder side of the handshake for a single connection.
+func acceptOnce(t *testing.T, ctx context.Context, listener net.Listener) <-chan *RemoteConn {
+	t.Helper()
+	accepted := make(chan *RemoteConn, 1)
+	go func() {
+		defer close(accepted)
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Errorf("Accept failed: %v", err)
+			return
+		}
+		remote := RemoteConn{Conn: &conn, ByteOrder: DEFAULT_BYTE_ORDER}
+		if err := Negotiate(ctx, &remote); err != nil {
+			t.Errorf("Negotiate failed: %v", err)
+			return
+		}
+		accepted <- &remote
+	}()
+	return accepted
+}
+
+// serveOnce accepts a single connection and handles its messages with the server client.
+// It returns the NID to dial.
+func serveOnce(t *testing.T, ctx context.Context, server *LNetClient) NID {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	accepted := acceptOnce(t, ctx, listener)
+	go func() {
+		if peer := <-accepted; peer != nil {
+			_ = server.handleCommands(ctx, peer)
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+	nid, err := ParseNID(fmt.Sprintf("127.0.0.1@tcp0#%d", port))
+	if err != nil {
+		t.Fatalf("ParseNID failed: %v", err)
+	}
+	return nid
+}
+
+func testDial(t *testing.T, client LNetClient) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer listener.Close()
+	accepted := acceptOnce(t, ctx, listener)
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	nid, err := ParseNID(fmt.Sprintf("127.0.0.1@tcp0#%d", port))
+	if err != nil {
+		t.Fatalf("ParseNID failed: %v", err)
+	}
+	remote, err := client.Dial(ctx, nid)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer remote.Close()
+	if remote.Version != KSOCK_PROTO_V3 {
+		t.Errorf("expected protocol version %d, got %d", KSOCK_PROTO_V3, remote.Version)
+	}
+	if !SameNID(remote.NID, nid) {
+		t.Errorf("expected remote NID %s, got %s", nid, remote.NID)
+	}
+	if remote.ByteOrder != client.ByteOrder {
+		t.Errorf("expected byte order to stay %v, got %v", client.ByteOrder, remote.ByteOrder)
+	}
+	peer := <-accepted
+	if peer == nil {
+		t.Fatal("responder did not complete negotiation")
+	}
+	if peer.Incarnation != client.Incarnation {
+		t.Errorf("responder saw incarnation %d, expected %d", peer.Incarnation, client.Incarnation)
+	}
+}
+
+func TestDial(t *testing.T) {
+	testDial(t, NewLNetClient())
+}
+
+func TestDialOppositeByteOrder(t *testing.T) {
+	client := NewLNetClient()
+	client.ByteOrder = GetOppositeByteOrder(client.ByteOrder)
+	testDial(t, client)
+}
+
+func TestConnTypeInvert(t *testing.T) {
+	var tests = []struct {
+		input    ConnType
+		expected ConnType
+	}{
+		{SOCKLND_CONN_ANY, SOCKLND_CONN_ANY},
+		{SOCKLND_CONN_CONTROL, SOCKLND_CONN_CONTROL},
+		{SOCKLND_CONN_BULK_IN, SOCKLND_CONN_BULK_OUT},
+		{SOCKLND_CONN_BULK_OUT, SOCKLND_CONN_BULK_IN},
+	}
+	for _, test := range tests {
+		if test.input.Invert() != test.expected {
+			t.Errorf("ConnType(%d).Invert() = %d; expected %d", test.input, test.input.Invert(), test.expected)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Dynamic peer discovery (lib-peer.c).
+
+Discovery pulls the ping buffer of a peer to learn all its NIDs, which become one multi-rail peer.
+If both sides support PING_FEATURE_DISCOVERY, each side pushes its ping buffer to the other:
+a PUT to the reserved portal with the ping match bits. Pushes are sent again whenever the NIs change.
+*/
+package lnet
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+)
+
+// Discover pulls the ping buffer of the peer with the given NID, and records its NIDs
+// as one multi-rail peer. If both sides support discovery, our NIDs are pushed to the peer.
+func (client *LNetClient) Discover(ctx context.Context, nid NID) (*MultiRailPeer, error) {
+	peer := client.Peers.peer(nid)
+	peer.startDiscovery()
+	ping, err := client.PingNID(ctx, nid)
+	if err != nil {
+		client.forgetIdle(peer)
+		return nil, fmt.Errorf("failed to discover %s: %w", nid, err)
+	}
+	return client.discovered(ctx, ping, nid)
+}
+
+// discovered records the ping buffer pulled from the peer with the given NID as one multi-rail peer,
+// and pushes our NIDs to it if both sides support discovery.
+func (client *LNetClient) discovered(ctx context.Context, ping PingResponse, nid NID) (*MultiRailPeer, error) {
+	mrPeer, err := client.Peers.mergePeer(ping, nid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover %s: %w", nid, err)
+	}
+	slog.Info("discovered peer", "nid", nid, "primary", mrPeer.Primary(), "nids", mrPeer.NIDs(), "features", mrPeer.Features())
+	if client.Discovery && mrPeer.Features()&PING_FEATURE_DISCOVERY != 0 {
+		if err := client.pushTo(ctx, nid); err != nil {
+			return mrPeer, err
+		}
+	}
+	return mrPeer, nil
+}
+
+// Push sends our ping buffer to the remote, so that it learns our current NIDs.
+func (client *LNetClient) Push(ctx context.Context, remote *RemoteConn) error {
+	return client.push(ctx, remote, remote.NID)
+}
+
+// pushTo pushes our ping buffer to the peer with the given NID, which may be on a remote network.
+func (client *LNetClient) pushTo(ctx context.Context, nid NID) error {
+	return client.resend(ctx, nid, 0, func(ctx context.Context, remote *RemoteConn, dest NID) error {
+		return client.push(ctx, remote, dest)
+	})
+}
+
+// push sends our ping buffer to dest over the connection to the remote.
+func (client *LNetClient) push(ctx context.Context, remote *RemoteConn, dest NID) error {
+	ping := client.pingBuffer(remote, client.PID)
+	data, err := ping.ToBytes(remote.ByteOrder)
+	if err != nil {
+		return fmt.Errorf("failed to encode discovery push: %w", err)
+	}
+	if _, err := client.put(ctx, remote, dest, LNET_RESERVED_PORTAL, LNET_PROTO_PING_MATCHBITS, 0, 0, data, true); err != nil {
+		return fmt.Errorf("failed to push to %s: %w", dest, err)
+	}
+	return nil
+}
+
+// HandlePush handles a discovery push (a PUT of the peer's ping buffer), and acknowledges it.
+// Without client.Discovery, the push is acknowledged but ignored.
+func (client *LNetClient) HandlePush(ctx context.Context, remote *RemoteConn, message LNetMessage) error {
+	command := message.LNetCommand.(*LNetPutCommand)
+	if client.Discovery {
+		var ping PingResponse
+		if err := ping.FromBytes(message.Payload, remote.ByteOrder); err != nil {
+			slog.Warn("dropping invalid discovery push", "error", err, "source", message.SourceNID)
+			return nil
+		}
+		// NIDs on the wire have no port: a peer we dialed on another port likely listens on it for all NIDs
+		from := message.SourceNID
+		if port := NIDPort(remote.NID); port != DEFAULT_PORT && client.samePeer(from, remote.NID) {
+			from = withPort(from, port)
+		}
+		mrPeer, err := client.Peers.mergePeer(ping, from)
+		if err != nil {
+			slog.Warn("dropping discovery push", "error", err, "source", message.SourceNID)
+			return nil
+		}
+		slog.Info("peer pushed its NIDs", "primary", mrPeer.Primary(), "nids", mrPeer.NIDs())
+	}
+	if MDHandle(command.AckWMD.ObjectCookie) == MD_HANDLE_NONE {
+		return nil
+	}
+	ack := &LNetAckCommand{
+		DestWMD:       command.AckWMD,
+		MatchBits:     command.MatchBits,
+		MessageLength: uint32(len(message.Payload)),
+	}
+	ackMessage := client.newMessage(remote, LNET_MSG_ACK, ack, nil)
+	ackMessage.DestNID, ackMessage.DestPID = message.SourceNID, message.SourcePID
+	ackMessage.SourceNID = message.DestNID
+	return client.SendMessage(ctx, remote, ackMessage)
+}
+
+// samePeer reports whether both NIDs belong to the same peer, as far as we know.
+func (client *LNetClient) samePeer(a, b NID) bool {
+	if SameNID(a, b) {
+		return true
+	}
+	mrPeer := client.Peers.MultiRailPeer(a)
+	return mrPeer != nil && mrPeer == client.Peers.MultiRailPeer(b)
+}
+
+// RunDiscovery pushes our ping buffer to the discovered peers whenever our NIs change,
+// until ctx is cancelled.
+func (client *LNetClient) RunDiscovery(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-client.NIs.Changed():
+		}
+		for _, mrPeer := range client.Peers.MultiRailPeers() {
+			if mrPeer.Features()&PING_FEATURE_DISCOVERY == 0 {
+				continue
+			}
+			pushCtx, cancel := context.WithTimeout(ctx, client.TransactionTimeout)
+			err := client.pushTo(pushCtx, mrPeer.Primary())
+			cancel()
+			if err != nil {
+				slog.Warn("failed to push NI change", "peer", mrPeer.Primary(), "error", err)
+			}
+		}
+	}
+}
+
+// discoverNew starts discovery of a peer that just connected, unless it is known already,
+// it is ourselves on the loopback network, or it is on this node behind a unix socket.
+// The peer is pinged over the new connection, as one that only connected to us may not listen,
+// and only recorded if it takes part in discovery (PING_FEATURE_DISCOVERY): others, such as
+// remote-ping probes, stay plain peer NIs, which are forgotten once they disconnect.
+func (client *LNetClient) discoverNew(remote *RemoteConn) {
+	peer := client.Peers.Peer(remote.NID)
+	if peer == nil {
+		return
+	}
+	netType := peer.NID.Header().Type
+	if !client.Discovery || netType == NETWORK_TYPE_LO || netType == NETWORK_TYPE_UNIX ||
+		client.Peers.MultiRailPeer(peer.NID) != nil || !peer.startDiscovery() {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), client.TransactionTimeout)
+		defer cancel()
+		ping, err := client.PingRemote(ctx, remote)
+		if err != nil {
+			slog.Debug("discovery of new peer failed", "nid", peer.NID, "error", err)
+			return
+		}
+		if PingFeature(ping.Features)&PING_FEATURE_DISCOVERY == 0 {
+			slog.Debug("new peer does not take part in discovery", "nid", peer.NID, "features", PingFeature(ping.Features))
+			return
+		}
+		if _, err := client.discovered(ctx, ping, peer.NID); err != nil {
+			slog.Debug("discovery of new peer failed", "nid", peer.NID, "error", err)
+		}
+	}()
+}
+
+// startDiscovery marks the peer NI as discovered, and reports whether it was not already.
+func (peer *Peer) startDiscovery() bool {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	if peer.discovered {
+		return false
+	}
+	peer.discovered = true
+	return true
+}
+
+// MultiRailPeers returns all multi-rail peers.
+func (table *PeerTable) MultiRailPeers() []*MultiRailPeer {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	var mrPeers []*MultiRailPeer
+	for _, mrPeer := range table.multiRail {
+		if !slices.Contains(mrPeers, mrPeer) {
+			mrPeers = append(mrPeers, mrPeer)
+		}
+	}
+	return mrPeers
+}
+
+// mergePeer records the NIDs of a ping buffer (and the NID it came from) as one multi-rail peer.
+// NIDs that other peers had are moved to it, and NIDs the peer no longer lists are dropped.
+// Unix NIDs whose path we do not know are left out, as they cannot be reached (see UnixLND.Connect).
+func (table *PeerTable) mergePeer(ping PingResponse, from NID) (*MultiRailPeer, error) {
+	var nids []NID
+	add := func(nid NID) {
+		if nid == nil || nid.IsAny() || nid.Header().Type == NETWORK_TYPE_LO {
+			return
+		}
+		if _, ok := UnixPath(nid); nid.Header().Type == NETWORK_TYPE_UNIX && !ok {
+			return
+		}
+		if !slices.ContainsFunc(nids, func(other NID) bool { return SameNID(other, nid) }) {
+			nids = append(nids, nid)
+		}
+	}
+	add(ping.Primary())
+	for _, status := range ping.NIDStatuses {
+		add(status.NID)
+	}
+	add(from)
+	if len(nids) == 0 {
+		return nil, fmt.Errorf("ping buffer of %s has no NIDs", from)
+	}
+	// Ping buffers have no ports: a peer we reached on another port likely listens on it for all NIDs
+	if port := NIDPort(from); port != DEFAULT_PORT {
+		for i, nid := range nids {
+			if NIDPort(nid) == DEFAULT_PORT {
+				nids[i] = withPort(nid, port)
+			}
+		}
+	}
+	primary := nids[0]
+
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	// The peer may be known under its primary NID, or any other
+	var mrPeer *MultiRailPeer
+	for _, nid := range nids {
+		if mrPeer = table.multiRail[peerKey(nid)]; mrPeer != nil {
+			break
+		}
+	}
+	if mrPeer == nil {
+		mrPeer = &MultiRailPeer{}
+	}
+	mrPeer.mu.Lock()
+	defer mrPeer.mu.Unlock()
+	for _, nid := range mrPeer.nids {
+		delete(table.multiRail, peerKey(nid))
+	}
+	for _, nid := range nids {
+		if other := table.multiRail[peerKey(nid)]; other != nil {
+			other.removeNID(nid)
+		}
+		table.multiRail[peerKey(nid)] = mrPeer
+	}
+	mrPeer.primary = primary
+	mrPeer.nids = nids
+	mrPeer.features = PingFeature(ping.Features)
+	return mrPeer, nil
+}
+
+// removeNID drops a NID that moved to another peer.
+func (mrPeer *MultiRailPeer) removeNID(nid NID) {
+	mrPeer.mu.Lock()
+	defer mrPeer.mu.Unlock()
+	mrPeer.nids = slices.DeleteFunc(mrPeer.nids, func(other NID) bool { return SameNID(other, nid) })
+}
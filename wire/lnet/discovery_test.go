@@ -0,0 +1,123 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for peer discovery.
+*/
+package lnet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+// nidAddrs lists the addresses and networks of NIDs, ignoring ports.
+func nidAddrs(nids []NID) []string {
+	var addrs []string
+	for _, nid := range nids {
+		header := nid.Header()
+		addrs = append(addrs, fmt.Sprintf("%s@%s%d", nid.NetAddr(), header.Type, header.NetworkIndex))
+	}
+	return addrs
+}
+
+func TestMergePeer(t *testing.T) {
+	table := NewPeerTable()
+	ping := func(nids ...string) PingResponse {
+		ping := PingResponse{PingHeader: PingHeader{Features: uint32(PING_FEATURE_DISCOVERY)}}
+		ping.NIDStatuses = append(ping.NIDStatuses, NIDStatus{NID: NID64{NIDHeader: NIDHeader{Type: NETWORK_TYPE_LO}}})
+		for _, nid := range nids {
+			ping.NIDStatuses = append(ping.NIDStatuses, NIDStatus{NID: mustParseNID(t, nid), Status: PING_NI_STATUS_UP})
+		}
+		return ping
+	}
+	first, err := table.mergePeer(ping("10.0.0.1@tcp0"), mustParseNID(t, "10.0.0.1@tcp0"))
+	if err != nil {
+		t.Fatalf("mergePeer failed: %v", err)
+	}
+	// The peer now also has a NID we knew as another peer, and came to us from a third one
+	if _, err := table.AddPeerNIDs(mustParseNID(t, "10.1.0.1@tcp1")); err != nil {
+		t.Fatalf("AddPeerNIDs failed: %v", err)
+	}
+	merged, err := table.mergePeer(ping("10.0.0.1@tcp0", "10.1.0.1@tcp1"), mustParseNID(t, "10.2.0.1@tcp2"))
+	if err != nil {
+		t.Fatalf("mergePeer failed: %v", err)
+	}
+	if merged != first || table.MultiRailPeer(mustParseNID(t, "10.1.0.1@tcp1")) != first {
+		t.Errorf("mergePeer did not merge into the known peer")
+	}
+	expected := []string{"10.0.0.1@tcp0", "10.1.0.1@tcp1", "10.2.0.1@tcp2"}
+	if nids := nidAddrs(merged.NIDs()); !slices.Equal(nids, expected) {
+		t.Errorf("Merged peer has NIDs %v; expected %v", nids, expected)
+	}
+	// NIDs the peer no longer lists are dropped
+	merged, _ = table.mergePeer(ping("10.1.0.1@tcp1"), mustParseNID(t, "10.1.0.1@tcp1"))
+	if nids := nidAddrs(merged.NIDs()); !slices.Equal(nids, []string{"10.1.0.1@tcp1"}) || table.MultiRailPeer(mustParseNID(t, "10.0.0.1@tcp0")) != nil {
+		t.Errorf("Merged peer has NIDs %v; expected only 10.1.0.1@tcp1", nids)
+	}
+	if len(table.MultiRailPeers()) != 1 {
+		t.Errorf("Table has %d multi-rail peers; expected 1", len(table.MultiRailPeers()))
+	}
+}
+
+// TestDiscover checks that two multi-rail nodes learn each other's NIDs by pull and push.
+func TestDiscover(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	port := uint16(free.Addr().(*net.TCPAddr).Port)
+	free.Close()
+
+	server := NewLNetServer().WithPort(port)
+	server.Client.Discovery = true
+	server.Client.NIs.Set([]LocalNI{testNI(0, "127.0.0.1"), testNI(1, "127.0.0.2")})
+	go func() { _ = server.Listen(ctx) }()
+	defer server.Shutdown(ctx)
+
+	client := NewLNetClient()
+	client.Discovery = true
+	client.NIs.Set([]LocalNI{testNI(0, "127.0.0.3"), testNI(1, "127.0.0.4")})
+	go func() { _ = client.RunDiscovery(ctx) }()
+	serverNID := mustParseNID(t, fmt.Sprintf("127.0.0.1@tcp0#%d", port))
+	var mrPeer *MultiRailPeer
+	for {
+		if mrPeer, err = client.Discover(ctx, serverNID); err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("Discover failed: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	expected := []string{"127.0.0.1@tcp0", "127.0.0.2@tcp1"}
+	if nids := nidAddrs(mrPeer.NIDs()); !slices.Equal(nids, expected) || mrPeer.Features()&PING_FEATURE_DISCOVERY == 0 {
+		t.Errorf("Discovered NIDs %v with features %v; expected %v with DISCOVERY", nids, mrPeer.Features(), expected)
+	}
+
+	// waitForPush waits until the server knows the client by the expected NIDs
+	waitForPush := func(expected []string) {
+		for {
+			var nids []string
+			if mrPeer := server.Client.Peers.MultiRailPeer(mustParseNID(t, "127.0.0.3@tcp0")); mrPeer != nil {
+				nids = nidAddrs(mrPeer.NIDs())
+				if slices.Equal(nids, expected) {
+					return
+				}
+			}
+			if ctx.Err() != nil {
+				t.Fatalf("Server knows the client by %v; expected %v", nids, expected)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitForPush([]string{"127.0.0.3@tcp0", "127.0.0.4@tcp1"})
+	client.NIs.Set([]LocalNI{testNI(0, "127.0.0.3"), testNI(1, "127.0.0.5")})
+	waitForPush([]string{"127.0.0.3@tcp0", "127.0.0.5@tcp1"})
+}
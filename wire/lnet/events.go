@@ -0,0 +1,150 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+LNet events, reported to the owner of a memory descriptor.
+
+Events of one MD are delivered one at a time, in the order the operations happened:
+  - an outgoing PUT (PutMD) reports SEND once it is written, then ACK if one was requested;
+  - an outgoing GET (GetMD) reports SEND once it is written, then REPLY;
+  - an MD attached to a portal reports a PUT or GET for each incoming request it matched.
+
+A SEND with a Status is the last event of its operation: no ACK or REPLY follows.
+The last event of an MD has Unlinked set, and no event follows it. UNLINK is only reported
+when UnlinkMD removes an MD with no outstanding operation; an ACK or REPLY that an
+outstanding operation still waits for is reported instead, with ErrMDUnlinked as Status.
+*/
+package lnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// The MD was unlinked before its operation completed
+var ErrMDUnlinked = errors.New("memory descriptor unlinked")
+
+type EventType uint32
+
+// lnet-types.h
+const (
+	// A peer fetched data from the MD
+	EVENT_GET EventType = iota + 1
+	// A peer stored data into the MD
+	EVENT_PUT
+	// The REPLY to our GET was stored into the MD
+	EVENT_REPLY
+	// The peer acknowledged our PUT from the MD
+	EVENT_ACK
+	// Our PUT or GET from the MD was written to the connection
+	EVENT_SEND
+	// The MD was unlinked
+	EVENT_UNLINK
+)
+
+func (eventType EventType) String() string {
+	switch eventType {
+	case EVENT_GET:
+		return "GET"
+	case EVENT_PUT:
+		return "PUT"
+	case EVENT_REPLY:
+		return "REPLY"
+	case EVENT_ACK:
+		return "ACK"
+	case EVENT_SEND:
+		return "SEND"
+	case EVENT_UNLINK:
+		return "UNLINK"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint32(eventType))
+	}
+}
+
+// Event describes an operation on a memory descriptor (struct lnet_event).
+type Event struct {
+	Type EventType
+	// The process that started the operation
+	Initiator ProcessID
+	// The process the operation was sent to (for SEND)
+	Target    ProcessID
+	Portal    uint32
+	MatchBits uint64
+	// Length requested by the initiator
+	RequestedLength int
+	// Length actually moved, and where in the MD
+	Length     int
+	Offset     int
+	HeaderData uint64
+	MD         *MemoryDescriptor
+	// The MD was unlinked by this operation
+	Unlinked bool
+	// Error, if the operation failed
+	Status error
+}
+
+// EventHandler is called for each event on an MD.
+// It runs on the connection's goroutine, so it must not block.
+type EventHandler func(Event)
+
+// notify passes the event to the MD's handler, if any, one event at a time.
+func (md *MemoryDescriptor) notify(event Event) {
+	if md.Handler != nil {
+		md.eventMu.Lock()
+		defer md.eventMu.Unlock()
+		event.MD = md
+		md.Handler(event)
+	}
+}
+
+// EventQueue collects events for callers that wait for them rather than handle them
+// as they happen (lnet_eq). Events of all MDs that use the queue's Handler are queued
+// in the order they are delivered.
+type EventQueue struct {
+	events  chan Event
+	dropped atomic.Int64
+}
+
+// NewEventQueue creates an event queue that holds up to size events.
+func NewEventQueue(size int) *EventQueue {
+	return &EventQueue{events: make(chan Event, max(size, 1))}
+}
+
+// Handler returns the handler that queues events, for MemoryDescriptor.Handler.
+// As handlers must not block, events that do not fit into a full queue are dropped.
+func (eq *EventQueue) Handler() EventHandler {
+	return func(event Event) {
+		select {
+		case eq.events <- event:
+		default:
+			eq.dropped.Add(1)
+		}
+	}
+}
+
+// Get returns the next event, if there is one (LNetEQGet).
+func (eq *EventQueue) Get() (Event, bool) {
+	select {
+	case event := <-eq.events:
+		return event, true
+	default:
+		return Event{}, false
+	}
+}
+
+// Wait returns the next event, waiting for it until ctx is done (LNetEQPoll).
+func (eq *EventQueue) Wait(ctx context.Context) (Event, error) {
+	select {
+	case event := <-eq.events:
+		return event, nil
+	case <-ctx.Done():
+		return Event{}, ctx.Err()
+	}
+}
+
+// Dropped returns the number of events dropped because the queue was full.
+func (eq *EventQueue) Dropped() int {
+	return int(eq.dropped.Load())
+}
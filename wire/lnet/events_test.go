@@ -0,0 +1,129 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for LNet events and event queues.
+*/
+package lnet
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEventQueue(t *testing.T) {
+	eq := NewEventQueue(2)
+	handler := eq.Handler()
+	for _, eventType := range []EventType{EVENT_SEND, EVENT_ACK, EVENT_UNLINK} {
+		handler(Event{Type: eventType})
+	}
+	if eq.Dropped() != 1 {
+		t.Errorf("Queue dropped %d events; expected 1", eq.Dropped())
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if event, err := eq.Wait(ctx); err != nil || event.Type != EVENT_SEND {
+		t.Errorf("Wait returned %v, %v; expected SEND", event.Type, err)
+	}
+	if event, ok := eq.Get(); !ok || event.Type != EVENT_ACK {
+		t.Errorf("Get returned %v, %v; expected ACK", event.Type, ok)
+	}
+	if _, ok := eq.Get(); ok {
+		t.Errorf("Get returned an event from an empty queue")
+	}
+	if _, err := eq.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait returned %v; expected %v", err, context.DeadlineExceeded)
+	}
+}
+
+// waitEvents returns the next count events of the queue.
+func waitEvents(t *testing.T, ctx context.Context, eq *EventQueue, count int) []Event {
+	t.Helper()
+	events := make([]Event, count)
+	for i := range events {
+		event, err := eq.Wait(ctx)
+		if err != nil {
+			t.Fatalf("Wait for event %d failed: %v", i, err)
+		}
+		events[i] = event
+	}
+	return events
+}
+
+// TestOperationEvents checks the events of PutMD, GetMD and UnlinkMD, and their order.
+func TestOperationEvents(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server := NewLNetClient()
+	sink := &MemoryDescriptor{Buffer: make([]byte, 8), Threshold: LNET_MD_THRESH_INF, Options: LNET_MD_OP_PUT | LNET_MD_OP_GET | LNET_MD_MANAGE_REMOTE}
+	if _, err := server.Portals.Attach(10, &MatchEntry{MatchID: AnyProcess, MatchBits: 42}, sink, true); err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+	nid := serveOnce(t, ctx, &server)
+	client := NewLNetClient()
+	remote, err := client.Dial(ctx, nid)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer remote.Close()
+	eq := NewEventQueue(8)
+
+	// PUT: SEND, then the ACK, which unlinks the MD
+	md := &MemoryDescriptor{Buffer: []byte("glimmer"), Handler: eq.Handler()}
+	if err := client.PutMD(ctx, nid, md, 10, 42, 0, 7, true); err != nil {
+		t.Fatalf("PutMD failed: %v", err)
+	}
+	events := waitEvents(t, ctx, eq, 2)
+	if send := events[0]; send.Type != EVENT_SEND || send.Status != nil || send.Length != 7 || send.Unlinked || send.MD != md || !SameNID(send.Target.NID, nid) {
+		t.Errorf("First event is %+v; expected SEND of 7 bytes to %s", send, nid)
+	}
+	if ack := events[1]; ack.Type != EVENT_ACK || ack.Status != nil || ack.Length != 7 || !ack.Unlinked {
+		t.Errorf("Second event is %+v; expected ACK of 7 bytes that unlinks the MD", ack)
+	}
+	if _, ok := client.Portals.Lookup(md.Handle()); ok {
+		t.Errorf("MD is still bound after its last event")
+	}
+
+	// GET: SEND, then the REPLY with the data
+	md = &MemoryDescriptor{Buffer: make([]byte, 4), Handler: eq.Handler()}
+	if err := client.GetMD(ctx, nid, md, 10, 42, 0); err != nil {
+		t.Fatalf("GetMD failed: %v", err)
+	}
+	events = waitEvents(t, ctx, eq, 2)
+	if events[0].Type != EVENT_SEND || events[1].Type != EVENT_REPLY || events[1].Length != 4 || !events[1].Unlinked || string(md.Buffer) != "glim" {
+		t.Errorf("Events are %v and %+v with %q; expected SEND, then REPLY of \"glim\"", events[0].Type, events[1], md.Buffer)
+	}
+
+	// An unmatched PUT gets no ACK: unlinking reports the missing ACK, and no UNLINK follows
+	md = &MemoryDescriptor{Buffer: []byte("lost"), Handler: eq.Handler()}
+	if err := client.PutMD(ctx, nid, md, 10, 43, 0, 0, true); err != nil {
+		t.Fatalf("PutMD failed: %v", err)
+	}
+	if send := waitEvents(t, ctx, eq, 1)[0]; send.Type != EVENT_SEND {
+		t.Errorf("First event is %v; expected SEND", send.Type)
+	}
+	if err := client.UnlinkMD(md.Handle()); err != nil {
+		t.Fatalf("UnlinkMD failed: %v", err)
+	}
+	if ack := waitEvents(t, ctx, eq, 1)[0]; ack.Type != EVENT_ACK || !errors.Is(ack.Status, ErrMDUnlinked) || !ack.Unlinked {
+		t.Errorf("Last event is %+v; expected ACK with %v", ack, ErrMDUnlinked)
+	}
+	if event, ok := eq.Get(); ok {
+		t.Errorf("Got %v after the last event of the MD", event.Type)
+	}
+
+	// An idle MD reports UNLINK
+	md = &MemoryDescriptor{Buffer: make([]byte, 4), Handler: eq.Handler()}
+	handle, err := client.Portals.Bind(md)
+	if err != nil {
+		t.Fatalf("Bind failed: %v", err)
+	}
+	if err := client.UnlinkMD(handle); err != nil {
+		t.Fatalf("UnlinkMD failed: %v", err)
+	}
+	if event, ok := eq.Get(); !ok || event.Type != EVENT_UNLINK || !event.Unlinked {
+		t.Errorf("Got %+v, %v; expected UNLINK", event, ok)
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Framing of ksock messages.
+
+Each KSOCK_MSG_LNET frame is a ksock header, an LNet header (two NIDs, the common fields and
+the fixed-size message union) and the payload. Frames are always read completely, and the
+payload length announced by the peer is checked before anything is allocated for it.
+*/
+package lnet
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sync"
+)
+
+// lnet-types.h, lnet-idl.h
+const (
+	// Maximum payload of an LNet message
+	LNET_MTU = 1 << 20
+	// Size of the message union in the LNet header (the largest member is the PUT)
+	LNET_MSG_UNION_SIZE = 40
+	// Size of the LNet header with 64-bit NIDs (struct lnet_hdr_nid4)
+	LNET_HDR_NID4_SIZE = 72
+	// Size of the LNet header with large NIDs (struct lnet_hdr_nid16), used by protocol version 4
+	LNET_HDR_NID16_SIZE = 96
+	// Default limit for the LNet header and payload of a frame
+	// (on protocol version 4 connections, the limit grows with the header)
+	DEFAULT_MAX_MESSAGE_SIZE = LNET_MTU + LNET_HDR_NID4_SIZE
+)
+
+// FrameTooLargeError is returned for frames that exceed the maximum message size.
+// The rest of the frame is not read, so the connection cannot be used afterwards.
+type FrameTooLargeError struct {
+	Size int
+	Max  int
+}
+
+func (err *FrameTooLargeError) Error() string {
+	return fmt.Sprintf("LNet frame of %d bytes exceeds maximum of %d bytes", err.Size, err.Max)
+}
+
+// TruncatedFrameError is returned when the connection ends in the middle of a frame.
+type TruncatedFrameError struct {
+	// Part of the frame that was being read
+	Part string
+	// Bytes of the frame read before the connection ended
+	Read int
+}
+
+func (err *TruncatedFrameError) Error() string {
+	return fmt.Sprintf("LNet frame truncated in %s after %d bytes", err.Part, err.Read)
+}
+
+func (err *TruncatedFrameError) Unwrap() error {
+	return io.ErrUnexpectedEOF
+}
+
+// frameReader reads one frame, tracking how much of it was read and its CRC.
+type frameReader struct {
+	reader io.Reader
+	read   int
+	// CRC-32 of what was read (see ksockChecksum)
+	crc uint32
+}
+
+func (reader *frameReader) Read(p []byte) (int, error) {
+	n, err := reader.reader.Read(p)
+	reader.read += n
+	reader.crc = crc32.Update(reader.crc, crc32.IEEETable, p[:n])
+	return n, err
+}
+
+// readFull fills data, turning the end of the connection into a TruncatedFrameError.
+// At the start of a frame, the end of the connection is reported as io.EOF.
+func (reader *frameReader) readFull(part string, data []byte) error {
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return reader.wrap(part, err)
+	}
+	return nil
+}
+
+// wrap converts errors from reading the frame.
+func (reader *frameReader) wrap(part string, err error) error {
+	if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("error reading LNet frame %s: %w", part, err)
+	}
+	if reader.read == 0 {
+		return io.EOF
+	}
+	return &TruncatedFrameError{Part: part, Read: reader.read}
+}
+
+// Payload buffers are reused between frames.
+// Buffers larger than this are left to the garbage collector.
+const maxPooledBuffer = LNET_MTU
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		buffer := make([]byte, 0, 4096)
+		return &buffer
+	},
+}
+
+// getBuffer returns a buffer of the given size from the pool.
+func getBuffer(size int) []byte {
+	buffer := *bufferPool.Get().(*[]byte)
+	if cap(buffer) < size {
+		// Too small, so leave it in the pool for smaller frames
+		putBuffer(buffer)
+		return make([]byte, size)
+	}
+	return buffer[:size]
+}
+
+// putBuffer returns a buffer to the pool.
+func putBuffer(buffer []byte) {
+	if cap(buffer) == 0 || cap(buffer) > maxPooledBuffer {
+		return
+	}
+	buffer = buffer[:0]
+	bufferPool.Put(&buffer)
+}
@@ -0,0 +1,169 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for reading ksock frames.
+*/
+package lnet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// encodeFrame encodes a message with its ksock header.
+func encodeFrame(t *testing.T, message LNetMessage) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	if err := binary.Write(buf, DEFAULT_BYTE_ORDER, KSockMessageHeader{Type: KSOCK_MSG_LNET}); err != nil {
+		t.Fatalf("Writing ksock header failed: %v", err)
+	}
+	data, err := message.ToBytes(DEFAULT_BYTE_ORDER)
+	if err != nil {
+		t.Fatalf("ToBytes failed: %v", err)
+	}
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+// testPutMessage returns a PUT between two NID4s.
+func testPutMessage(t *testing.T, payload []byte) LNetMessage {
+	t.Helper()
+	dest, err := ParseNID("10.0.0.1@tcp0")
+	if err != nil {
+		t.Fatalf("ParseNID failed: %v", err)
+	}
+	source, err := ParseNID("10.0.0.2@tcp0")
+	if err != nil {
+		t.Fatalf("ParseNID failed: %v", err)
+	}
+	return LNetMessage{
+		DestNID:         dest,
+		SourceNID:       source,
+		LNetHeaderEmbed: LNetHeaderEmbed{DestPID: PID_LUSTRE, SourcePID: PID_LUSTRE, MessageType: LNET_MSG_PUT},
+		LNetCommand:     &LNetPutCommand{MatchBits: 42, PortalIndex: 10},
+		Payload:         payload,
+	}
+}
+
+// readFrameFrom reads a message from data, which the peer writes in chunks before closing.
+func readFrameFrom(data []byte, chunkSize int, maxMessageSize int) (LNetMessage, error) {
+	local, peer := net.Pipe()
+	defer local.Close()
+	go func() {
+		defer peer.Close()
+		for len(data) > 0 {
+			n := min(chunkSize, len(data))
+			if _, err := peer.Write(data[:n]); err != nil {
+				return
+			}
+			data = data[n:]
+		}
+	}()
+	remote := &RemoteConn{Conn: &local, ByteOrder: DEFAULT_BYTE_ORDER, MaxMessageSize: maxMessageSize}
+	_, message, err := readMessage(context.Background(), remote)
+	return message, err
+}
+
+func TestMessageHeaderSize(t *testing.T) {
+	for _, command := range []any{&LNetAckCommand{}, &LNetPutCommand{}, &LNetGetCommand{}, &LNetReplyCommand{}, &LNetHelloCommand{}} {
+		message := testPutMessage(t, nil)
+		message.LNetCommand = command
+		data, err := message.ToBytes(DEFAULT_BYTE_ORDER)
+		if err != nil {
+			t.Fatalf("ToBytes failed for %T: %v", command, err)
+		}
+		if len(data) != LNET_HDR_NID4_SIZE {
+			t.Errorf("Header for %T has %d bytes; expected %d", command, len(data), LNET_HDR_NID4_SIZE)
+		}
+		if data, err := message.encode(DEFAULT_BYTE_ORDER, true); err != nil || len(data) != LNET_HDR_NID16_SIZE {
+			t.Errorf("Large header for %T has %d bytes (%v); expected %d", command, len(data), err, LNET_HDR_NID16_SIZE)
+		}
+	}
+}
+
+func TestReadFrameLargePayload(t *testing.T) {
+	payload := make([]byte, LNET_MTU)
+	for i := range payload {
+		payload[i] = byte(i * 7)
+	}
+	frame := encodeFrame(t, testPutMessage(t, payload))
+	// Small writes make the payload arrive over many reads
+	message, err := readFrameFrom(frame, 1000, 0)
+	if err != nil {
+		t.Fatalf("readMessage failed: %v", err)
+	}
+	defer message.release()
+	if !bytes.Equal(message.Payload, payload) {
+		t.Errorf("Payload of %d bytes differs from the %d bytes sent", len(message.Payload), len(payload))
+	}
+	command := message.LNetCommand.(*LNetPutCommand)
+	if command.MatchBits != 42 || command.PortalIndex != 10 {
+		t.Errorf("Got %+v; expected match bits 42 on portal 10", command)
+	}
+}
+
+func TestReadFrameTooLarge(t *testing.T) {
+	frame := encodeFrame(t, testPutMessage(t, make([]byte, 100)))
+	_, err := readFrameFrom(frame, len(frame), LNET_HDR_NID4_SIZE+99)
+	var tooLarge *FrameTooLargeError
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("readMessage returned %v; expected FrameTooLargeError", err)
+	}
+	if tooLarge.Size != LNET_HDR_NID4_SIZE+100 || tooLarge.Max != LNET_HDR_NID4_SIZE+99 {
+		t.Errorf("Got size %d and max %d; expected %d and %d", tooLarge.Size, tooLarge.Max, LNET_HDR_NID4_SIZE+100, LNET_HDR_NID4_SIZE+99)
+	}
+
+	// The default limit is the MTU plus the header
+	message := testPutMessage(t, nil)
+	message.PayloadLength = LNET_MTU + 1
+	header, err := message.ToBytes(DEFAULT_BYTE_ORDER)
+	if err != nil {
+		t.Fatalf("ToBytes failed: %v", err)
+	}
+	// ToBytes sets the length from the payload, so patch it in the header
+	DEFAULT_BYTE_ORDER.PutUint32(header[28:32], LNET_MTU+1)
+	frame = append(encodeFrame(t, testPutMessage(t, nil))[:24], header...)
+	if _, err := readFrameFrom(frame, len(frame), 0); !errors.As(err, &tooLarge) {
+		t.Errorf("readMessage returned %v; expected FrameTooLargeError", err)
+	}
+}
+
+func TestReadFrameTruncated(t *testing.T) {
+	frame := encodeFrame(t, testPutMessage(t, []byte("glimmering")))
+	tests := []struct {
+		name   string
+		length int
+		part   string
+	}{
+		{"ksock header", 10, "ksock header"},
+		{"NID", 24 + 4, "destination NID"},
+		{"header", 24 + 20, "header"},
+		{"payload", len(frame) - 1, "payload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readFrameFrom(frame[:tt.length], tt.length, 0)
+			var truncated *TruncatedFrameError
+			if !errors.As(err, &truncated) {
+				t.Fatalf("readMessage returned %v; expected TruncatedFrameError", err)
+			}
+			if truncated.Part != tt.part || truncated.Read != tt.length {
+				t.Errorf("Got %q after %d bytes; expected %q after %d bytes", truncated.Part, truncated.Read, tt.part, tt.length)
+			}
+			if !errors.Is(err, io.ErrUnexpectedEOF) {
+				t.Errorf("Error %v does not wrap io.ErrUnexpectedEOF", err)
+			}
+		})
+	}
+
+	// Closing between frames is not a truncation
+	if _, err := readFrameFrom(nil, 1, 0); err != io.EOF {
+		t.Errorf("readMessage on a closed connection returned %v; expected io.EOF", err)
+	}
+}
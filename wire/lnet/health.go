@@ -0,0 +1,246 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+LNet health (lnet_health_check in lib-msg.c, and the recovery queues of api-ni.c).
+
+Each local NI and peer NI has a health value, from 0 to LNET_MAX_HEALTH_VALUE.
+Failures lower it by the health sensitivity: local errors (such as a missing source address)
+count against the local NI, timeouts, connection resets and handshake errors against the peer NI.
+Successful sends and recovery raise it again. Path selection prefers the healthiest NIs,
+and sends that fail are resent, possibly on another path, up to RetryCount times.
+*/
+package lnet
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// Health of a fully healthy NI
+	LNET_MAX_HEALTH_VALUE = 1000
+	// Health lost on each failure (lnet_health_sensitivity)
+	DEFAULT_HEALTH_SENSITIVITY = 100
+	// Resends of a failed send (lnet_retry_count)
+	DEFAULT_RETRY_COUNT = 2
+	// Time between recovery attempts of unhealthy NIs (lnet_recovery_interval)
+	DEFAULT_RECOVERY_INTERVAL = time.Second
+	// Longest time between recovery pings of an unhealthy peer NI (lnet_max_recovery_ping_interval)
+	DEFAULT_MAX_RECOVERY_PING_INTERVAL = 900 * time.Second
+)
+
+// sendError marks failures to get a message onto the wire, which can be resent.
+type sendError struct {
+	err error
+}
+
+func (err *sendError) Error() string {
+	return err.err.Error()
+}
+
+func (err *sendError) Unwrap() error {
+	return err.err
+}
+
+// isLocalError reports whether a failure lies with our own NI rather than with the peer.
+func isLocalError(err error) bool {
+	return errors.Is(err, syscall.EADDRNOTAVAIL) || errors.Is(err, syscall.ENETDOWN) || errors.Is(err, syscall.ENETUNREACH)
+}
+
+// changeHealth adds delta to the health of the NI, within 0 and LNET_MAX_HEALTH_VALUE.
+func (table *NITable) changeHealth(ni LocalNI, delta int) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	if state := table.states[ni]; state != nil {
+		state.health = min(max(state.health+delta, 0), LNET_MAX_HEALTH_VALUE)
+	}
+}
+
+// Health returns the health value of the peer NI.
+func (peer *Peer) Health() int {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	return peer.health
+}
+
+// changeHealth adds delta to the health of the peer NI, within 0 and LNET_MAX_HEALTH_VALUE.
+// Once it is fully healthy, its recovery pings start over.
+func (peer *Peer) changeHealth(delta int) {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	peer.health = min(max(peer.health+delta, 0), LNET_MAX_HEALTH_VALUE)
+	if peer.health == LNET_MAX_HEALTH_VALUE {
+		peer.pingCount, peer.nextPing = 0, time.Time{}
+	}
+}
+
+// recoveryPingDue reports whether a recovery ping of the peer NI is due, and if so schedules the next:
+// the interval doubles from a second with each ping, up to DEFAULT_MAX_RECOVERY_PING_INTERVAL
+// (lnet_get_next_recovery_ping).
+func (peer *Peer) recoveryPingDue(now time.Time) bool {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	if now.Before(peer.nextPing) {
+		return false
+	}
+	// 1024s is past the maximum already
+	interval := min(time.Second<<min(peer.pingCount, 10), DEFAULT_MAX_RECOVERY_PING_INTERVAL)
+	peer.pingCount++
+	peer.nextPing = now.Add(interval)
+	return true
+}
+
+// sendFailed lowers the health of the local NI or of the peer NI, depending on the failure.
+// Callers skip failures of their own making, such as a cancelled context.
+func (client *LNetClient) sendFailed(ni LocalNI, nid NID, err error) {
+	if client.HealthSensitivity == 0 {
+		return
+	}
+	if isLocalError(err) {
+		if ni.Addr.IsValid() {
+			client.NIs.changeHealth(ni, -client.HealthSensitivity)
+			slog.Debug("local NI health lowered", "ni", ni, "error", err)
+		}
+		return
+	}
+	// Peers we never reached are not added just to hold their health
+	if nid == nil {
+		return
+	}
+	if peer := client.Peers.Peer(nid); peer != nil {
+		peer.changeHealth(-client.HealthSensitivity)
+		slog.Debug("peer NI health lowered", "nid", nid, "error", err)
+	}
+}
+
+// connFailed lowers the health of the peer NI of the connection.
+func (client *LNetClient) connFailed(remote *RemoteConn, err error) {
+	ni, _ := client.NIs.lookup(remote.LocalNID)
+	client.sendFailed(ni, remote.NID, err)
+}
+
+// sendSucceeded raises the health of the local NI and the peer NI of the connection.
+func (client *LNetClient) sendSucceeded(remote *RemoteConn) {
+	if client.HealthSensitivity == 0 {
+		return
+	}
+	if ni, ok := client.NIs.lookup(remote.LocalNID); ok {
+		client.NIs.changeHealth(ni, client.HealthSensitivity)
+	}
+	if remote.NID != nil {
+		if peer := client.Peers.Peer(remote.NID); peer != nil {
+			peer.changeHealth(client.HealthSensitivity)
+		}
+	}
+}
+
+// resend runs send on the connection that route picks for the NID. If the message cannot be sent,
+// it is sent again on the then healthiest path, up to client.RetryCount times.
+// Without a deadline on ctx, all attempts are bounded by client.TransactionTimeout.
+func (client *LNetClient) resend(ctx context.Context, nid NID, size int, send func(ctx context.Context, remote *RemoteConn, dest NID) error) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.TransactionTimeout)
+		defer cancel()
+	}
+	var err error
+	for attempt := 0; ; attempt++ {
+		var remote *RemoteConn
+		var dest NID
+		if remote, dest, err = client.route(ctx, nid, size); err == nil {
+			err = send(ctx, remote, dest)
+		}
+		var failed *sendError
+		if err == nil || !errors.As(err, &failed) || ctx.Err() != nil || attempt >= client.RetryCount {
+			return err
+		}
+		slog.Info("resending message", "nid", nid, "attempt", attempt+1, "error", err)
+	}
+}
+
+// RecoverHealth raises the health of unhealthy NIs once: local NIs that are up recover by
+// the health sensitivity, peer NIs only if they answer a ping (as any successful send raises it).
+// Local NIs that are down come back up once their LND reports them up.
+// Peer NIs are pinged concurrently when due (see recoveryPingDue), each bounded by client.TransactionTimeout:
+// over an existing connection, or else a new one if the peer NI listens (see listens).
+func (client *LNetClient) RecoverHealth(ctx context.Context) {
+	for _, info := range client.NIs.Info() {
+		if info.Status == PING_NI_STATUS_DOWN {
+			if lnd, err := client.lnd(info.NetType); err == nil && lnd.Query(info.LocalNI) == PING_NI_STATUS_UP {
+				slog.Info("local NI is up again", "ni", info.LocalNI)
+				client.NIs.SetStatus(info.LocalNI, PING_NI_STATUS_UP)
+				info.Status = PING_NI_STATUS_UP
+			}
+		}
+		if info.Health < LNET_MAX_HEALTH_VALUE && info.Status == PING_NI_STATUS_UP {
+			client.NIs.changeHealth(info.LocalNI, client.HealthSensitivity)
+		}
+	}
+	var pings sync.WaitGroup
+	now := time.Now()
+	for _, peer := range client.Peers.Peers() {
+		if peer.Health() >= LNET_MAX_HEALTH_VALUE {
+			continue
+		}
+		remote := peer.Conn(0)
+		if remote == nil && !client.listens(peer.NID) {
+			// It is pinged once it connects again
+			continue
+		}
+		if !peer.recoveryPingDue(now) {
+			continue
+		}
+		pings.Go(func() {
+			pingCtx, cancel := context.WithTimeout(ctx, client.TransactionTimeout)
+			defer cancel()
+			var err error
+			if remote == nil {
+				remote, err = client.Dial(pingCtx, peer.NID)
+			}
+			if err == nil {
+				_, err = client.PingRemote(pingCtx, remote)
+			}
+			if err != nil && ctx.Err() == nil {
+				slog.Debug("peer NI recovery ping failed", "nid", peer.NID, "error", err)
+			}
+		})
+	}
+	pings.Wait()
+}
+
+// listens reports whether the peer NI is known to accept connections: it is a NID of a multi-rail
+// peer (from its ping buffer), or a gateway. A peer that only connected to us may not listen at all,
+// and unix sockets can only be dialed if we know their path.
+func (client *LNetClient) listens(nid NID) bool {
+	if nid.Header().Type == NETWORK_TYPE_UNIX {
+		if _, ok := UnixPath(nid); !ok {
+			return false
+		}
+	}
+	return client.Peers.MultiRailPeer(nid) != nil || client.isGateway(nid)
+}
+
+// RunHealthRecovery recovers unhealthy NIs every interval (DEFAULT_RECOVERY_INTERVAL if 0),
+// until ctx is cancelled.
+func (client *LNetClient) RunHealthRecovery(ctx context.Context, interval time.Duration) error {
+	if interval == 0 {
+		interval = DEFAULT_RECOVERY_INTERVAL
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		if client.HealthSensitivity > 0 {
+			client.RecoverHealth(ctx)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for LNet health and resends.
+*/
+package lnet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHealthSelection(t *testing.T) {
+	client := NewLNetClient()
+	rail0, rail1 := testNI(0, "10.0.0.1"), testNI(0, "10.0.0.2")
+	client.NIs.Set([]LocalNI{rail0, rail1})
+	primary, secondary := mustParseNID(t, "10.0.0.11@tcp0"), mustParseNID(t, "10.0.0.12@tcp0")
+	if _, err := client.Peers.AddPeerNIDs(primary, secondary); err != nil {
+		t.Fatalf("AddPeerNIDs failed: %v", err)
+	}
+
+	// Health comes before credits and round robin, on both sides
+	client.NIs.changeHealth(rail0, -client.HealthSensitivity)
+	client.Peers.peer(secondary).changeHealth(-client.HealthSensitivity)
+	for range 3 {
+		path, err := client.selectPath(secondary)
+		if err != nil || path.ni != rail1 || !SameNID(path.nid, primary) {
+			t.Errorf("selectPath picked %v to %v, %v; expected %v to %v", path.ni, path.nid, err, rail1, primary)
+		}
+	}
+	if infos := client.NIs.Info(); infos[0].Health != LNET_MAX_HEALTH_VALUE-DEFAULT_HEALTH_SENSITIVITY || infos[1].Health != LNET_MAX_HEALTH_VALUE {
+		t.Errorf("NI health is %d and %d; expected %d and %d", infos[0].Health, infos[1].Health, LNET_MAX_HEALTH_VALUE-DEFAULT_HEALTH_SENSITIVITY, LNET_MAX_HEALTH_VALUE)
+	}
+
+	// Local NIs recover over time, and health stays within its bounds
+	client.RecoverHealth(context.Background())
+	client.NIs.changeHealth(rail1, 1)
+	if infos := client.NIs.Info(); infos[0].Health != LNET_MAX_HEALTH_VALUE || infos[1].Health != LNET_MAX_HEALTH_VALUE {
+		t.Errorf("NI health is %d and %d after recovery; expected %d", infos[0].Health, infos[1].Health, LNET_MAX_HEALTH_VALUE)
+	}
+}
+
+// TestResend checks that a send to a peer NID that refuses connections is resent to another NID of the peer.
+func TestResend(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	port := uint16(free.Addr().(*net.TCPAddr).Port)
+	free.Close()
+
+	server := NewLNetServer().WithPort(port)
+	server.Client.NIs.Set([]LocalNI{testNI(0, "127.0.0.1")})
+	go func() { _ = server.Listen(ctx) }()
+	defer server.Shutdown(ctx)
+	dead := mustParseNID(t, fmt.Sprintf("127.0.0.6@tcp0#%d", port))
+	alive := mustParseNID(t, fmt.Sprintf("127.0.0.1@tcp0#%d", port))
+
+	client := NewLNetClient()
+	client.NIs.Set([]LocalNI{testNI(0, "127.0.0.3")})
+	if _, err := client.Peers.AddPeerNIDs(dead, alive); err != nil {
+		t.Fatalf("AddPeerNIDs failed: %v", err)
+	}
+	for {
+		// Until the server listens, both NIDs fail
+		client.Peers.peer(alive).changeHealth(LNET_MAX_HEALTH_VALUE)
+		client.Peers.peer(dead).changeHealth(LNET_MAX_HEALTH_VALUE)
+		if _, err = client.PingNID(ctx, dead); err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("PingNID failed: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if health := client.Peers.Peer(dead).Health(); health != LNET_MAX_HEALTH_VALUE-DEFAULT_HEALTH_SENSITIVITY {
+		t.Errorf("Dead peer NI has health %d; expected %d", health, LNET_MAX_HEALTH_VALUE-DEFAULT_HEALTH_SENSITIVITY)
+	}
+	if health := client.Peers.Peer(alive).Health(); health != LNET_MAX_HEALTH_VALUE {
+		t.Errorf("Live peer NI has health %d; expected %d", health, LNET_MAX_HEALTH_VALUE)
+	}
+
+	// Without resends, the failure goes back to the caller
+	client.RetryCount = 0
+	client.Peers.peer(dead).changeHealth(LNET_MAX_HEALTH_VALUE)
+	if _, err := client.PingNID(ctx, dead); err == nil {
+		t.Errorf("PingNID succeeded without resends; expected the dead NID to be tried first")
+	}
+}
+
+// TestRecoverPeerHealth checks that unhealthy peer NIs are pinged over their connection, with backoff,
+// and that peers that only connected to us are not dialed.
+func TestRecoverPeerHealth(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server := NewLNetServer()
+	nid, _ := startServer(t, ctx, server)
+	defer server.Shutdown(ctx)
+
+	client := NewLNetClient()
+	remote, err := client.Dial(ctx, nid)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer remote.Close()
+	var peer *Peer
+	for peer = server.Client.Peers.Peer(remote.LocalNID); peer == nil; peer = server.Client.Peers.Peer(remote.LocalNID) {
+		if ctx.Err() != nil {
+			t.Fatal("Client is not in the server's peer table")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	lowered := LNET_MAX_HEALTH_VALUE - 3*DEFAULT_HEALTH_SENSITIVITY
+	peer.changeHealth(lowered - LNET_MAX_HEALTH_VALUE)
+	server.Client.RecoverHealth(ctx)
+	if health := peer.Health(); health != lowered+DEFAULT_HEALTH_SENSITIVITY {
+		t.Errorf("Peer NI has health %d after recovery; expected %d", health, lowered+DEFAULT_HEALTH_SENSITIVITY)
+	}
+	// The next ping is due in a second
+	server.Client.RecoverHealth(ctx)
+	if health := peer.Health(); health != lowered+DEFAULT_HEALTH_SENSITIVITY {
+		t.Errorf("Peer NI has health %d after a second recovery; expected no ping yet", health)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer listener.Close()
+	unknown := mustParseNID(t, fmt.Sprintf("127.0.0.1@tcp0#%d", listener.Addr().(*net.TCPAddr).Port))
+	server.Client.Peers.peer(unknown).changeHealth(-DEFAULT_HEALTH_SENSITIVITY)
+	server.Client.RecoverHealth(ctx)
+	_ = listener.(*net.TCPListener).SetDeadline(time.Now().Add(100 * time.Millisecond))
+	if conn, err := listener.Accept(); err == nil {
+		conn.Close()
+		t.Errorf("Recovery dialed a peer NI that is not known to listen")
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+socklnd-style keepalive.
+
+Like ksocklnd, we send a NOOP when we have not heard from the peer for Timeouts.Keepalive,
+which makes a live peer (that does the same) send something back.
+A peer that sends nothing for Timeouts.Idle is considered dead: its connection is dropped and
+a PEER_EVENT_DEAD is published, so that half-open connections do not hang forever.
+*/
+package lnet
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Defaults of ksocklnd's keepalive and LNet's peer_timeout
+const (
+	DEFAULT_KEEPALIVE    = 30 * time.Second
+	DEFAULT_PEER_TIMEOUT = 180 * time.Second
+)
+
+// ErrPeerDead is returned when a peer sends nothing for Timeouts.Idle.
+var ErrPeerDead = errors.New("LNet peer is dead")
+
+// keepalive sends NOOPs while the peer is quiet, until done is closed.
+func (client *LNetClient) keepalive(ctx context.Context, remote *RemoteConn, done <-chan struct{}) {
+	interval := remote.Timeouts.Keepalive
+	if interval <= 0 {
+		return
+	}
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		wait := interval - time.Since(remote.LastReceived())
+		if wait <= 0 {
+			slog.Debug("sending keepalive NOOP", "remote", remote)
+			if err := client.SendNoop(ctx, remote); err != nil {
+				slog.Warn("failed to send keepalive NOOP", "error", err, "remote", remote)
+			}
+			wait = interval
+		}
+		timer.Reset(wait)
+	}
+}
+
+// isIdleTimeout reports whether reading the start of a frame failed because the Idle timeout expired
+// (rather than ctx ending or stopReading).
+func isIdleTimeout(ctx context.Context, remote *RemoteConn, reader *frameReader, err error) bool {
+	return remote.Timeouts.Idle > 0 && reader.read == 0 && ctx.Err() == nil &&
+		!remote.readStopped.Load() && errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+type PeerEventType uint32
+
+const (
+	// Nothing was received from the peer within Timeouts.Idle
+	PEER_EVENT_DEAD PeerEventType = iota + 1
+	// The peer restarted (its incarnation changed), and its old connections were closed
+	PEER_EVENT_RESET
+)
+
+func (eventType PeerEventType) String() string {
+	switch eventType {
+	case PEER_EVENT_DEAD:
+		return "DEAD"
+	case PEER_EVENT_RESET:
+		return "RESET"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// PeerEvent reports a change in the state of a peer.
+type PeerEvent struct {
+	Type PeerEventType
+	NID  NID
+	// The connection the event is about, if any
+	Remote *RemoteConn
+	Err    error
+}
+
+// peerEventSubscribers holds the handlers for peer events.
+type peerEventSubscribers struct {
+	mu       sync.Mutex
+	next     int
+	handlers map[int]func(PeerEvent)
+}
+
+func newPeerEventSubscribers() *peerEventSubscribers {
+	return &peerEventSubscribers{handlers: make(map[int]func(PeerEvent))}
+}
+
+// SubscribePeerEvents calls handler for each peer event, until unsubscribe is called.
+// Handlers run on the connection's goroutine, so they must not block.
+func (client *LNetClient) SubscribePeerEvents(handler func(PeerEvent)) (unsubscribe func()) {
+	subscribers := client.peerEvents
+	subscribers.mu.Lock()
+	defer subscribers.mu.Unlock()
+	id := subscribers.next
+	subscribers.next++
+	subscribers.handlers[id] = handler
+	return func() {
+		subscribers.mu.Lock()
+		defer subscribers.mu.Unlock()
+		delete(subscribers.handlers, id)
+	}
+}
+
+// publishPeerEvent passes the event to all subscribers.
+func (client *LNetClient) publishPeerEvent(event PeerEvent) {
+	subscribers := client.peerEvents
+	subscribers.mu.Lock()
+	handlers := make([]func(PeerEvent), 0, len(subscribers.handlers))
+	for _, handler := range subscribers.handlers {
+		handlers = append(handlers, handler)
+	}
+	subscribers.mu.Unlock()
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for keepalive and dead-peer detection.
+*/
+package lnet
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestKeepaliveNoop(t *testing.T) {
+	local, peer := net.Pipe()
+	defer peer.Close()
+	client := NewLNetClient()
+	remote := &RemoteConn{Conn: &local, ByteOrder: DEFAULT_BYTE_ORDER, Timeouts: Timeouts{Keepalive: 50 * time.Millisecond}}
+	defer remote.Close()
+	go func() { _ = client.handleCommands(context.Background(), remote) }()
+
+	// The peer stays quiet, so we send it a NOOP
+	if err := peer.SetReadDeadline(time.Now().Add(time.Second)); err != nil {
+		t.Fatalf("SetReadDeadline failed: %v", err)
+	}
+	var header [24]byte
+	if _, err := io.ReadFull(peer, header[:]); err != nil {
+		t.Fatalf("Reading keepalive failed: %v", err)
+	}
+	if messageType := DEFAULT_BYTE_ORDER.Uint32(header[:4]); messageType != KSOCK_MSG_NOOP {
+		t.Errorf("Received message type 0x%x; expected KSOCK_MSG_NOOP", messageType)
+	}
+}
+
+func TestDeadPeer(t *testing.T) {
+	local, peer := net.Pipe()
+	defer peer.Close()
+	client := NewLNetClient()
+	events := make(chan PeerEvent, 1)
+	unsubscribe := client.SubscribePeerEvents(func(event PeerEvent) { events <- event })
+	defer unsubscribe()
+	remote := &RemoteConn{Conn: &local, ByteOrder: DEFAULT_BYTE_ORDER, Timeouts: Timeouts{Idle: 100 * time.Millisecond}}
+	defer remote.Close()
+
+	// NOOPs from the peer keep it alive
+	go func() {
+		noop := make([]byte, binary.Size(KSockMessageHeader{}))
+		DEFAULT_BYTE_ORDER.PutUint32(noop, KSOCK_MSG_NOOP)
+		for range 4 {
+			time.Sleep(50 * time.Millisecond)
+			if _, err := peer.Write(noop); err != nil {
+				return
+			}
+		}
+	}()
+	start := time.Now()
+	err := client.handleCommands(context.Background(), remote)
+	if !errors.Is(err, ErrPeerDead) {
+		t.Fatalf("handleCommands returned %v; expected %v", err, ErrPeerDead)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Errorf("Peer declared dead after %v, while it was sending NOOPs", elapsed)
+	}
+	select {
+	case event := <-events:
+		if event.Type != PEER_EVENT_DEAD || event.Remote != remote || !errors.Is(event.Err, ErrPeerDead) {
+			t.Errorf("Got event %+v; expected PEER_EVENT_DEAD for the connection", event)
+		}
+	default:
+		t.Errorf("No peer event was published")
+	}
+}
+
+// TestKeepaliveBetweenPeers checks that two quiet peers keep each other alive.
+func TestKeepaliveBetweenPeers(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	timeouts := Timeouts{Idle: 200 * time.Millisecond, Keepalive: 50 * time.Millisecond}
+	server := NewLNetServer()
+	server.Client.Timeouts = timeouts
+	nid, _ := startServer(t, ctx, server)
+	defer server.Shutdown(ctx)
+
+	client := NewLNetClient()
+	client.Timeouts = timeouts
+	dead := make(chan PeerEvent, 2)
+	client.SubscribePeerEvents(func(event PeerEvent) { dead <- event })
+	server.Client.SubscribePeerEvents(func(event PeerEvent) { dead <- event })
+	remote, err := client.Dial(ctx, nid)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer remote.Close()
+
+	time.Sleep(500 * time.Millisecond)
+	select {
+	case event := <-dead:
+		t.Fatalf("Peer %v declared dead: %v", event.NID, event.Err)
+	default:
+	}
+	if _, err := client.PingRemote(ctx, remote); err != nil {
+		t.Errorf("PingRemote failed: %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+LNet network drivers (struct lnet_lnd in lib-types.h).
+
+An LND carries LNet messages over one type of network: it listens for and opens connections,
+performs the handshake, and frames messages on the wire. The core (portals, credits, health,
+routing and discovery) picks the LND by the network type of the NID, so transports can be
+added by registering an LND in LNetClient.LNDs.
+*/
+package lnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// ErrNoLND is returned for NIDs on networks that no registered LND serves.
+var ErrNoLND = errors.New("no LND for network type")
+
+// LND is a network driver.
+type LND interface {
+	// NetworkType returns the type of the networks the LND serves.
+	NetworkType() NetworkType
+	// Startup starts the LND on the NI, and returns the listener for its incoming connections
+	// on the port, or nil if it takes none (lnd_startup). An NI without an address listens on all addresses.
+	Startup(ctx context.Context, config net.ListenConfig, ni LocalNI, port uint16) (net.Listener, error)
+	// Shutdown releases what Startup set up for the NI, once its listener is closed (lnd_shutdown).
+	Shutdown(ni LocalNI) error
+	// Connect opens a connection from the NI (if it has an address) to the peer NID,
+	// and performs the initiator side of the handshake.
+	Connect(ctx context.Context, client *LNetClient, nid NID, connType ConnType, ni LocalNI) (*RemoteConn, error)
+	// Accept performs the acceptor side of the handshake on an incoming connection.
+	Accept(ctx context.Context, remote *RemoteConn) error
+	// Send writes the message to the connection, and returns once it is written (lnd_send).
+	Send(ctx context.Context, remote *RemoteConn, message LNetMessage) error
+	// Receive reads the next LNet message from the connection (lnd_recv).
+	// It returns ErrPeerDead if the peer sent nothing for remote.Timeouts.Idle.
+	Receive(ctx context.Context, remote *RemoteConn) (LNetMessage, error)
+	// Query reports whether the NI can carry messages (lnd_query).
+	Query(ni LocalNI) PingStatus
+}
+
+// LNDTable holds the LNDs of a client by network type.
+type LNDTable struct {
+	mu   sync.Mutex
+	lnds map[NetworkType]LND
+}
+
+func NewLNDTable() *LNDTable {
+	return &LNDTable{lnds: make(map[NetworkType]LND)}
+}
+
+// Register adds the LND, replacing any LND for the same network type.
+func (table *LNDTable) Register(lnd LND) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	table.lnds[lnd.NetworkType()] = lnd
+}
+
+// Lookup returns the LND for the network type.
+func (table *LNDTable) Lookup(netType NetworkType) (LND, error) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	lnd, ok := table.lnds[netType]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrNoLND, netType)
+	}
+	return lnd, nil
+}
+
+// lnd returns the LND of the client for the network type.
+// The unix network is a GlimmerFS extension, which is refused in CompatMode.
+func (client *LNetClient) lnd(netType NetworkType) (LND, error) {
+	if client.CompatMode && netType == NETWORK_TYPE_UNIX {
+		return nil, fmt.Errorf("%w %s in CompatMode", ErrNoLND, netType)
+	}
+	return client.LNDs.Lookup(netType)
+}
+
+// driver returns the LND of the connection. Connections made outside of an LND are socklnd's.
+func (remote *RemoteConn) driver() LND {
+	if remote.lnd == nil {
+		return SockLND{}
+	}
+	return remote.lnd
+}
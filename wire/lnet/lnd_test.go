@@ -0,0 +1,100 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for LND selection.
+*/
+package lnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testLND runs socklnd on o2ib networks, and counts its use.
+type testLND struct {
+	SockLND
+	startups, connects, sends atomic.Int32
+}
+
+func (*testLND) NetworkType() NetworkType {
+	return NETWORK_TYPE_O2IB
+}
+
+func (lnd *testLND) Startup(ctx context.Context, config net.ListenConfig, ni LocalNI, port uint16) (net.Listener, error) {
+	lnd.startups.Add(1)
+	return lnd.SockLND.Startup(ctx, config, ni, port)
+}
+
+func (lnd *testLND) Connect(ctx context.Context, client *LNetClient, nid NID, connType ConnType, ni LocalNI) (*RemoteConn, error) {
+	lnd.connects.Add(1)
+	remote, err := lnd.SockLND.Connect(ctx, client, nid, connType, ni)
+	if err == nil {
+		remote.lnd = lnd
+	}
+	return remote, err
+}
+
+func (lnd *testLND) Send(ctx context.Context, remote *RemoteConn, message LNetMessage) error {
+	lnd.sends.Add(1)
+	return lnd.SockLND.Send(ctx, remote, message)
+}
+
+// TestLNDSelection checks that servers and clients use the LND of the network of each NI and NID.
+func TestLNDSelection(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	port := uint16(free.Addr().(*net.TCPAddr).Port)
+	free.Close()
+
+	serverLND := &testLND{}
+	server := NewLNetServer().WithPort(port)
+	server.Client.LNDs.Register(serverLND)
+	ni := LocalNI{NetType: NETWORK_TYPE_O2IB, Interface: "ib0", Addr: netip.MustParseAddr("127.0.0.1")}
+	server.Client.NIs.Set([]LocalNI{ni})
+	go func() { _ = server.Listen(ctx) }()
+	defer server.Shutdown(ctx)
+	nid := mustParseNID(t, fmt.Sprintf("127.0.0.1@o2ib0#%d", port))
+
+	// Without an LND for o2ib, the NID cannot be reached
+	client := NewLNetClient()
+	if _, err := client.Dial(ctx, nid); !errors.Is(err, ErrNoLND) {
+		t.Errorf("Dial returned %v; expected %v", err, ErrNoLND)
+	}
+
+	clientLND := &testLND{}
+	client.LNDs.Register(clientLND)
+	for {
+		if _, err = client.PingNID(ctx, nid); err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("PingNID failed: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if serverLND.startups.Load() != 1 || serverLND.sends.Load() == 0 {
+		t.Errorf("Server LND had %d startups and %d sends; expected 1 startup and the ping reply", serverLND.startups.Load(), serverLND.sends.Load())
+	}
+	if clientLND.connects.Load() == 0 || clientLND.sends.Load() == 0 {
+		t.Errorf("Client LND had %d connects and %d sends; expected the ping", clientLND.connects.Load(), clientLND.sends.Load())
+	}
+
+	// A down NI comes back once its LND can use it again
+	client.NIs.Set([]LocalNI{{NetType: NETWORK_TYPE_TCP, Addr: netip.MustParseAddr("127.0.0.1")}})
+	client.NIs.SetStatus(client.NIs.NIs()[0], PING_NI_STATUS_DOWN)
+	client.RecoverHealth(ctx)
+	if status := client.NIs.Info()[0].Status; status != PING_NI_STATUS_UP {
+		t.Errorf("NI is %v after recovery; expected %v", status, PING_NI_STATUS_UP)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+The loopback LND (lolnd): 0@lo reaches the process itself, so that services sharing an
+LNetClient talk LNet to each other without sockets.
+
+Each connection is an in-memory pipe that carries ksock frames, and its other end is handled
+by the same client, so portals, events and credits work as they do on tcp.
+There is no handshake, as both ends are ours.
+*/
+package lnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+)
+
+// LoLND is the LND of the lo network.
+type LoLND struct {
+	// Frames as on tcp
+	SockLND
+}
+
+func (LoLND) NetworkType() NetworkType {
+	return NETWORK_TYPE_LO
+}
+
+// Startup listens on nothing, as only the process itself connects to 0@lo.
+func (LoLND) Startup(ctx context.Context, config net.ListenConfig, ni LocalNI, port uint16) (net.Listener, error) {
+	return nil, nil
+}
+
+func (LoLND) Shutdown(ni LocalNI) error {
+	return nil
+}
+
+// Connect opens a pipe to ourselves. The other end is served like an accepted connection,
+// but it is not added to client.Peers, so that replies to our messages come back on it.
+func (lnd LoLND) Connect(ctx context.Context, client *LNetClient, nid NID, connType ConnType, ni LocalNI) (*RemoteConn, error) {
+	if !SameNID(nid, LoNID) {
+		return nil, fmt.Errorf("failed to connect to %s: the lo network only reaches %s", nid, LoNID)
+	}
+	local, other := net.Pipe()
+	remote := lnd.newConn(client, local, connType)
+	accepted := lnd.newConn(client, other, connType.Invert())
+	go func() {
+		err := client.handleCommands(context.WithoutCancel(ctx), accepted)
+		if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrClosedPipe) {
+			slog.Debug("LNet loopback connection closed", "error", err)
+		}
+		_ = accepted.Close()
+	}()
+	return remote, nil
+}
+
+// newConn creates one end of a loopback connection, as the handshake would have left it.
+func (lnd LoLND) newConn(client *LNetClient, conn net.Conn, connType ConnType) *RemoteConn {
+	remote := client.newRemoteConn(conn)
+	remote.lnd = lnd
+	remote.Protocol = PROTO_MAGIC_TCP
+	remote.Version = KSOCK_PROTO_V3
+	remote.NID, remote.LocalNID = LoNID, LoNID
+	remote.PID, remote.Incarnation = client.PID, client.Incarnation
+	remote.ConnType = connType
+	return remote
+}
+
+func (LoLND) Accept(ctx context.Context, remote *RemoteConn) error {
+	return fmt.Errorf("the lo network takes no incoming connections")
+}
+
+// Query reports the loopback NI up, as it always is.
+func (LoLND) Query(ni LocalNI) PingStatus {
+	return PING_NI_STATUS_UP
+}
@@ -0,0 +1,68 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for the loopback LND.
+*/
+package lnet
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLoNID(t *testing.T) {
+	nid := mustParseNID(t, "0@lo")
+	if !SameNID(nid, LoNID) || nid.String() != "0@lo" {
+		t.Errorf("ParseNID(\"0@lo\") returned %v; expected %v", nid, LoNID)
+	}
+}
+
+// TestLoopback checks that a client reaches its own portals through 0@lo, without a server.
+func TestLoopback(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client := NewLNetClient()
+	// NIs on other networks do not get in the way
+	client.NIs.Set([]LocalNI{testNI(0, "10.0.0.1")})
+	eq := NewEventQueue(8)
+	sink := &MemoryDescriptor{
+		Buffer:    make([]byte, 8),
+		Threshold: LNET_MD_THRESH_INF,
+		Options:   LNET_MD_OP_PUT | LNET_MD_OP_GET | LNET_MD_MANAGE_REMOTE,
+		Handler:   eq.Handler(),
+	}
+	if _, err := client.Portals.Attach(10, &MatchEntry{MatchID: AnyProcess, MatchBits: 42}, sink, true); err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+
+	length, err := client.PutTo(ctx, LoNID, 10, 42, 0, 0, []byte("glimmer"), true)
+	if err != nil || length != 7 || string(sink.Buffer[:7]) != "glimmer" {
+		t.Fatalf("PutTo returned %d, %v with %q; expected 7 bytes stored", length, err, sink.Buffer)
+	}
+	if event := waitEvents(t, ctx, eq, 1)[0]; event.Type != EVENT_PUT || event.Initiator.NID.String() != "0@lo" {
+		t.Errorf("Got %v from %v; expected PUT from 0@lo", event.Type, event.Initiator.NID)
+	}
+	buffer := make([]byte, 4)
+	if length, err := client.GetFrom(ctx, LoNID, 10, 42, 3, buffer); err != nil || string(buffer[:length]) != "mmer" {
+		t.Errorf("GetFrom returned %q, %v; expected \"mmer\"", buffer[:length], err)
+	}
+	ping, err := client.PingNID(ctx, LoNID)
+	if err != nil || ping.PID != client.PID {
+		t.Errorf("PingNID returned %+v, %v; expected our own ping buffer", ping, err)
+	}
+
+	// One connection, whose credits all came back
+	peer := client.Peers.Peer(LoNID)
+	if peer == nil || len(peer.Conns()) != 1 {
+		t.Fatalf("Peer 0@lo is %v; expected one connection", peer)
+	}
+	// The last reply may still hold its credit until its sender sees it written
+	for stats := peer.Credits(); stats.Available != stats.Max; stats = peer.Credits() {
+		if ctx.Err() != nil {
+			t.Fatalf("Peer credits are %v; expected all available", stats)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,236 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Multi-rail peers and path selection (lnet_select_pathway in lib-move.c).
+
+A multi-rail peer (lnet_peer) is known by several NIDs, possibly on several networks.
+To send to it, we pick one of our NIs on a network we share with the peer, then one of the
+peer's NIDs on that network. Both are chosen by their status, then the most tx credits, then
+round robin, so that traffic is spread over all the rails.
+*/
+package lnet
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// ErrUnreachable is returned when none of our NIs is on a network of the peer,
+// and no route leads to one.
+var ErrUnreachable = errors.New("no local NI on the networks of the peer")
+
+// MultiRailPeer is a peer with several NIDs, identified by its primary NID.
+type MultiRailPeer struct {
+	mu      sync.Mutex
+	primary NID
+	nids    []NID
+	// Ping features of the peer, once discovered
+	features PingFeature
+}
+
+// Primary returns the primary NID of the peer.
+func (mrPeer *MultiRailPeer) Primary() NID {
+	mrPeer.mu.Lock()
+	defer mrPeer.mu.Unlock()
+	return mrPeer.primary
+}
+
+// Features returns the ping features of the peer (0 until it is discovered).
+func (mrPeer *MultiRailPeer) Features() PingFeature {
+	mrPeer.mu.Lock()
+	defer mrPeer.mu.Unlock()
+	return mrPeer.features
+}
+
+// NIDs returns the NIDs of the peer, starting with the primary NID.
+func (mrPeer *MultiRailPeer) NIDs() []NID {
+	mrPeer.mu.Lock()
+	defer mrPeer.mu.Unlock()
+	return slices.Clone(mrPeer.nids)
+}
+
+func (mrPeer *MultiRailPeer) hasNID(nid NID) bool {
+	return slices.ContainsFunc(mrPeer.nids, func(other NID) bool { return SameNID(other, nid) })
+}
+
+// AddPeerNIDs records that the NIDs belong to the peer with the given primary NID,
+// like "lnetctl peer add --prim_nid". NIDs of another multi-rail peer are an error.
+func (table *PeerTable) AddPeerNIDs(primary NID, nids ...NID) (*MultiRailPeer, error) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	mrPeer := table.multiRail[peerKey(primary)]
+	if mrPeer == nil {
+		mrPeer = &MultiRailPeer{primary: primary, nids: []NID{primary}}
+		table.multiRail[peerKey(primary)] = mrPeer
+	}
+	for _, nid := range nids {
+		if other := table.multiRail[peerKey(nid)]; other != nil && other != mrPeer {
+			return nil, fmt.Errorf("NID %s already belongs to peer %s", nid, other.Primary())
+		}
+	}
+	mrPeer.mu.Lock()
+	defer mrPeer.mu.Unlock()
+	for _, nid := range nids {
+		if !mrPeer.hasNID(nid) {
+			mrPeer.nids = append(mrPeer.nids, nid)
+			table.multiRail[peerKey(nid)] = mrPeer
+		}
+	}
+	return mrPeer, nil
+}
+
+// MultiRailPeer returns the multi-rail peer with the given NID, or nil if there is none.
+func (table *PeerTable) MultiRailPeer(nid NID) *MultiRailPeer {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	return table.multiRail[peerKey(nid)]
+}
+
+// peerNIDs returns all NIDs of the peer with the given NID.
+// The port of the given NID is kept for the other NIDs, which are usually learnt without one.
+func (table *PeerTable) peerNIDs(nid NID) []NID {
+	mrPeer := table.MultiRailPeer(nid)
+	if mrPeer == nil {
+		return []NID{nid}
+	}
+	nids := mrPeer.NIDs()
+	for i, other := range nids {
+		if SameNID(other, nid) || (NIDPort(other) == DEFAULT_PORT && NIDPort(nid) != DEFAULT_PORT) {
+			nids[i] = withPort(other, NIDPort(nid))
+		}
+	}
+	return nids
+}
+
+// sameNet reports whether the local NI can reach the NID directly.
+func sameNet(ni LocalNI, nid NID) bool {
+	header := nid.Header()
+	return ni.NetType == header.Type && ni.NetNum == header.NetworkIndex && ni.Addr.Is4() == nid.NetAddr().Unmap().Is4()
+}
+
+// path is where selectPath sends a message: from a local NI to a NID of the peer,
+// directly or through a gateway.
+type path struct {
+	ni  LocalNI
+	nid NID
+	// Gateway on a local network for peers on remote networks, or nil
+	gateway NID
+}
+
+// nextHop returns the NID to connect to.
+func (p path) nextHop() NID {
+	if p.gateway != nil {
+		return p.gateway
+	}
+	return p.nid
+}
+
+// dest returns the NID to address messages sent on the connection to: the NID of a directly
+// connected peer as it knows itself, or the NID of the peer behind the gateway.
+func (p path) dest(remote *RemoteConn) NID {
+	if p.gateway != nil {
+		return p.nid
+	}
+	return remote.NID
+}
+
+// selectPath picks the local NI and the NID of the peer to send to, and the gateway if the
+// peer has no NID on our networks (see RouteTable.selectGateway).
+// Without local NIs, there is nothing to choose from, and the NID is used as is,
+// through a gateway if there is a route to its network.
+func (client *LNetClient) selectPath(nid NID) (path, error) {
+	if nid == nil || nid.IsAny() {
+		return path{}, fmt.Errorf("cannot send to NID %v", nid)
+	}
+	if header := nid.Header(); header.Type == NETWORK_TYPE_LO || header.Type == NETWORK_TYPE_UNIX {
+		// Our own loopback network and sockets on this node need no NI
+		return path{nid: nid}, nil
+	}
+	peerNIDs := client.Peers.peerNIDs(nid)
+	table := client.NIs
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	if len(table.nis) == 0 {
+		gateway, _ := client.Routes.selectGateway([]NID{nid}, nil)
+		return path{nid: nid, gateway: gateway}, nil
+	}
+
+	best, bestState := table.bestNILocked(peerNIDs)
+	if bestState == nil {
+		// Remote network: through a gateway one of our NIs reaches
+		gateway, ok := client.Routes.selectGateway(peerNIDs, func(gateway NID) bool {
+			_, state := table.bestNILocked([]NID{gateway})
+			return state != nil
+		})
+		if !ok {
+			return path{}, fmt.Errorf("cannot send to %s: %w", nid, ErrUnreachable)
+		}
+		best, bestState = table.bestNILocked([]NID{gateway})
+		bestState.seq++
+		return path{ni: best, nid: nid, gateway: gateway}, nil
+	}
+	bestState.seq++
+
+	// Peer NI on the same network: healthiest, most credits, least recently used
+	var bestNID NID
+	var bestPeer *Peer
+	var bestHealth, bestCredits int
+	for _, peerNID := range peerNIDs {
+		if !sameNet(best, peerNID) {
+			continue
+		}
+		peer := client.Peers.peer(peerNID)
+		credits := peer.credits.stats().Available
+		peer.mu.Lock()
+		health := peer.health
+		better := bestPeer == nil || health > bestHealth || (health == bestHealth && (credits > bestCredits ||
+			(credits == bestCredits && peer.seq < bestPeer.seq)))
+		peer.mu.Unlock()
+		if better {
+			bestNID, bestPeer, bestHealth, bestCredits = peerNID, peer, health, credits
+		}
+	}
+	bestPeer.mu.Lock()
+	bestPeer.seq++
+	bestPeer.mu.Unlock()
+	return path{ni: best, nid: bestNID}, nil
+}
+
+// bestNILocked picks the local NI to reach one of the NIDs directly: up, on a network of a NID,
+// healthiest, most credits, least recently used. The state is nil if there is none.
+func (table *NITable) bestNILocked(nids []NID) (LocalNI, *niState) {
+	var best LocalNI
+	var bestState *niState
+	var bestCredits int
+	for _, ni := range table.nis {
+		state := table.states[ni]
+		if state.status != PING_NI_STATUS_UP {
+			continue
+		}
+		if !slices.ContainsFunc(nids, func(nid NID) bool { return sameNet(ni, nid) }) {
+			continue
+		}
+		credits := state.credits.stats().Available
+		if bestState == nil || state.health > bestState.health || (state.health == bestState.health && (credits > bestCredits ||
+			(credits == bestCredits && state.seq < bestState.seq))) {
+			best, bestState, bestCredits = ni, state, credits
+		}
+	}
+	return best, bestState
+}
+
+// withPort returns the NID with another port.
+func withPort(nid NID, port uint16) NID {
+	switch nid := nid.(type) {
+	case NID64:
+		nid.Port = port
+		return nid
+	case ExtendedNID:
+		nid.Port = port
+		return nid
+	}
+	return nid
+}
@@ -0,0 +1,138 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for multi-rail path selection.
+*/
+package lnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func testNI(netNum uint16, addr string) LocalNI {
+	return LocalNI{NetType: NETWORK_TYPE_TCP, NetNum: netNum, Interface: "eth" + addr, Addr: netip.MustParseAddr(addr)}
+}
+
+func mustParseNID(t *testing.T, s string) NID {
+	t.Helper()
+	nid, err := ParseNID(s)
+	if err != nil {
+		t.Fatalf("ParseNID(%q) failed: %v", s, err)
+	}
+	return nid
+}
+
+func TestSelectPath(t *testing.T) {
+	client := NewLNetClient()
+	rail0, rail1, other := testNI(0, "10.0.0.1"), testNI(0, "10.0.0.2"), testNI(1, "10.1.0.1")
+	client.NIs.Set([]LocalNI{rail0, rail1, other})
+	primary := mustParseNID(t, "10.0.0.11@tcp0")
+	secondary := mustParseNID(t, "10.0.0.12@tcp0")
+	if _, err := client.Peers.AddPeerNIDs(primary, secondary, mustParseNID(t, "10.2.0.11@tcp2")); err != nil {
+		t.Fatalf("AddPeerNIDs failed: %v", err)
+	}
+
+	// Round robin over both rails on both sides
+	type hop struct {
+		ni  LocalNI
+		nid string
+	}
+	var paths []hop
+	for range 4 {
+		path, err := client.selectPath(secondary)
+		if err != nil {
+			t.Fatalf("selectPath failed: %v", err)
+		}
+		paths = append(paths, hop{path.ni, path.nid.NetAddr().String()})
+	}
+	expected := []hop{{rail0, "10.0.0.11"}, {rail1, "10.0.0.12"}, {rail0, "10.0.0.11"}, {rail1, "10.0.0.12"}}
+	if fmt.Sprint(paths) != fmt.Sprint(expected) {
+		t.Errorf("selectPath picked %v; expected %v", paths, expected)
+	}
+
+	// Credits come before round robin, and down NIs are skipped
+	_ = client.Peers.Peer(primary).credits.acquire(context.Background())
+	client.NIs.SetStatus(rail0, PING_NI_STATUS_DOWN)
+	for range 2 {
+		path, err := client.selectPath(primary)
+		if err != nil || path.ni != rail1 || !SameNID(path.nid, secondary) {
+			t.Errorf("selectPath picked %v to %v, %v; expected %v to %v", path.ni, path.nid, err, rail1, secondary)
+		}
+	}
+
+	if _, err := client.selectPath(mustParseNID(t, "10.5.0.1@tcp5")); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("selectPath to tcp5 returned %v; expected %v", err, ErrUnreachable)
+	}
+	if _, err := client.Peers.AddPeerNIDs(mustParseNID(t, "10.0.0.99@tcp0"), secondary); err == nil {
+		t.Errorf("AddPeerNIDs accepted a NID of another peer")
+	}
+}
+
+// TestMultiRail sends to a server with NIs on two networks over both of them.
+func TestMultiRail(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	port := uint16(free.Addr().(*net.TCPAddr).Port)
+	free.Close()
+
+	server := NewLNetServer().WithPort(port)
+	server.Client.NIs.Set([]LocalNI{testNI(0, "127.0.0.1"), testNI(1, "127.0.0.2")})
+	go func() { _ = server.Listen(ctx) }()
+	defer server.Shutdown(ctx)
+	serverNIDs := []NID{
+		mustParseNID(t, fmt.Sprintf("127.0.0.1@tcp0#%d", port)),
+		mustParseNID(t, fmt.Sprintf("127.0.0.2@tcp1#%d", port)),
+	}
+
+	client := NewLNetClient()
+	client.NIs.Set([]LocalNI{testNI(0, "127.0.0.3"), testNI(1, "127.0.0.4")})
+	var ping PingResponse
+	for {
+		if ping, err = client.Ping(ctx, serverNIDs[1]); err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("Ping failed: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if PingFeature(ping.Features)&PING_FEATURE_MULTI_RAIL == 0 || len(ping.NIDStatuses) != 2 {
+		t.Fatalf("Ping reply has features %v and NIDs %+v; expected MULTI_RAIL and 2 NIDs", PingFeature(ping.Features), ping.NIDStatuses)
+	}
+	var nids []NID
+	for _, status := range ping.NIDStatuses {
+		if status.Status != PING_NI_STATUS_UP {
+			t.Errorf("Server NI %s is %v; expected UP", status.NID, status.Status)
+		}
+		nids = append(nids, status.NID)
+	}
+	if _, err := client.Peers.AddPeerNIDs(nids[0], nids[1:]...); err != nil {
+		t.Fatalf("AddPeerNIDs failed: %v", err)
+	}
+
+	for i, expected := range []string{"127.0.0.3@tcp0 -> 127.0.0.1", "127.0.0.4@tcp1 -> 127.0.0.2"} {
+		remote, err := client.PeerConn(ctx, serverNIDs[0], 100)
+		if err != nil {
+			t.Fatalf("PeerConn %d failed: %v", i, err)
+		}
+		header := remote.LocalNID.Header()
+		path := fmt.Sprintf("%s@%s%d -> %s", remote.LocalNID.NetAddr(), header.Type, header.NetworkIndex, remote.NID.NetAddr())
+		if path != expected {
+			t.Errorf("PeerConn %d took %s; expected %s", i, path, expected)
+		}
+		if _, err := client.PingRemote(ctx, remote); err != nil {
+			t.Errorf("PingRemote over %s failed: %v", path, err)
+		}
+	}
+}
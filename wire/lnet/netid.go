@@ -14,6 +14,7 @@ import (
 	"net/netip"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 type NID interface {
@@ -21,6 +22,7 @@ type NID interface {
 	NetAddr() netip.Addr
 	IsAny() bool
 	ToBytes(binary.ByteOrder) ([]byte, error)
+	Header() NIDHeader
 }
 
 type NIDHeader struct {
@@ -29,6 +31,12 @@ type NIDHeader struct {
 	NetworkIndex uint16
 }
 
+// Header returns the NID header (network type and number).
+// This is promoted to every NID type embedding NIDHeader.
+func (header NIDHeader) Header() NIDHeader {
+	return header
+}
+
 type RawNID64 uint64
 
 // NID64 is a 64-bit NID that can fit a 32-bit address (e.g., IPv4)
@@ -39,10 +47,13 @@ type NID64 struct {
 	Port uint16
 }
 
-type RawExtendedNID struct {
-	NIDHeader
-	Addr [4]uint32
-}
+// Size of struct lnet_nid, which can hold up to 16 address bytes
+const LNET_NID_SIZE = 20
+
+// RawExtendedNID is the wire representation of a large NID (struct lnet_nid).
+// Unlike NID64, its network number and address are big-endian in both byte orders,
+// so it is kept as bytes.
+type RawExtendedNID [LNET_NID_SIZE]byte
 
 // ExtendedNID is a 160-bit NID that can fit a 128-bit address (e.g., IPv6)
 type ExtendedNID struct {
@@ -69,8 +80,10 @@ func (rawNid RawNID64) ToNID64() NID64 {
 	return NID64{NIDHeader: header, Addr: [1]uint32{addr0}, Port: DEFAULT_PORT}
 }
 
-func (rawNid RawExtendedNID) ToExtendedNID() ExtendedNID {
-	return ExtendedNID{NIDHeader: rawNid.NIDHeader, Addr: rawNid.Addr, Port: DEFAULT_PORT}
+// ToNID decodes a large NID. IPv4 addresses (size 0) are returned as NID64.
+func (rawNid RawExtendedNID) ToNID() (NID, error) {
+	nid, _, err := decodeLargeNID(rawNid[:])
+	return nid, err
 }
 
 // NIDFromAddr creates a NID from a netip.Addr
@@ -78,19 +91,22 @@ func NIDFromAddr(addr netip.Addr, netType NetworkType, netNum uint16, portNum ui
 	if addr.IsUnspecified() {
 		return AnyNID, nil
 	}
+	// IPv4 peers of dual-stack sockets show up as IPv4-mapped IPv6 addresses
+	addr = addr.Unmap()
 	addrBytes := addr.AsSlice()
 	if portNum == 0 {
 		portNum = DEFAULT_PORT
 	}
 	size := uint8(len(addrBytes) - 4)
 	header := NIDHeader{Size: size, Type: netType, NetworkIndex: netNum}
+	// NOTE: netip.Addr uses Big endian, Addr blocks hold host values
 	if addr.Is4() {
-		blocks := [1]uint32{DEFAULT_BYTE_ORDER.Uint32(addr.AsSlice())}
+		blocks := [1]uint32{binary.BigEndian.Uint32(addrBytes)}
 		return NID64{NIDHeader: header, Addr: blocks, Port: portNum}, nil
 	} else if addr.Is6() {
 		var blocks [4]uint32
 		for i := range 4 {
-			blocks[i] = DEFAULT_BYTE_ORDER.Uint32(addrBytes[i*4 : (i+1)*4])
+			blocks[i] = binary.BigEndian.Uint32(addrBytes[i*4 : (i+1)*4])
 		}
 		return ExtendedNID{NIDHeader: header, Addr: blocks, Port: portNum}, nil
 	}
@@ -99,11 +115,18 @@ func NIDFromAddr(addr netip.Addr, netType NetworkType, netNum uint16, portNum ui
 
 var ValidNIDExpr *regexp.Regexp = regexp.MustCompile(`^([0-9a-fA-F:.]+)@([a-zA-Z0-9]+[a-zA-Z])(\d+)(?:#(\d{1,5}))?$`)
 
-// ParseNID parses a string of the form "ADDRESS@PROTOCOL#PORT" into a NID.
+// ParseNID parses a string of the form "ADDRESS@PROTOCOL#PORT" into a NID,
+// or "PATH@unixN" for a Unix-domain socket (see UnixNID).
 func ParseNID(s string) (NID, error) {
 	if s == "any" || s == "*" {
 		return AnyNID, nil
 	}
+	if s == "0@lo" || s == "0@lo0" {
+		return LoNID, nil
+	}
+	if at := strings.LastIndexByte(s, '@'); at != -1 && strings.HasPrefix(s[at+1:], NETWORK_TYPE_UNIX.String()) {
+		return parseUnixNID(s[:at], s[at+1+len(NETWORK_TYPE_UNIX.String()):])
+	}
 	matches := ValidNIDExpr.FindStringSubmatch(s)
 	var addrStr, protoStr, netNumStr, portStr string
 	if matches == nil {
@@ -139,60 +162,140 @@ func ParseNID(s string) (NID, error) {
 	return NIDFromAddr(addr, networkType, uint16(networkNum), uint16(portNum))
 }
 
-// ReadNID reads a NID from a reader (e.g., socket)
-// Note that Lustre uses protocol version to determine read length
-func ReadNID(reader io.Reader, byteOrder binary.ByteOrder, versionHint uint32) (NID, error) {
-	_ = versionHint // This may be required in better ExtendedNID cases
-	// TODO: use RawNID64
-	var rawHeader uint64
-	var header NIDHeader
-	err := binary.Read(reader, byteOrder, &rawHeader)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read NID header: %w", err)
+// ReadNID reads a NID from a reader (e.g., socket).
+// Without large, this is a 64-bit lnet_nid_t in byteOrder. With large, it is a struct lnet_nid,
+// as found in acceptor requests version 2, HELLO version 4 and LNet headers of protocol version 4.
+func ReadNID(reader io.Reader, byteOrder binary.ByteOrder, large bool) (NID, error) {
+	if !large {
+		var raw RawNID64
+		if err := binary.Read(reader, byteOrder, &raw); err != nil {
+			return nil, fmt.Errorf("failed to read NID: %w", err)
+		}
+		return raw.ToNID64(), nil
 	}
+	// Each NID takes a whole struct lnet_nid, whatever its size
+	data := make([]byte, LNET_NID_SIZE)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, fmt.Errorf("failed to read large NID: %w", err)
+	}
+	nid, _, err := decodeLargeNID(data)
+	return nid, err
+}
 
-	addr0 := uint32(rawHeader & 0xFFFFFFFF)
-	header.Size = uint8((rawHeader >> 56) & 0xFF)
-	header.Type = NetworkType((rawHeader >> 48) & 0xFF)
-	header.NetworkIndex = uint16((rawHeader >> 32) & 0xFFFF)
+// Raw converts the NID64 to its 64-bit wire representation (lnet_nid_t).
+// The wildcard is LNET_NID_ANY, with all bits set.
+func (nid NID64) Raw() RawNID64 {
+	if nid.IsAny() {
+		return RawNID64(^uint64(0))
+	}
+	return RawNID64(uint64(nid.Size)<<56 | uint64(nid.Type)<<48 | uint64(nid.NetworkIndex)<<32 | uint64(nid.Addr[0]))
+}
 
-	if header.Type == NETWORK_TYPE_ANY {
-		return AnyNID, nil
+// Raw converts the ExtendedNID to its wire representation (struct lnet_nid).
+func (enid ExtendedNID) Raw() RawExtendedNID {
+	var raw RawExtendedNID
+	copy(raw[:], appendLargeNID(nil, enid))
+	return raw
+}
+
+// appendLargeNID appends the first NID_BYTES (Size+8) bytes of the struct lnet_nid for a NID.
+// NID64s have the large form of size 0. The non-standard port is not included.
+func appendLargeNID(data []byte, nid NID) []byte {
+	header := nid.Header()
+	var addr []uint32
+	switch n := nid.(type) {
+	case NID64:
+		header.Size = 0
+		addr = n.Addr[:]
+	case ExtendedNID:
+		header.Size = 12
+		addr = n.Addr[:]
+	}
+	data = append(data, header.Size, byte(header.Type))
+	data = binary.BigEndian.AppendUint16(data, header.NetworkIndex)
+	for _, block := range addr {
+		data = binary.BigEndian.AppendUint32(data, block)
 	}
+	return data
+}
 
-	// FIXME: right now, we just rely on Size, but ENID MAY actually have size defined later
-	switch header.Size {
-	case 0:
-		return NID64{NIDHeader: header, Addr: [1]uint32{addr0}, Port: DEFAULT_PORT}, nil
-	case 2:
-		// TODO implement this case later
-		// WARNING: Cannot use with Lustre peers
-		return nil, fmt.Errorf("IPv4 + Port not yet supported")
-	case 12:
-		// YAGNI: We MAY need to handle 1-11 size for extended nid
-		var addr [4]uint32
-		addr[0] = addr0
-		addr123 := addr[1:4]
-		err := binary.Read(reader, byteOrder, &addr123)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read ExtendedNID address: %w", err)
+// encodeNID encodes a NID for an LNet header: a struct lnet_nid if large, otherwise a lnet_nid_t.
+func encodeNID(nid NID, byteOrder binary.ByteOrder, large bool) ([]byte, error) {
+	if large {
+		raw := make([]byte, 0, LNET_NID_SIZE)
+		if nid.IsAny() {
+			return bytes.Repeat([]byte{0xFF}, LNET_NID_SIZE), nil
 		}
-		return ExtendedNID{NIDHeader: header, Addr: addr, Port: DEFAULT_PORT}, nil
-	case 14:
-		// TODO implement this case later
-		// WARNING: Cannot use with Lustre peers
-		return nil, fmt.Errorf("IPv6 + Port not yet supported")
+		raw = appendLargeNID(raw, nid)
+		return raw[:LNET_NID_SIZE], nil
+	}
+	if _, ok := nid.(NID64); !ok && !nid.IsAny() {
+		return nil, fmt.Errorf("NID %s does not fit in 64 bits", nid)
 	}
-	return nil, fmt.Errorf("unsupported NID size: %d", header.Size)
+	return nid.ToBytes(byteOrder)
+}
+
+// NIDPort returns the (non-standard) port of the NID, or DEFAULT_PORT if it has none.
+func NIDPort(nid NID) uint16 {
+	var port uint16
+	switch n := nid.(type) {
+	case NID64:
+		port = n.Port
+	case ExtendedNID:
+		port = n.Port
+	}
+	if port == 0 {
+		return DEFAULT_PORT
+	}
+	return port
+}
+
+// SameNID reports whether both NIDs identify the same network endpoint.
+// The non-standard port is ignored, as it is never sent on the wire.
+func SameNID(a, b NID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	aBytes, aErr := a.ToBytes(binary.LittleEndian)
+	bBytes, bErr := b.ToBytes(binary.LittleEndian)
+	return aErr == nil && bErr == nil && bytes.Equal(aBytes, bBytes)
+}
+
+// decodeLargeNID decodes a struct lnet_nid from the start of data and returns the number of bytes used.
+// Unlike NID64, the network number and address of a large NID are always big-endian,
+// and only Size+4 address bytes are present. Only IPv4 (size 0) and IPv6 (size 12) addresses are supported.
+func decodeLargeNID(data []byte) (NID, int, error) {
+	if len(data) < 4 {
+		return nil, 0, fmt.Errorf("failed to read large NID header: %w", io.ErrUnexpectedEOF)
+	}
+	header := NIDHeader{Size: data[0], Type: NetworkType(data[1]), NetworkIndex: binary.BigEndian.Uint16(data[2:4])}
+	if header.Type == NETWORK_TYPE_ANY {
+		// LNET_ANY_NID has all bits set, including its size
+		return AnyNID, min(len(data), LNET_NID_SIZE), nil
+	}
+	if header.Size != 0 && header.Size != 12 {
+		return nil, 0, fmt.Errorf("unsupported NID size: %d", header.Size)
+	}
+	addrLen := int(header.Size) + 4
+	if len(data) < 4+addrLen {
+		return nil, 0, fmt.Errorf("failed to read large NID address: %w", io.ErrUnexpectedEOF)
+	}
+	addrBytes := data[4 : 4+addrLen]
+	if header.Size == 0 {
+		return NID64{NIDHeader: header, Addr: [1]uint32{binary.BigEndian.Uint32(addrBytes)}, Port: DEFAULT_PORT}, 4 + addrLen, nil
+	}
+	var addr [4]uint32
+	for i := range addr {
+		addr[i] = binary.BigEndian.Uint32(addrBytes[i*4:])
+	}
+	return ExtendedNID{NIDHeader: header, Addr: addr, Port: DEFAULT_PORT}, 4 + addrLen, nil
 }
 
 // ToBytes converts the NID64 to a byte slice.
+// On the wire, a NID64 is a single 64-bit value (see ReadNID).
 func (nid NID64) ToBytes(byteOrder binary.ByteOrder) ([]byte, error) {
 	buf := new(bytes.Buffer)
-	if err := binary.Write(buf, byteOrder, nid.NIDHeader); err != nil {
-		return nil, fmt.Errorf("failed to write NID64: %w", err)
-	}
-	if err := binary.Write(buf, byteOrder, nid.Addr); err != nil {
+	if err := binary.Write(buf, byteOrder, nid.Raw()); err != nil {
 		return nil, fmt.Errorf("failed to write NID64: %w", err)
 	}
 	// NB: port is nonstandard. do not write for NID64
@@ -200,16 +303,11 @@ func (nid NID64) ToBytes(byteOrder binary.ByteOrder) ([]byte, error) {
 }
 
 // ToBytes converts the ExtendedNID to a byte slice.
+// On the wire, an ExtendedNID is a struct lnet_nid, which does not depend on byteOrder.
 func (enid ExtendedNID) ToBytes(byteOrder binary.ByteOrder) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	if err := binary.Write(buf, byteOrder, enid.NIDHeader); err != nil {
-		return nil, fmt.Errorf("failed to write ExtendedNID: %w", err)
-	}
-	if err := binary.Write(buf, byteOrder, enid.Addr); err != nil {
-		return nil, fmt.Errorf("failed to write ExtendedNID: %w", err)
-	}
 	// NB: port is nonstandard. do not write for ExtendedNID
-	return buf.Bytes(), nil
+	raw := enid.Raw()
+	return raw[:], nil
 }
 
 // NetAddr converts the NID64 to a netip.Addr, assuming it's an IPv4 address.
@@ -222,15 +320,21 @@ func (nid NID64) NetAddr() netip.Addr {
 
 // NetAddr converts the ExtendedNID to a netip.Addr, assuming it's an IPv6 address.
 func (enid ExtendedNID) NetAddr() netip.Addr {
-	var bytes []byte
+	var bytes [16]byte
 	// NOTE: netip.Addr uses Big endian
-	for _, addrValue := range enid.Addr {
-		binary.BigEndian.AppendUint32(bytes, addrValue)
+	for i, addrValue := range enid.Addr {
+		binary.BigEndian.PutUint32(bytes[i*4:(i+1)*4], addrValue)
 	}
-	return netip.AddrFrom16([16]byte(bytes))
+	return netip.AddrFrom16(bytes)
 }
 
 func (nid NID64) String() string {
+	if nid.Type == NETWORK_TYPE_LO {
+		return "0@lo"
+	}
+	if nid.Type == NETWORK_TYPE_UNIX {
+		return unixNIDString(nid)
+	}
 	return fmt.Sprintf("%s@%s%d#%d", nid.NetAddr().String(), nid.Type.String(), nid.NetworkIndex, nid.Port)
 }
 
@@ -251,3 +355,6 @@ func (enid ExtendedNID) IsAny() bool {
 // In Lustre, the entire structure is set to ~0 (all bits set)
 // (but we really only care about Type being set properly)
 var AnyNID NID = NID64{NIDHeader: NIDHeader{Size: 0xFF, Type: NETWORK_TYPE_ANY, NetworkIndex: 0xFF}, Addr: [1]uint32{0xFFFFFFFF}, Port: DEFAULT_PORT}
+
+// LoNID (0@lo) is the NID of the loopback network, which only reaches the process itself.
+var LoNID NID = NID64{NIDHeader: NIDHeader{Type: NETWORK_TYPE_LO}, Port: DEFAULT_PORT}
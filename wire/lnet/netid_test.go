@@ -0,0 +1,154 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for network identifiers.
+*/
+package lnet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestParseNIDIPv6(t *testing.T) {
+	nid, err := ParseNID("fd00::1@tcp1")
+	if err != nil {
+		t.Fatalf("ParseNID failed: %v", err)
+	}
+	if _, ok := nid.(ExtendedNID); !ok {
+		t.Fatalf("ParseNID returned %T; expected ExtendedNID", nid)
+	}
+	if nid.NetAddr() != netip.MustParseAddr("fd00::1") || nid.Header().NetworkIndex != 1 || nid.Header().Size != 12 {
+		t.Errorf("Parsed %s with header %+v; expected fd00::1 on tcp1", nid.NetAddr(), nid.Header())
+	}
+	reparsed, err := ParseNID(nid.String())
+	if err != nil || !SameNID(nid, reparsed) {
+		t.Errorf("ParseNID(%q) = %v, %v; expected %v", nid.String(), reparsed, err, nid)
+	}
+	// IPv4-mapped addresses (from dual-stack sockets) are IPv4 NIDs
+	mapped, err := NIDFromAddr(netip.MustParseAddr("::ffff:10.0.0.1"), NETWORK_TYPE_TCP, 0, 0)
+	if _, ok := mapped.(NID64); err != nil || !ok {
+		t.Errorf("NIDFromAddr returned %T, %v; expected NID64", mapped, err)
+	}
+}
+
+func TestLargeNIDWire(t *testing.T) {
+	tests := []struct {
+		nid     string
+		encoded string
+	}{
+		// struct lnet_nid is big-endian apart from its size and type bytes
+		{"fd00::1@tcp1", "0c020001fd000000000000000000000000000001"},
+		// IPv4 in the large form only has 4 address bytes, the rest is padding
+		{"10.0.0.1@tcp2", "000200020a000001000000000000000000000000"},
+	}
+	for _, tt := range tests {
+		nid, err := ParseNID(tt.nid)
+		if err != nil {
+			t.Fatalf("ParseNID failed: %v", err)
+		}
+		for _, byteOrder := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+			encoded, err := encodeNID(nid, byteOrder, true)
+			if err != nil {
+				t.Fatalf("encodeNID failed: %v", err)
+			}
+			if hex.EncodeToString(encoded) != tt.encoded {
+				t.Errorf("encodeNID(%s, %v) = %x; expected %s", tt.nid, byteOrder, encoded, tt.encoded)
+			}
+			decoded, err := ReadNID(bytes.NewReader(encoded), byteOrder, true)
+			if err != nil || !SameNID(decoded, nid) {
+				t.Errorf("ReadNID(%x) = %v, %v; expected %s", encoded, decoded, err, tt.nid)
+			}
+		}
+	}
+}
+
+// TestAnyNIDWire checks that the wildcard is written with all bits set (LNET_NID_ANY and
+// LNET_ANY_NID) in both header sizes, and read back as the wildcard.
+func TestAnyNIDWire(t *testing.T) {
+	for _, large := range []bool{false, true} {
+		encoded, err := encodeNID(AnyNID, DEFAULT_BYTE_ORDER, large)
+		if err != nil {
+			t.Fatalf("encodeNID failed: %v", err)
+		}
+		if len(bytes.Trim(encoded, "\xff")) != 0 {
+			t.Errorf("encodeNID(AnyNID, large %v) = %x; expected all bits set", large, encoded)
+		}
+		decoded, err := ReadNID(bytes.NewReader(encoded), DEFAULT_BYTE_ORDER, large)
+		if err != nil || !decoded.IsAny() || !SameNID(decoded, AnyNID) {
+			t.Errorf("ReadNID(%x) = %v, %v; expected %v", encoded, decoded, err, AnyNID)
+		}
+	}
+}
+
+// TestReadNIDSize checks that a large NID in a header always takes a whole struct lnet_nid,
+// and that sizes other than IPv4 and IPv6 are rejected rather than read past.
+func TestReadNIDSize(t *testing.T) {
+	tests := []struct {
+		encoded string
+		valid   bool
+	}{
+		{"000200000a000001000000000000000000000000" + "ffff", true},
+		{"ffffffffffffffffffffffffffffffffffffffff" + "ffff", true},
+		{"020200000a000001232800000000000000000000" + "ffff", false},
+		{"0e020000fd000000000000000000000000000001" + "03dc", false},
+	}
+	for _, tt := range tests {
+		data, _ := hex.DecodeString(tt.encoded)
+		reader := bytes.NewReader(data)
+		_, err := ReadNID(reader, DEFAULT_BYTE_ORDER, true)
+		if (err == nil) != tt.valid {
+			t.Errorf("ReadNID(%s) returned %v; expected valid %v", tt.encoded, err, tt.valid)
+		}
+		if reader.Len() != 2 {
+			t.Errorf("ReadNID(%s) left %d bytes unread; expected 2", tt.encoded, reader.Len())
+		}
+	}
+}
+
+// TestDialIPv6 connects and pings over IPv6, which uses acceptor version 2, HELLO version 4
+// and LNet headers with large NIDs.
+func TestDialIPv6(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	listener, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 is not available: %v", err)
+	}
+	server := NewLNetServer()
+	go func() { _ = server.Serve(ctx, listener) }()
+	defer server.Shutdown(ctx)
+	nid, err := ParseNID(fmt.Sprintf("::1@tcp1#%d", listener.Addr().(*net.TCPAddr).Port))
+	if err != nil {
+		t.Fatalf("ParseNID failed: %v", err)
+	}
+
+	client := NewLNetClient()
+	remote, err := client.Dial(ctx, nid)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer remote.Close()
+	if remote.Version != KSOCK_PROTO_V4 {
+		t.Errorf("Negotiated protocol version %d; expected %d", remote.Version, KSOCK_PROTO_V4)
+	}
+	ping, err := client.PingRemote(ctx, remote)
+	if err != nil {
+		t.Fatalf("PingRemote failed: %v", err)
+	}
+	features := PingFeature(ping.Features)
+	if features&PING_FEATURE_LARGE_ADDRESS == 0 || features&PING_FEATURE_PRIMARY_LARGE == 0 {
+		t.Errorf("Ping reply has features %v; expected LARGE_ADDRESS and PRIMARY_LARGE", features)
+	}
+	if primary := ping.Primary(); !SameNID(primary, nid) {
+		t.Errorf("Ping reply has primary NID %v; expected %v", primary, nid)
+	}
+}
@@ -0,0 +1,554 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Local network interfaces (NIs), configured like Lustre's lnet module with networks= or ip2nets=.
+*/
+package lnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// DEFAULT_INTERFACE_POLL is how often WatchInterfaces looks for address changes by default.
+const DEFAULT_INTERFACE_POLL = 10 * time.Second
+
+// Tx credits of each local NI (socklnd's credits)
+const DEFAULT_NI_CREDITS = 256
+
+// NetworkSpec is a network with the host interfaces it uses, e.g., "tcp0(eth0,eth1)".
+// Without interfaces, the first usable interface is picked, as in Lustre.
+type NetworkSpec struct {
+	NetType    NetworkType
+	NetNum     uint16
+	Interfaces []string
+}
+
+// IP2NetsRule selects its network if a host address matches any of its ranges,
+// e.g., "tcp1(eth1) 192.168.0.*".
+type IP2NetsRule struct {
+	Network NetworkSpec
+	Ranges  []AddrRange
+}
+
+// NetworkConfig is the lnet networks= or ip2nets= module parameter. Only one of them may be set.
+type NetworkConfig struct {
+	Networks []NetworkSpec
+	IP2Nets  []IP2NetsRule
+}
+
+// Interface is a host network interface with its addresses.
+type Interface struct {
+	Name     string
+	Up       bool
+	Loopback bool
+	Addrs    []netip.Addr
+}
+
+// LocalNI is a local network interface (lnet_ni): an address of ours on an LNet network.
+// On unix networks, Interface is the socket path, and there is no Addr.
+type LocalNI struct {
+	NetType   NetworkType
+	NetNum    uint16
+	Interface string
+	Addr      netip.Addr
+}
+
+func (spec NetworkSpec) String() string {
+	s := fmt.Sprintf("%s%d", spec.NetType, spec.NetNum)
+	if len(spec.Interfaces) > 0 {
+		s += "(" + strings.Join(spec.Interfaces, ",") + ")"
+	}
+	return s
+}
+
+// NID returns the NID of the NI for peers to reach us on the given port.
+func (ni LocalNI) NID(port uint16) (NID, error) {
+	if ni.NetType == NETWORK_TYPE_UNIX {
+		return UnixNID(unixSocket(ni), ni.NetNum)
+	}
+	return NIDFromAddr(ni.Addr, ni.NetType, ni.NetNum, port)
+}
+
+func (ni LocalNI) String() string {
+	if ni.NetType == NETWORK_TYPE_UNIX {
+		return fmt.Sprintf("%s@%s%d", unixSocket(ni), ni.NetType, ni.NetNum)
+	}
+	return fmt.Sprintf("%s@%s%d(%s)", ni.Addr, ni.NetType, ni.NetNum, ni.Interface)
+}
+
+// ParseNetworks parses the lnet networks= syntax: a comma separated list of networks,
+// each with an optional list of interfaces in parentheses, e.g., "tcp0(eth0),tcp1(eth1)".
+// CPT lists after the interfaces (e.g., "tcp0(eth0)[0,1]") are accepted and ignored.
+func ParseNetworks(s string) ([]NetworkSpec, error) {
+	var specs []NetworkSpec
+	for _, item := range splitOutside(s, ',') {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		spec, err := parseNetworkSpec(item)
+		if err != nil {
+			return nil, err
+		}
+		if slices.ContainsFunc(specs, func(other NetworkSpec) bool {
+			return other.NetType == spec.NetType && other.NetNum == spec.NetNum
+		}) {
+			return nil, fmt.Errorf("duplicate network %s%d in %q", spec.NetType, spec.NetNum, s)
+		}
+		specs = append(specs, spec)
+	}
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("no networks in %q", s)
+	}
+	return specs, nil
+}
+
+// parseNetworkSpec parses a single network such as "tcp0(eth0,eth1)".
+func parseNetworkSpec(s string) (NetworkSpec, error) {
+	netStr, rest, hasInterfaces := strings.Cut(s, "(")
+	var interfaces []string
+	if hasInterfaces {
+		list, tail, ok := strings.Cut(rest, ")")
+		if !ok {
+			return NetworkSpec{}, fmt.Errorf("invalid network %q: missing ')'", s)
+		}
+		if tail != "" && !(strings.HasPrefix(tail, "[") && strings.HasSuffix(tail, "]")) {
+			return NetworkSpec{}, fmt.Errorf("invalid network %q: unexpected %q", s, tail)
+		}
+		for _, name := range strings.Split(list, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				interfaces = append(interfaces, name)
+			}
+		}
+	}
+	netStr = strings.TrimSpace(netStr)
+	netType, netNums, err := parseNetRange(netStr)
+	if err != nil {
+		return NetworkSpec{}, fmt.Errorf("invalid network %q: %w", s, err)
+	}
+	if len(netNums) != 1 || netNums[0].Lo != netNums[0].Hi {
+		return NetworkSpec{}, fmt.Errorf("invalid network %q: expected a single net number", s)
+	}
+	if netType == NETWORK_TYPE_LO {
+		return NetworkSpec{}, fmt.Errorf("invalid network %q: the lo network is implicit", s)
+	}
+	return NetworkSpec{NetType: netType, NetNum: uint16(netNums[0].Lo), Interfaces: interfaces}, nil
+}
+
+// ParseIP2Nets parses the lnet ip2nets= syntax: rules separated by ';' or newlines,
+// each a network followed by IP address ranges, e.g., "tcp0(eth0) 192.168.0.*; tcp1 10.0.[0-3].*".
+// Comments start with '#' and end at the end of the line.
+func ParseIP2Nets(s string) ([]IP2NetsRule, error) {
+	var rules []IP2NetsRule
+	for _, line := range strings.Split(s, "\n") {
+		line, _, _ = strings.Cut(line, "#")
+		for _, ruleStr := range strings.Split(line, ";") {
+			ruleStr = strings.TrimSpace(ruleStr)
+			if ruleStr == "" {
+				continue
+			}
+			// The network ends at the first space, unless it is in the interface list
+			netEnd := strings.IndexFunc(ruleStr, unicode.IsSpace)
+			if open := strings.Index(ruleStr, "("); open != -1 && (netEnd == -1 || open < netEnd) {
+				netEnd = strings.Index(ruleStr, ")") + 1
+				if netEnd == 0 {
+					return nil, fmt.Errorf("invalid ip2nets rule %q: missing ')'", ruleStr)
+				}
+				if cpts := strings.Index(ruleStr[netEnd:], "]"); strings.HasPrefix(ruleStr[netEnd:], "[") {
+					netEnd += cpts + 1
+				}
+			}
+			if netEnd == -1 {
+				netEnd = len(ruleStr)
+			}
+			spec, err := parseNetworkSpec(ruleStr[:netEnd])
+			if err != nil {
+				return nil, fmt.Errorf("invalid ip2nets rule %q: %w", ruleStr, err)
+			}
+			fields := strings.Fields(ruleStr[netEnd:])
+			rule := IP2NetsRule{Network: spec}
+			for _, rangeStr := range fields {
+				addrRange, err := parseAddrRange(rangeStr)
+				if err != nil || addrRange.Kind == ADDR_RANGE_NUM {
+					return nil, fmt.Errorf("invalid ip2nets rule %q: invalid IP range %q", ruleStr, rangeStr)
+				}
+				rule.Ranges = append(rule.Ranges, addrRange)
+			}
+			if len(rule.Ranges) == 0 {
+				return nil, fmt.Errorf("invalid ip2nets rule %q: no IP ranges", ruleStr)
+			}
+			rules = append(rules, rule)
+		}
+	}
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no rules in ip2nets %q", s)
+	}
+	return rules, nil
+}
+
+// ParseNetworkConfig parses the networks= or ip2nets= parameter (the other must be empty).
+// If both are empty, the configuration defaults to "tcp0", like Lustre.
+func ParseNetworkConfig(networks string, ip2nets string) (NetworkConfig, error) {
+	switch {
+	case networks != "" && ip2nets != "":
+		return NetworkConfig{}, fmt.Errorf("only one of networks and ip2nets may be set")
+	case ip2nets != "":
+		rules, err := ParseIP2Nets(ip2nets)
+		if err != nil {
+			return NetworkConfig{}, err
+		}
+		return NetworkConfig{IP2Nets: rules}, nil
+	case networks == "":
+		networks = "tcp0"
+	}
+	specs, err := ParseNetworks(networks)
+	if err != nil {
+		return NetworkConfig{}, err
+	}
+	return NetworkConfig{Networks: specs}, nil
+}
+
+// splitOutside splits s on sep, ignoring separators inside parentheses or brackets.
+func splitOutside(s string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case sep:
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// HostInterfaces lists the network interfaces of the host with their IP addresses.
+func HostInterfaces() ([]Interface, error) {
+	netIfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list network interfaces: %w", err)
+	}
+	ifaces := make([]Interface, 0, len(netIfaces))
+	for _, netIface := range netIfaces {
+		iface := Interface{
+			Name:     netIface.Name,
+			Up:       netIface.Flags&net.FlagUp != 0,
+			Loopback: netIface.Flags&net.FlagLoopback != 0,
+		}
+		addrs, err := netIface.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list addresses of %s: %w", netIface.Name, err)
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				if ip, ok := netip.AddrFromSlice(ipNet.IP); ok {
+					iface.Addrs = append(iface.Addrs, ip.Unmap())
+				}
+			}
+		}
+		ifaces = append(ifaces, iface)
+	}
+	return ifaces, nil
+}
+
+// usableAddr reports whether the address can be the address of an NI.
+// Link-local addresses need a zone, which NIDs cannot carry.
+func usableAddr(addr netip.Addr) bool {
+	return addr.IsValid() && !addr.IsLinkLocalUnicast() && !addr.IsMulticast() && !addr.IsUnspecified()
+}
+
+// interfaceAddr picks the NI address of the interface among those accepted by match:
+// IPv4 is preferred, as peers without large NID support can only reach IPv4 NIDs.
+func interfaceAddr(iface Interface, match func(netip.Addr) bool) (netip.Addr, bool) {
+	var found netip.Addr
+	for _, addr := range iface.Addrs {
+		if !usableAddr(addr) || !match(addr) {
+			continue
+		}
+		if addr.Is4() {
+			return addr, true
+		}
+		if !found.IsValid() {
+			found = addr
+		}
+	}
+	return found, found.IsValid()
+}
+
+// Resolve turns the configuration into NIs on the given host interfaces.
+// Interfaces that are missing, down or without a usable address are reported in the error,
+// but the NIs that could be resolved are still returned.
+func (config NetworkConfig) Resolve(ifaces []Interface) ([]LocalNI, error) {
+	var nis []LocalNI
+	var errs []error
+	matchAny := func(netip.Addr) bool { return true }
+	add := func(spec NetworkSpec, name string, match func(netip.Addr) bool) {
+		index := slices.IndexFunc(ifaces, func(iface Interface) bool { return iface.Name == name })
+		if index == -1 {
+			errs = append(errs, fmt.Errorf("network %s: interface %s not found", spec, name))
+			return
+		}
+		if !ifaces[index].Up {
+			errs = append(errs, fmt.Errorf("network %s: interface %s is down", spec, name))
+			return
+		}
+		addr, ok := interfaceAddr(ifaces[index], match)
+		if !ok {
+			errs = append(errs, fmt.Errorf("network %s: interface %s has no usable address", spec, name))
+			return
+		}
+		nis = append(nis, LocalNI{NetType: spec.NetType, NetNum: spec.NetNum, Interface: name, Addr: addr})
+	}
+	// firstInterface is the interface picked for networks without interfaces (the first usable one)
+	firstInterface := func(match func(netip.Addr) bool) string {
+		for _, iface := range ifaces {
+			if _, ok := interfaceAddr(iface, match); ok && iface.Up && !iface.Loopback {
+				return iface.Name
+			}
+		}
+		return ""
+	}
+	addSpec := func(spec NetworkSpec, match func(netip.Addr) bool) {
+		if spec.NetType == NETWORK_TYPE_UNIX {
+			// Sockets, not host interfaces
+			paths := spec.Interfaces
+			if len(paths) == 0 {
+				paths = []string{DEFAULT_UNIX_SOCKET}
+			}
+			for _, path := range paths {
+				ni := LocalNI{NetType: spec.NetType, NetNum: spec.NetNum, Interface: path}
+				if _, err := ni.NID(0); err != nil {
+					errs = append(errs, fmt.Errorf("network %s: %w", spec, err))
+					continue
+				}
+				nis = append(nis, ni)
+			}
+			return
+		}
+		names := spec.Interfaces
+		if len(names) == 0 {
+			name := firstInterface(match)
+			if name == "" {
+				errs = append(errs, fmt.Errorf("network %s: no usable interface", spec))
+				return
+			}
+			names = []string{name}
+		}
+		for _, name := range names {
+			add(spec, name, match)
+		}
+	}
+
+	for _, spec := range config.Networks {
+		addSpec(spec, matchAny)
+	}
+	// The first matching rule of each network wins
+	type network struct {
+		netType NetworkType
+		netNum  uint16
+	}
+	selected := make(map[network]bool)
+	for _, rule := range config.IP2Nets {
+		key := network{rule.Network.NetType, rule.Network.NetNum}
+		if selected[key] {
+			continue
+		}
+		match := func(addr netip.Addr) bool {
+			return slices.ContainsFunc(rule.Ranges, func(addrRange AddrRange) bool { return addrRange.Match(addr) })
+		}
+		matched := slices.ContainsFunc(ifaces, func(iface Interface) bool {
+			_, ok := interfaceAddr(iface, match)
+			return ok && iface.Up
+		})
+		if !matched {
+			continue
+		}
+		selected[key] = true
+		if len(rule.Network.Interfaces) > 0 || rule.Network.NetType == NETWORK_TYPE_UNIX {
+			addSpec(rule.Network, matchAny)
+			continue
+		}
+		// Without interfaces, the network uses every interface with a matching address
+		for _, iface := range ifaces {
+			if _, ok := interfaceAddr(iface, match); ok && iface.Up {
+				add(rule.Network, iface.Name, match)
+			}
+		}
+	}
+	if len(config.IP2Nets) > 0 && len(selected) == 0 {
+		errs = append(errs, fmt.Errorf("no ip2nets rule matches the host addresses"))
+	}
+	return nis, errors.Join(errs...)
+}
+
+// NITable holds the local NIs of a client, which can change while it runs.
+type NITable struct {
+	mu  sync.Mutex
+	nis []LocalNI
+	// Runtime state of each NI in nis
+	states map[LocalNI]*niState
+	// Closed (and replaced) when the NIs change
+	changed chan struct{}
+	// Tx credits of new NIs
+	credits int
+}
+
+// niState is the runtime state of a local NI (the rest of lnet_ni).
+type niState struct {
+	status PingStatus
+	// Tx credits (credits)
+	credits *creditPool
+	// Health value, up to LNET_MAX_HEALTH_VALUE
+	health int
+	// Number of times the NI was selected, for round robin
+	seq uint64
+}
+
+// NIInfo is a snapshot of a local NI and its state.
+type NIInfo struct {
+	LocalNI
+	Status  PingStatus
+	Health  int
+	Credits CreditStats
+}
+
+func NewNITable() *NITable {
+	return &NITable{states: make(map[LocalNI]*niState), changed: make(chan struct{}), credits: DEFAULT_NI_CREDITS}
+}
+
+// NIs returns the current NIs.
+func (table *NITable) NIs() []LocalNI {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	return slices.Clone(table.nis)
+}
+
+// Info returns the current NIs with their state.
+func (table *NITable) Info() []NIInfo {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	infos := make([]NIInfo, len(table.nis))
+	for i, ni := range table.nis {
+		state := table.states[ni]
+		infos[i] = NIInfo{LocalNI: ni, Status: state.status, Health: state.health, Credits: state.credits.stats()}
+	}
+	return infos
+}
+
+// Changed returns a channel that is closed on the next change of the NIs.
+func (table *NITable) Changed() <-chan struct{} {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	return table.changed
+}
+
+// Set replaces the NIs, and reports whether they changed.
+// NIs that remain keep their state, new ones are up and healthy, with all their credits.
+func (table *NITable) Set(nis []LocalNI) bool {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	if slices.Equal(table.nis, nis) {
+		return false
+	}
+	states := make(map[LocalNI]*niState, len(nis))
+	for _, ni := range nis {
+		if states[ni] = table.states[ni]; states[ni] == nil {
+			states[ni] = &niState{status: PING_NI_STATUS_UP, credits: newCreditPool(table.credits), health: LNET_MAX_HEALTH_VALUE}
+		}
+	}
+	table.nis = slices.Clone(nis)
+	table.states = states
+	close(table.changed)
+	table.changed = make(chan struct{})
+	return true
+}
+
+// SetStatus marks the NI up or down. Down NIs are not selected for sending.
+func (table *NITable) SetStatus(ni LocalNI, status PingStatus) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	if state := table.states[ni]; state != nil {
+		state.status = status
+	}
+}
+
+// lookup returns the NI with the address of the NID on its network.
+func (table *NITable) lookup(nid NID) (LocalNI, bool) {
+	if nid == nil || nid.IsAny() {
+		return LocalNI{}, false
+	}
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	header := nid.Header()
+	for _, ni := range table.nis {
+		if ni.NetType == NETWORK_TYPE_UNIX {
+			if niNID, err := ni.NID(0); err == nil && SameNID(niNID, nid) {
+				return ni, true
+			}
+			continue
+		}
+		if ni.NetType == header.Type && ni.NetNum == header.NetworkIndex && ni.Addr == nid.NetAddr().Unmap() {
+			return ni, true
+		}
+	}
+	return LocalNI{}, false
+}
+
+// ConfigureNetworks resolves the configuration on the host interfaces and sets client.NIs.
+// Unlike WatchInterfaces, any network that cannot be resolved is an error.
+func (client *LNetClient) ConfigureNetworks(config NetworkConfig) error {
+	ifaces, err := HostInterfaces()
+	if err != nil {
+		return err
+	}
+	nis, err := config.Resolve(ifaces)
+	if err != nil {
+		return fmt.Errorf("failed to configure networks: %w", err)
+	}
+	client.NIs.Set(nis)
+	slog.Info("LNet networks configured", "nis", nis)
+	return nil
+}
+
+// WatchInterfaces polls the host interfaces every interval (DEFAULT_INTERFACE_POLL if 0)
+// and updates client.NIs when their addresses change, until ctx is cancelled.
+func (client *LNetClient) WatchInterfaces(ctx context.Context, config NetworkConfig, interval time.Duration) error {
+	if interval == 0 {
+		interval = DEFAULT_INTERFACE_POLL
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		ifaces, err := HostInterfaces()
+		if err != nil {
+			slog.Warn("failed to watch network interfaces", "error", err)
+			continue
+		}
+		nis, err := config.Resolve(ifaces)
+		if client.NIs.Set(nis) {
+			slog.Info("LNet networks changed", "nis", nis, "error", err)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for the network configuration.
+*/
+package lnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestParseNetworks(t *testing.T) {
+	tests := []struct {
+		networks string
+		expected []NetworkSpec
+	}{
+		{"tcp0(eth0),tcp1(eth1, eth2)", []NetworkSpec{
+			{NetType: NETWORK_TYPE_TCP, NetNum: 0, Interfaces: []string{"eth0"}},
+			{NetType: NETWORK_TYPE_TCP, NetNum: 1, Interfaces: []string{"eth1", "eth2"}},
+		}},
+		{"tcp", []NetworkSpec{{NetType: NETWORK_TYPE_TCP}}},
+		{" o2ib1(ib0)[0,1], tcp2 ", []NetworkSpec{
+			{NetType: NETWORK_TYPE_O2IB, NetNum: 1, Interfaces: []string{"ib0"}},
+			{NetType: NETWORK_TYPE_TCP, NetNum: 2},
+		}},
+	}
+	for _, tt := range tests {
+		specs, err := ParseNetworks(tt.networks)
+		if err != nil {
+			t.Fatalf("ParseNetworks(%q) failed: %v", tt.networks, err)
+		}
+		if fmt.Sprint(specs) != fmt.Sprint(tt.expected) {
+			t.Errorf("ParseNetworks(%q) = %v; expected %v", tt.networks, specs, tt.expected)
+		}
+	}
+	for _, networks := range []string{"", "tcp0(eth0", "tcp0(eth0)x", "lo", "tcp0,tcp", "foo0", "tcp[0-1]"} {
+		if specs, err := ParseNetworks(networks); err == nil {
+			t.Errorf("ParseNetworks(%q) = %v; expected an error", networks, specs)
+		}
+	}
+	if _, err := ParseNetworkConfig("tcp0", "tcp0 10.0.0.*"); err == nil {
+		t.Errorf("ParseNetworkConfig accepted both networks and ip2nets")
+	}
+}
+
+var testInterfaces = []Interface{
+	{Name: "lo", Up: true, Loopback: true, Addrs: []netip.Addr{netip.MustParseAddr("127.0.0.1")}},
+	{Name: "eth0", Up: true, Addrs: []netip.Addr{
+		netip.MustParseAddr("fe80::1"), netip.MustParseAddr("fd00::5"), netip.MustParseAddr("10.0.0.5"),
+	}},
+	{Name: "eth1", Up: false, Addrs: []netip.Addr{netip.MustParseAddr("10.1.0.5")}},
+	{Name: "eth2", Up: true, Addrs: []netip.Addr{netip.MustParseAddr("fe80::2"), netip.MustParseAddr("fd00::9")}},
+	{Name: "eth3", Up: true, Addrs: []netip.Addr{netip.MustParseAddr("192.168.1.7")}},
+}
+
+func TestResolveNetworks(t *testing.T) {
+	tests := []struct {
+		networks string
+		ip2nets  string
+		expected []string
+		failed   bool
+	}{
+		// Without interfaces, the first non-loopback interface (preferring its IPv4 address)
+		{"tcp", "", []string{"10.0.0.5@tcp0(eth0)"}, false},
+		{"tcp0(eth2),tcp1(lo,eth3)", "", []string{"fd00::9@tcp0(eth2)", "127.0.0.1@tcp1(lo)", "192.168.1.7@tcp1(eth3)"}, false},
+		{"tcp0(eth1,eth0),tcp1(eth9)", "", []string{"10.0.0.5@tcp0(eth0)"}, true},
+		// The first matching rule of each network wins
+		{"", "tcp0 192.168.*.*; tcp0 10.*.*.*; tcp1(eth0) 10.0.0.[1-10] # comment\no2ib0 10.2.*.*", []string{
+			"192.168.1.7@tcp0(eth3)", "10.0.0.5@tcp1(eth0)",
+		}, false},
+		{"", "tcp2 fd00::[1-f]", []string{"fd00::5@tcp2(eth0)", "fd00::9@tcp2(eth2)"}, false},
+		{"", "tcp0 172.16.*.*", nil, true},
+	}
+	for _, tt := range tests {
+		config, err := ParseNetworkConfig(tt.networks, tt.ip2nets)
+		if err != nil {
+			t.Fatalf("ParseNetworkConfig(%q, %q) failed: %v", tt.networks, tt.ip2nets, err)
+		}
+		nis, err := config.Resolve(testInterfaces)
+		if (err != nil) != tt.failed {
+			t.Errorf("Resolve(%q, %q) returned error %v; expected failure: %v", tt.networks, tt.ip2nets, err, tt.failed)
+		}
+		var resolved []string
+		for _, ni := range nis {
+			resolved = append(resolved, ni.String())
+		}
+		if !slices.Equal(resolved, tt.expected) {
+			t.Errorf("Resolve(%q, %q) = %v; expected %v", tt.networks, tt.ip2nets, resolved, tt.expected)
+		}
+	}
+}
+
+// TestListenNIs checks that the server listens on the addresses of its NIs as they change,
+// and advertises them in ping replies.
+func TestListenNIs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	port := uint16(free.Addr().(*net.TCPAddr).Port)
+	free.Close()
+
+	server := NewLNetServer().WithPort(port)
+	ni := LocalNI{NetType: NETWORK_TYPE_TCP, NetNum: 0, Interface: "lo", Addr: netip.MustParseAddr("127.0.0.1")}
+	server.Client.NIs.Set([]LocalNI{ni})
+	listenCtx, stopListening := context.WithCancel(ctx)
+	listened := make(chan error, 1)
+	go func() { listened <- server.Listen(listenCtx) }()
+	defer server.Shutdown(ctx)
+
+	// ping retries until the listener is up (or down, if closed is set)
+	ping := func(addr string, closed bool) PingResponse {
+		nid, err := ParseNID(fmt.Sprintf("%s@tcp0#%d", addr, port))
+		if err != nil {
+			t.Fatalf("ParseNID failed: %v", err)
+		}
+		client := NewLNetClient()
+		for {
+			ping, err := client.Ping(ctx, nid)
+			if (err == nil) != closed {
+				return ping
+			}
+			if ctx.Err() != nil {
+				t.Fatalf("Ping of %s returned %v", nid, err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	reply := ping("127.0.0.1", false)
+	if len(reply.NIDStatuses) != 1 || reply.NIDStatuses[0].NID.NetAddr() != ni.Addr {
+		t.Errorf("Ping reply has NIDs %+v; expected %s", reply.NIDStatuses, ni.Addr)
+	}
+
+	ni.Addr = netip.MustParseAddr("127.0.0.2")
+	server.Client.NIs.Set([]LocalNI{ni})
+	reply = ping("127.0.0.2", false)
+	if len(reply.NIDStatuses) != 1 || reply.NIDStatuses[0].NID.NetAddr() != ni.Addr {
+		t.Errorf("Ping reply has NIDs %+v; expected %s", reply.NIDStatuses, ni.Addr)
+	}
+	ping("127.0.0.1", true)
+
+	stopListening()
+	if err := <-listened; err != nil && !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Listen returned %v", err)
+	}
+}
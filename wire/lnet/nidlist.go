@@ -0,0 +1,551 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+NID list expressions (libcfs nidstr.c), as used in access control, nodemaps, routes and failover lists.
+*/
+package lnet
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// The grammar follows libcfs, extended with IPv6 address ranges and net number ranges:
+//
+//	<nidlist>     :== <nidrange> [ ' ' <nidrange> ]
+//	<nidrange>    :== <addrrange> '@' <net>
+//	<addrrange>   :== '*' | <ipv4range> | <ipv6range> | <exprlist>
+//	<ipv4range>   :== <exprlist> '.' <exprlist> '.' <exprlist> '.' <exprlist>
+//	<ipv6range>   :== IPv6 address, where each group may be a hexadecimal <exprlist>
+//	<net>         :== <nettype> [ <exprlist> ]
+//	<exprlist>    :== <number> | '[' <rangeexpr> [ ',' <rangeexpr> ] ']'
+//	<rangeexpr>   :== <number> | <number> '-' <number> [ '/' <number> ]
+//
+// e.g., "192.168.[0-3].[1-254/2]@tcp0 *@o2ib[1-2] fd00::[1-ff]@tcp"
+
+// RangeExpr matches the numbers from Lo to Hi (inclusive) in increments of Step.
+// libcfs: struct cfs_range_expr
+type RangeExpr struct {
+	Lo   uint32
+	Hi   uint32
+	Step uint32
+}
+
+// ExprList matches a number if any of its range expressions matches.
+// libcfs: struct cfs_expr_list
+type ExprList []RangeExpr
+
+// AddrRangeKind is the address family of an AddrRange.
+type AddrRangeKind int
+
+const (
+	ADDR_RANGE_ANY  AddrRangeKind = iota // '*'
+	ADDR_RANGE_IPV4                      // four decimal expression lists
+	ADDR_RANGE_IPV6                      // eight hexadecimal expression lists
+	ADDR_RANGE_NUM                       // a single expression list, e.g., "0@lo"
+)
+
+// AddrRange matches the address part of a NID.
+type AddrRange struct {
+	Kind  AddrRangeKind
+	Parts []ExprList
+}
+
+// NIDRange matches NIDs on a range of networks of a single type.
+// libcfs: struct nidrange
+type NIDRange struct {
+	Addr    AddrRange
+	NetType NetworkType
+	NetNums ExprList
+}
+
+// NIDList matches a NID if any of its ranges matches.
+type NIDList []NIDRange
+
+// Match reports whether the number is matched by the range expression.
+func (expr RangeExpr) Match(value uint32) bool {
+	return value >= expr.Lo && value <= expr.Hi && (value-expr.Lo)%expr.Step == 0
+}
+
+// Match reports whether the number is matched by any expression in the list.
+func (list ExprList) Match(value uint32) bool {
+	for _, expr := range list {
+		if expr.Match(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether the address is in the range.
+func (addrRange AddrRange) Match(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	switch addrRange.Kind {
+	case ADDR_RANGE_ANY:
+		return true
+	case ADDR_RANGE_IPV4:
+		if !addr.Is4() {
+			return false
+		}
+		for i, octet := range addr.As4() {
+			if !addrRange.Parts[i].Match(uint32(octet)) {
+				return false
+			}
+		}
+		return true
+	case ADDR_RANGE_IPV6:
+		if !addr.Is6() {
+			return false
+		}
+		bytes := addr.As16()
+		for i := range 8 {
+			if !addrRange.Parts[i].Match(uint32(bytes[2*i])<<8 | uint32(bytes[2*i+1])) {
+				return false
+			}
+		}
+		return true
+	case ADDR_RANGE_NUM:
+		if !addr.Is4() {
+			return false
+		}
+		bytes := addr.As4()
+		return addrRange.Parts[0].Match(uint32(bytes[0])<<24 | uint32(bytes[1])<<16 | uint32(bytes[2])<<8 | uint32(bytes[3]))
+	}
+	return false
+}
+
+// Match reports whether the NID is in the range. The port of the NID is ignored.
+func (nidRange NIDRange) Match(nid NID) bool {
+	if nid == nil || nid.IsAny() {
+		return false
+	}
+	header := nid.Header()
+	return header.Type == nidRange.NetType &&
+		nidRange.NetNums.Match(uint32(header.NetworkIndex)) &&
+		nidRange.Addr.Match(nid.NetAddr())
+}
+
+// Match reports whether the NID is in any range of the list.
+// libcfs: cfs_match_nid
+func (list NIDList) Match(nid NID) bool {
+	for _, nidRange := range list {
+		if nidRange.Match(nid) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseNIDList parses a whitespace separated list of NID range expressions.
+// libcfs: cfs_parse_nidlist
+func ParseNIDList(s string) (NIDList, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty NID list")
+	}
+	list := make(NIDList, 0, len(fields))
+	for _, field := range fields {
+		nidRange, err := ParseNIDRange(field)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, nidRange)
+	}
+	return list, nil
+}
+
+// ParseNIDRange parses a single NID range expression such as "10.0.[0-3].*@tcp0".
+func ParseNIDRange(s string) (NIDRange, error) {
+	addrStr, netStr, ok := strings.Cut(s, "@")
+	if !ok || addrStr == "" || netStr == "" {
+		return NIDRange{}, fmt.Errorf("invalid NID range %q: expected <address>@<net>", s)
+	}
+	netType, netNums, err := parseNetRange(netStr)
+	if err != nil {
+		return NIDRange{}, fmt.Errorf("invalid NID range %q: %w", s, err)
+	}
+	addr, err := parseAddrRange(addrStr)
+	if err != nil {
+		return NIDRange{}, fmt.Errorf("invalid NID range %q: %w", s, err)
+	}
+	return NIDRange{Addr: addr, NetType: netType, NetNums: netNums}, nil
+}
+
+// parseNetRange parses a network type followed by an optional net number expression.
+// A missing net number means net 0, as in Lustre.
+func parseNetRange(s string) (NetworkType, ExprList, error) {
+	// Network types may contain digits themselves (o2ib), so the net number is taken from the end
+	end := strings.LastIndex(s, "[")
+	if !strings.HasSuffix(s, "]") {
+		end = len(strings.TrimRight(s, "0123456789"))
+	}
+	if end <= 0 {
+		return NETWORK_TYPE_INVALID, nil, fmt.Errorf("invalid network %q", s)
+	}
+	netType, err := NetworkTypeFromString(s[:end])
+	if err != nil {
+		return NETWORK_TYPE_INVALID, nil, err
+	}
+	if end == len(s) {
+		return netType, ExprList{{Lo: 0, Hi: 0, Step: 1}}, nil
+	}
+	netNums, err := parseExprList(s[end:], 10, 0xFFFF)
+	if err != nil {
+		return NETWORK_TYPE_INVALID, nil, fmt.Errorf("invalid net number: %w", err)
+	}
+	return netType, netNums, nil
+}
+
+func parseAddrRange(s string) (AddrRange, error) {
+	switch {
+	case s == "*":
+		return AddrRange{Kind: ADDR_RANGE_ANY}, nil
+	case strings.Contains(s, ":"):
+		return parseIPv6Range(s)
+	case strings.Contains(s, "."):
+		parts, err := splitExprLists(s, ".")
+		if err != nil {
+			return AddrRange{}, err
+		}
+		if len(parts) != 4 {
+			return AddrRange{}, fmt.Errorf("invalid IPv4 address range %q", s)
+		}
+		addrRange := AddrRange{Kind: ADDR_RANGE_IPV4, Parts: make([]ExprList, 4)}
+		for i, part := range parts {
+			if addrRange.Parts[i], err = parseAddrPart(part, 10, 0xFF); err != nil {
+				return AddrRange{}, fmt.Errorf("invalid IPv4 address range %q: %w", s, err)
+			}
+		}
+		return addrRange, nil
+	default:
+		list, err := parseExprList(s, 10, 0xFFFFFFFF)
+		if err != nil {
+			return AddrRange{}, fmt.Errorf("invalid numeric address range %q: %w", s, err)
+		}
+		return AddrRange{Kind: ADDR_RANGE_NUM, Parts: []ExprList{list}}, nil
+	}
+}
+
+// parseIPv6Range parses an IPv6 address whose groups may be hexadecimal expression lists.
+// A "::" stands for as many zero groups as needed, like in a plain IPv6 address.
+func parseIPv6Range(s string) (AddrRange, error) {
+	head, tail, compressed := strings.Cut(s, "::")
+	if strings.Contains(tail, "::") {
+		return AddrRange{}, fmt.Errorf("invalid IPv6 address range %q: multiple '::'", s)
+	}
+	groups := func(s string) ([]ExprList, error) {
+		if s == "" {
+			return nil, nil
+		}
+		parts, err := splitExprLists(s, ":")
+		if err != nil {
+			return nil, err
+		}
+		lists := make([]ExprList, len(parts))
+		for i, part := range parts {
+			if lists[i], err = parseAddrPart(part, 16, 0xFFFF); err != nil {
+				return nil, err
+			}
+		}
+		return lists, nil
+	}
+	headLists, err := groups(head)
+	if err != nil {
+		return AddrRange{}, fmt.Errorf("invalid IPv6 address range %q: %w", s, err)
+	}
+	tailLists, err := groups(tail)
+	if err != nil {
+		return AddrRange{}, fmt.Errorf("invalid IPv6 address range %q: %w", s, err)
+	}
+	missing := 8 - len(headLists) - len(tailLists)
+	if (compressed && missing < 1) || (!compressed && missing != 0) {
+		return AddrRange{}, fmt.Errorf("invalid IPv6 address range %q: expected 8 groups", s)
+	}
+	parts := headLists
+	for range missing {
+		parts = append(parts, ExprList{{Lo: 0, Hi: 0, Step: 1}})
+	}
+	parts = append(parts, tailLists...)
+	return AddrRange{Kind: ADDR_RANGE_IPV6, Parts: parts}, nil
+}
+
+// parseAddrPart parses one part of an address, where '*' matches any value.
+func parseAddrPart(s string, base int, max uint32) (ExprList, error) {
+	if s == "*" {
+		return ExprList{{Lo: 0, Hi: max, Step: 1}}, nil
+	}
+	return parseExprList(s, base, max)
+}
+
+// splitExprLists splits s on sep, ignoring separators inside brackets.
+func splitExprLists(s string, sep string) ([]string, error) {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '[':
+			depth++
+		case s[i] == ']':
+			depth--
+		case depth == 0 && strings.HasPrefix(s[i:], sep):
+			parts = append(parts, s[start:i])
+			start = i + len(sep)
+		}
+		if depth < 0 || depth > 1 {
+			return nil, fmt.Errorf("unbalanced brackets in %q", s)
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced brackets in %q", s)
+	}
+	return append(parts, s[start:]), nil
+}
+
+// parseExprList parses a number or a bracketed list of range expressions.
+// libcfs: cfs_expr_list_parse
+func parseExprList(s string, base int, max uint32) (ExprList, error) {
+	if !strings.HasPrefix(s, "[") {
+		value, err := parseRangeNumber(s, base, max)
+		if err != nil {
+			return nil, err
+		}
+		return ExprList{{Lo: value, Hi: value, Step: 1}}, nil
+	}
+	if !strings.HasSuffix(s, "]") || len(s) < 3 {
+		return nil, fmt.Errorf("invalid expression list %q", s)
+	}
+	var list ExprList
+	for _, item := range strings.Split(s[1:len(s)-1], ",") {
+		expr, err := parseRangeExpr(item, base, max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expression list %q: %w", s, err)
+		}
+		list = append(list, expr)
+	}
+	return list, nil
+}
+
+// parseRangeExpr parses "<number>", "<lo>-<hi>" or "<lo>-<hi>/<step>".
+// libcfs: cfs_range_expr_parse
+func parseRangeExpr(s string, base int, max uint32) (RangeExpr, error) {
+	rangeStr, stepStr, hasStep := strings.Cut(s, "/")
+	loStr, hiStr, isRange := strings.Cut(rangeStr, "-")
+	lo, err := parseRangeNumber(loStr, base, max)
+	if err != nil {
+		return RangeExpr{}, err
+	}
+	expr := RangeExpr{Lo: lo, Hi: lo, Step: 1}
+	if isRange {
+		if expr.Hi, err = parseRangeNumber(hiStr, base, max); err != nil {
+			return RangeExpr{}, err
+		}
+		if expr.Hi < expr.Lo {
+			return RangeExpr{}, fmt.Errorf("invalid range %q: end is before start", s)
+		}
+	}
+	if hasStep {
+		if !isRange {
+			return RangeExpr{}, fmt.Errorf("invalid range %q: step without range", s)
+		}
+		step, err := strconv.ParseUint(stepStr, 10, 32)
+		if err != nil || step == 0 {
+			return RangeExpr{}, fmt.Errorf("invalid step in range %q", s)
+		}
+		expr.Step = uint32(step)
+	}
+	return expr, nil
+}
+
+func parseRangeNumber(s string, base int, max uint32) (uint32, error) {
+	value, err := strconv.ParseUint(s, base, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", s)
+	}
+	if value > uint64(max) {
+		return 0, fmt.Errorf("number %q is out of range", s)
+	}
+	return uint32(value), nil
+}
+
+func (expr RangeExpr) format(base int) string {
+	if expr.Lo == expr.Hi {
+		return strconv.FormatUint(uint64(expr.Lo), base)
+	}
+	s := strconv.FormatUint(uint64(expr.Lo), base) + "-" + strconv.FormatUint(uint64(expr.Hi), base)
+	if expr.Step > 1 {
+		s += "/" + strconv.FormatUint(uint64(expr.Step), 10)
+	}
+	return s
+}
+
+// format prints the list in libcfs style: a single number without brackets.
+// libcfs: cfs_expr_list_print
+func (list ExprList) format(base int) string {
+	if len(list) == 1 && list[0].Lo == list[0].Hi {
+		return list[0].format(base)
+	}
+	items := make([]string, len(list))
+	for i, expr := range list {
+		items[i] = expr.format(base)
+	}
+	return "[" + strings.Join(items, ",") + "]"
+}
+
+func (list ExprList) String() string {
+	return list.format(10)
+}
+
+// isFull reports whether the list matches every value from 0 to max, i.e., is a '*'.
+func (list ExprList) isFull(max uint32) bool {
+	return len(list) == 1 && list[0].Lo == 0 && list[0].Hi == max && list[0].Step == 1
+}
+
+func (list ExprList) isZero() bool {
+	return len(list) == 1 && list[0].Lo == 0 && list[0].Hi == 0
+}
+
+func (addrRange AddrRange) String() string {
+	switch addrRange.Kind {
+	case ADDR_RANGE_ANY:
+		return "*"
+	case ADDR_RANGE_IPV4:
+		parts := make([]string, len(addrRange.Parts))
+		for i, part := range addrRange.Parts {
+			if part.isFull(0xFF) {
+				parts[i] = "*"
+			} else {
+				parts[i] = part.format(10)
+			}
+		}
+		return strings.Join(parts, ".")
+	case ADDR_RANGE_IPV6:
+		return formatIPv6Range(addrRange.Parts)
+	case ADDR_RANGE_NUM:
+		return addrRange.Parts[0].format(10)
+	}
+	return fmt.Sprintf("unknown(%d)", addrRange.Kind)
+}
+
+// formatIPv6Range prints the groups like an IPv6 address, compressing the longest run of zero groups.
+func formatIPv6Range(groups []ExprList) string {
+	runStart, runLen := -1, 0
+	for i := 0; i < len(groups); {
+		if !groups[i].isZero() {
+			i++
+			continue
+		}
+		j := i
+		for j < len(groups) && groups[j].isZero() {
+			j++
+		}
+		if j-i > runLen && j-i > 1 {
+			runStart, runLen = i, j-i
+		}
+		i = j
+	}
+	format := func(groups []ExprList) string {
+		parts := make([]string, len(groups))
+		for i, group := range groups {
+			if group.isFull(0xFFFF) {
+				parts[i] = "*"
+			} else {
+				parts[i] = group.format(16)
+			}
+		}
+		return strings.Join(parts, ":")
+	}
+	if runStart == -1 {
+		return format(groups)
+	}
+	return format(groups[:runStart]) + "::" + format(groups[runStart+runLen:])
+}
+
+// String returns the canonical form of the range. The net number is always printed, as in NID strings.
+func (nidRange NIDRange) String() string {
+	return nidRange.Addr.String() + "@" + nidRange.NetType.String() + nidRange.NetNums.format(10)
+}
+
+// String returns the canonical form of the list, which ParseNIDList parses back to the same list.
+// libcfs: cfs_print_nidlist
+func (list NIDList) String() string {
+	ranges := make([]string, len(list))
+	for i, nidRange := range list {
+		ranges[i] = nidRange.String()
+	}
+	return strings.Join(ranges, " ")
+}
+
+// values lists the numbers matched by the list, in order, up to limit of them.
+func (list ExprList) values(limit int) ([]uint32, error) {
+	var values []uint32
+	for _, expr := range list {
+		for value := uint64(expr.Lo); value <= uint64(expr.Hi); value += uint64(expr.Step) {
+			if len(values) == limit {
+				return nil, fmt.Errorf("expression list %s has more than %d values", list, limit)
+			}
+			values = append(values, uint32(value))
+		}
+	}
+	return values, nil
+}
+
+// Expand lists the NIDs matched by the range, with the default port, up to limit of them.
+// Ranges with '*' addresses usually match too many NIDs to be expanded.
+// libcfs: cfs_expand_nidlist
+func (nidRange NIDRange) Expand(limit int) ([]NID, error) {
+	if nidRange.Addr.Kind == ADDR_RANGE_ANY {
+		return nil, fmt.Errorf("cannot expand NID range %s", nidRange)
+	}
+	netNums, err := nidRange.NetNums.values(limit)
+	if err != nil {
+		return nil, err
+	}
+	// Each address is built from one value of each part, like an odometer
+	parts := make([][]uint32, len(nidRange.Addr.Parts))
+	for i, part := range nidRange.Addr.Parts {
+		if parts[i], err = part.values(limit); err != nil {
+			return nil, err
+		}
+	}
+	var nids []NID
+	indexes := make([]int, len(parts))
+	for {
+		var addr netip.Addr
+		switch nidRange.Addr.Kind {
+		case ADDR_RANGE_IPV4:
+			addr = netip.AddrFrom4([4]byte{byte(parts[0][indexes[0]]), byte(parts[1][indexes[1]]), byte(parts[2][indexes[2]]), byte(parts[3][indexes[3]])})
+		case ADDR_RANGE_IPV6:
+			var bytes [16]byte
+			for i := range 8 {
+				bytes[2*i], bytes[2*i+1] = byte(parts[i][indexes[i]]>>8), byte(parts[i][indexes[i]])
+			}
+			addr = netip.AddrFrom16(bytes)
+		case ADDR_RANGE_NUM:
+			value := parts[0][indexes[0]]
+			addr = netip.AddrFrom4([4]byte{byte(value >> 24), byte(value >> 16), byte(value >> 8), byte(value)})
+		}
+		for _, netNum := range netNums {
+			if len(nids) == limit {
+				return nil, fmt.Errorf("NID range %s has more than %d NIDs", nidRange, limit)
+			}
+			nid, err := NIDFromAddr(addr, nidRange.NetType, uint16(netNum), DEFAULT_PORT)
+			if err != nil {
+				return nil, err
+			}
+			nids = append(nids, nid)
+		}
+		i := len(indexes) - 1
+		for ; i >= 0; i-- {
+			if indexes[i]++; indexes[i] < len(parts[i]) {
+				break
+			}
+			indexes[i] = 0
+		}
+		if i < 0 {
+			return nids, nil
+		}
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for NID list expressions.
+*/
+package lnet
+
+import (
+	"testing"
+)
+
+func TestNIDListMatch(t *testing.T) {
+	tests := []struct {
+		list    string
+		nid     string
+		matched bool
+	}{
+		{"192.168.[0-3].[1-254/2]@tcp0", "192.168.2.7@tcp0", true},
+		{"192.168.[0-3].[1-254/2]@tcp0", "192.168.2.8@tcp0", false},
+		{"192.168.[0-3].[1-254/2]@tcp0", "192.168.4.7@tcp0", false},
+		{"192.168.[0-3].[1-254/2]@tcp0", "192.168.2.7@tcp1", false},
+		{"*@o2ib1", "10.1.2.3@o2ib1", true},
+		{"*@o2ib1", "10.1.2.3@o2ib0", false},
+		{"*@o2ib1", "10.1.2.3@tcp1", false},
+		{"10.0.0.*@tcp", "10.0.0.9@tcp0", true},
+		{"10.0.0.[1,5-6]@tcp[1-3,7]", "10.0.0.6@tcp7", true},
+		{"10.0.0.[1,5-6]@tcp[1-3,7]", "10.0.0.6@tcp4", false},
+		{"10.0.0.1@tcp0 10.0.0.2@tcp0", "10.0.0.2@tcp0#9000", true},
+		{"fd00::[1-ff]@tcp1", "fd00::1f@tcp1", true},
+		{"fd00::[1-ff]@tcp1", "fd00::100@tcp1", false},
+		{"fd00::[1-ff]@tcp1", "10.0.0.1@tcp1", false},
+		{"fd00:*::1@tcp0", "fd00:abcd::1@tcp0", true},
+		{"10.0.0.*@tcp0", "fd00::1@tcp0", false},
+	}
+	for _, tt := range tests {
+		list, err := ParseNIDList(tt.list)
+		if err != nil {
+			t.Fatalf("ParseNIDList(%q) failed: %v", tt.list, err)
+		}
+		nid, err := ParseNID(tt.nid)
+		if err != nil {
+			t.Fatalf("ParseNID(%q) failed: %v", tt.nid, err)
+		}
+		if matched := list.Match(nid); matched != tt.matched {
+			t.Errorf("%q.Match(%s) = %v; expected %v", tt.list, tt.nid, matched, tt.matched)
+		}
+	}
+}
+
+func TestNIDListString(t *testing.T) {
+	tests := []struct {
+		list      string
+		canonical string
+	}{
+		{"192.168.[0-3].[1-254/2]@tcp0", "192.168.[0-3].[1-254/2]@tcp0"},
+		{"*@o2ib1   10.0.0.[5]@tcp", "*@o2ib1 10.0.0.5@tcp0"},
+		{"10.0.[0-255].*@tcp[1-2]", "10.0.*.*@tcp[1-2]"},
+		{"FD00:0:0:0:0:0:0:[1-FF]@tcp", "fd00::[1-ff]@tcp0"},
+		{"fd00:0:[0-ffff]::0:1@tcp", "fd00:0:*::1@tcp0"},
+		{"[0-3]@lo", "[0-3]@lo0"},
+	}
+	for _, tt := range tests {
+		list, err := ParseNIDList(tt.list)
+		if err != nil {
+			t.Fatalf("ParseNIDList(%q) failed: %v", tt.list, err)
+		}
+		if list.String() != tt.canonical {
+			t.Errorf("ParseNIDList(%q).String() = %q; expected %q", tt.list, list.String(), tt.canonical)
+		}
+		reparsed, err := ParseNIDList(list.String())
+		if err != nil || reparsed.String() != tt.canonical {
+			t.Errorf("ParseNIDList(%q) = %v, %v; expected %q", list.String(), reparsed, err, tt.canonical)
+		}
+	}
+}
+
+func TestParseNIDListErrors(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"10.0.0.1",
+		"10.0.0.1@",
+		"10.0.0.1@foo0",
+		"10.0.0@tcp0",
+		"10.0.0.256@tcp0",
+		"10.0.0.[5-1]@tcp0",
+		"10.0.0.[1-5/0]@tcp0",
+		"10.0.0.[1-5@tcp0",
+		"10.0.0.1@tcp[1-2",
+		"10.0.0.1@]",
+		"fd00::1::2@tcp0",
+		"fd00:1:2:3:4:5:6:7:8@tcp0",
+		"fd00::10000@tcp0",
+	} {
+		if list, err := ParseNIDList(s); err == nil {
+			t.Errorf("ParseNIDList(%q) = %v; expected an error", s, list)
+		}
+	}
+}
@@ -0,0 +1,435 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Outgoing PUT and GET operations, and delivery of incoming PUTs, ACKs and REPLYs.
+
+Outgoing operations bind a memory descriptor, whose handle is sent to the peer in the
+wire handle of the request (AckWMD for PUT, ReturnWMD for GET).
+The peer echoes that wire handle in its ACK or REPLY, which completes the operation.
+*/
+package lnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Lustre's default lnet_transaction_timeout
+const DEFAULT_TRANSACTION_TIMEOUT = 50 * time.Second
+
+// Operation errors
+var (
+	// The operation did not complete within its deadline
+	ErrOperationTimeout = errors.New("LNet operation timed out")
+)
+
+// operationResult completes an outgoing operation.
+type operationResult struct {
+	length int
+	err    error
+	// The ACK or REPLY that completed the operation
+	event Event
+}
+
+// operationTable tracks outgoing operations by the handle of their MD.
+type operationTable struct {
+	mu         sync.Mutex
+	operations map[MDHandle]chan operationResult
+}
+
+func newOperationTable() *operationTable {
+	return &operationTable{operations: make(map[MDHandle]chan operationResult)}
+}
+
+func (table *operationTable) add(handle MDHandle) <-chan operationResult {
+	done := make(chan operationResult, 1)
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	table.operations[handle] = done
+	return done
+}
+
+// complete finishes the operation, reporting whether it was still outstanding.
+// finish only runs for outstanding operations, so it can safely fill their buffers.
+func (table *operationTable) complete(handle MDHandle, finish func() operationResult) bool {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	done, ok := table.operations[handle]
+	if !ok {
+		return false
+	}
+	delete(table.operations, handle)
+	done <- finish()
+	return true
+}
+
+// wireHandle returns the wire handle peers use to refer to one of our MDs.
+func (client *LNetClient) wireHandle(handle MDHandle) LNetHandleWire {
+	return LNetHandleWire{InterfaceCookie: client.Incarnation, ObjectCookie: uint64(handle)}
+}
+
+// localHandle resolves a wire handle sent back by a peer.
+// Handles from before a restart (a different incarnation) are rejected.
+func (client *LNetClient) localHandle(wmd LNetHandleWire) (MDHandle, error) {
+	if wmd.InterfaceCookie != client.Incarnation {
+		return 0, fmt.Errorf("stale wire handle: interface cookie 0x%x, expected 0x%x", wmd.InterfaceCookie, client.Incarnation)
+	}
+	return MDHandle(wmd.ObjectCookie), nil
+}
+
+// newMessage creates a message from us to the remote.
+func (client *LNetClient) newMessage(remote *RemoteConn, messageType CommandType, command any, payload []byte) LNetMessage {
+	return client.newMessageTo(remote, remote.NID, messageType, command, payload)
+}
+
+// newMessageTo creates a message from us to dest, sent on the connection to the remote:
+// dest is either the remote, or a peer the remote routes to.
+func (client *LNetClient) newMessageTo(remote *RemoteConn, dest NID, messageType CommandType, command any, payload []byte) LNetMessage {
+	destPID := remote.PID
+	if !SameNID(dest, remote.NID) {
+		// The PID of the remote is that of the gateway
+		destPID = PID_LUSTRE
+	}
+	return LNetMessage{
+		DestNID:   dest,
+		SourceNID: remote.LocalNID,
+		LNetHeaderEmbed: LNetHeaderEmbed{
+			DestPID:     destPID,
+			SourcePID:   client.PID,
+			MessageType: messageType,
+		},
+		LNetCommand: command,
+		Payload:     payload,
+	}
+}
+
+// Put stores data into a portal of the remote.
+// With ack, Put waits for the ACK and returns the number of bytes the peer accepted,
+// otherwise it returns once the data is sent.
+// Without a deadline on ctx, the operation times out after client.TransactionTimeout.
+func (client *LNetClient) Put(ctx context.Context, remote *RemoteConn, portal uint32, matchBits uint64, offset uint32, headerData uint64, data []byte, ack bool) (int, error) {
+	return client.put(ctx, remote, remote.NID, portal, matchBits, offset, headerData, data, ack)
+}
+
+// PutTo is Put to the peer with the given NID, which may be on a remote network.
+// The connection is chosen like in PeerConn, and the PUT is resent if it cannot be sent.
+func (client *LNetClient) PutTo(ctx context.Context, nid NID, portal uint32, matchBits uint64, offset uint32, headerData uint64, data []byte, ack bool) (int, error) {
+	var length int
+	err := client.resend(ctx, nid, len(data), func(ctx context.Context, remote *RemoteConn, dest NID) (err error) {
+		length, err = client.put(ctx, remote, dest, portal, matchBits, offset, headerData, data, ack)
+		return err
+	})
+	return length, err
+}
+
+// put sends a PUT to dest on the connection to the remote.
+func (client *LNetClient) put(ctx context.Context, remote *RemoteConn, dest NID, portal uint32, matchBits uint64, offset uint32, headerData uint64, data []byte, ack bool) (int, error) {
+	command := &LNetPutCommand{
+		AckWMD:      LNetHandleWire{InterfaceCookie: uint64(MD_HANDLE_NONE), ObjectCookie: uint64(MD_HANDLE_NONE)},
+		MatchBits:   matchBits,
+		HeaderData:  headerData,
+		PortalIndex: portal,
+		Offset:      offset,
+	}
+	if !ack {
+		if err := client.SendMessage(ctx, remote, client.newMessageTo(remote, dest, LNET_MSG_PUT, command, data)); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	md := &MemoryDescriptor{Buffer: data, Threshold: 1}
+	handle, err := client.Portals.Bind(md)
+	if err != nil {
+		return 0, err
+	}
+	command.AckWMD = client.wireHandle(handle)
+	return client.runOperation(ctx, remote, handle, client.newMessageTo(remote, dest, LNET_MSG_PUT, command, data))
+}
+
+// Get fetches data from a portal of the remote into buffer, and returns the number of bytes received.
+// Without a deadline on ctx, the operation times out after client.TransactionTimeout.
+func (client *LNetClient) Get(ctx context.Context, remote *RemoteConn, portal uint32, matchBits uint64, offset uint32, buffer []byte) (int, error) {
+	return client.get(ctx, remote, remote.NID, portal, matchBits, offset, buffer)
+}
+
+// GetFrom is Get from the peer with the given NID, which may be on a remote network.
+// The connection is chosen like in PeerConn, and the GET is resent if it cannot be sent.
+func (client *LNetClient) GetFrom(ctx context.Context, nid NID, portal uint32, matchBits uint64, offset uint32, buffer []byte) (int, error) {
+	var length int
+	err := client.resend(ctx, nid, 0, func(ctx context.Context, remote *RemoteConn, dest NID) (err error) {
+		length, err = client.get(ctx, remote, dest, portal, matchBits, offset, buffer)
+		return err
+	})
+	return length, err
+}
+
+// get sends a GET to dest on the connection to the remote.
+func (client *LNetClient) get(ctx context.Context, remote *RemoteConn, dest NID, portal uint32, matchBits uint64, offset uint32, buffer []byte) (int, error) {
+	md := &MemoryDescriptor{Buffer: buffer, Threshold: 1}
+	handle, err := client.Portals.Bind(md)
+	if err != nil {
+		return 0, err
+	}
+	command := &LNetGetCommand{
+		ReturnWMD:    client.wireHandle(handle),
+		MatchBits:    matchBits,
+		PortalIndex:  portal,
+		SourceOffset: offset,
+		SinkLength:   uint32(len(buffer)),
+	}
+	return client.runOperation(ctx, remote, handle, client.newMessageTo(remote, dest, LNET_MSG_GET, command, nil))
+}
+
+// PutMD starts a PUT of md.Buffer to a portal of the peer with the given NID, and returns once it is sent.
+// The MD must not be bound yet. Its handler gets a SEND event, then an ACK if ack is set
+// (with ErrOperationTimeout as Status if none comes within client.TransactionTimeout),
+// and the MD is unlinked with the last event. The returned error is the Status of the SEND.
+func (client *LNetClient) PutMD(ctx context.Context, nid NID, md *MemoryDescriptor, portal uint32, matchBits uint64, offset uint32, headerData uint64, ack bool) error {
+	handle, err := client.Portals.Bind(md)
+	if err != nil {
+		return err
+	}
+	command := &LNetPutCommand{
+		AckWMD:      LNetHandleWire{InterfaceCookie: uint64(MD_HANDLE_NONE), ObjectCookie: uint64(MD_HANDLE_NONE)},
+		MatchBits:   matchBits,
+		HeaderData:  headerData,
+		PortalIndex: portal,
+		Offset:      offset,
+	}
+	completion := EventType(0)
+	if ack {
+		command.AckWMD = client.wireHandle(handle)
+		completion = EVENT_ACK
+	}
+	send := Event{Portal: portal, MatchBits: matchBits, RequestedLength: len(md.Buffer), HeaderData: headerData}
+	return client.startOperation(ctx, nid, md, send, completion, func(remote *RemoteConn, dest NID) LNetMessage {
+		return client.newMessageTo(remote, dest, LNET_MSG_PUT, command, md.Buffer)
+	})
+}
+
+// GetMD starts a GET from a portal of the peer with the given NID into md.Buffer,
+// and returns once it is sent. The MD must not be bound yet. Its handler gets a SEND event,
+// then a REPLY (with ErrOperationTimeout as Status if none comes within client.TransactionTimeout),
+// and the MD is unlinked with the last event. The returned error is the Status of the SEND.
+func (client *LNetClient) GetMD(ctx context.Context, nid NID, md *MemoryDescriptor, portal uint32, matchBits uint64, offset uint32) error {
+	handle, err := client.Portals.Bind(md)
+	if err != nil {
+		return err
+	}
+	command := &LNetGetCommand{
+		ReturnWMD:    client.wireHandle(handle),
+		MatchBits:    matchBits,
+		PortalIndex:  portal,
+		SourceOffset: offset,
+		SinkLength:   uint32(len(md.Buffer)),
+	}
+	send := Event{Portal: portal, MatchBits: matchBits, RequestedLength: len(md.Buffer)}
+	return client.startOperation(ctx, nid, md, send, EVENT_REPLY, func(remote *RemoteConn, dest NID) LNetMessage {
+		return client.newMessageTo(remote, dest, LNET_MSG_GET, command, nil)
+	})
+}
+
+// startOperation sends the request that build creates for a bound MD, and reports the SEND event.
+// If completion is set, the ACK or REPLY is then waited for in the background.
+func (client *LNetClient) startOperation(ctx context.Context, nid NID, md *MemoryDescriptor, send Event, completion EventType, build func(remote *RemoteConn, dest NID) LNetMessage) error {
+	handle := md.handle
+	// Registered even without a completion, so that UnlinkMD leaves the last event to us
+	done := client.operations.add(handle)
+	send.Type = EVENT_SEND
+	send.Initiator.PID = client.PID
+	send.Target = ProcessID{NID: nid, PID: PID_LUSTRE}
+	err := client.resend(ctx, nid, len(md.Buffer), func(ctx context.Context, remote *RemoteConn, dest NID) error {
+		message := build(remote, dest)
+		send.Initiator.NID = remote.LocalNID
+		send.Target = ProcessID{NID: dest, PID: message.DestPID}
+		return client.SendMessage(ctx, remote, message)
+	})
+	if send.Status = err; err == nil {
+		send.Length = send.RequestedLength
+	}
+	if err != nil || completion == 0 {
+		client.operations.complete(handle, func() operationResult { return operationResult{err: err} })
+		client.finishOperation(md, send)
+		return err
+	}
+	md.notify(send)
+	go func() {
+		timer := time.NewTimer(client.TransactionTimeout)
+		defer timer.Stop()
+		var result operationResult
+		select {
+		case result = <-done:
+		case <-timer.C:
+			client.operations.complete(handle, func() operationResult { return operationResult{err: ErrOperationTimeout} })
+			result = <-done
+		}
+		event := result.event
+		if event.Type == 0 {
+			// Timed out or unlinked, without an ACK or REPLY
+			event = Event{Type: completion, Initiator: send.Target, MatchBits: send.MatchBits, Status: result.err}
+		}
+		event.Portal = send.Portal
+		client.finishOperation(md, event)
+	}()
+	return nil
+}
+
+// finishOperation unlinks the MD of an outgoing operation, and reports the last event of the operation.
+func (client *LNetClient) finishOperation(md *MemoryDescriptor, event Event) {
+	_ = client.Portals.Unlink(md.handle)
+	event.Unlinked = true
+	md.notify(event)
+}
+
+// UnlinkMD unlinks an MD. If a PutMD or GetMD on it is outstanding, the operation reports
+// its own last event (an ACK or REPLY it still waits for has ErrMDUnlinked as Status),
+// otherwise the MD's handler gets an UNLINK event.
+func (client *LNetClient) UnlinkMD(handle MDHandle) error {
+	md, ok := client.Portals.Lookup(handle)
+	if !ok {
+		return fmt.Errorf("unknown memory descriptor handle %d", handle)
+	}
+	if client.operations.complete(handle, func() operationResult { return operationResult{err: ErrMDUnlinked} }) {
+		return nil
+	}
+	if err := client.Portals.Unlink(handle); err != nil {
+		// Unlinked concurrently, which reported its own last event
+		return err
+	}
+	md.notify(Event{Type: EVENT_UNLINK, Unlinked: true})
+	return nil
+}
+
+// runOperation sends the request for an outgoing operation and waits for its completion.
+func (client *LNetClient) runOperation(ctx context.Context, remote *RemoteConn, handle MDHandle, message LNetMessage) (int, error) {
+	defer func() {
+		// The MD only exists for this operation
+		_ = client.Portals.Unlink(handle)
+	}()
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.TransactionTimeout)
+		defer cancel()
+	}
+	done := client.operations.add(handle)
+	if err := client.SendMessage(ctx, remote, message); err != nil {
+		client.operations.complete(handle, func() operationResult { return operationResult{err: err} })
+		return 0, err
+	}
+	select {
+	case result := <-done:
+		return result.length, result.err
+	case <-ctx.Done():
+		if client.operations.complete(handle, func() operationResult { return operationResult{} }) {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				client.connFailed(remote, ErrOperationTimeout)
+				return 0, fmt.Errorf("%v to %s: %w", message.MessageType, message.DestNID, ErrOperationTimeout)
+			}
+			return 0, ctx.Err()
+		}
+		// Completed concurrently
+		result := <-done
+		return result.length, result.err
+	}
+}
+
+// HandlePut handles a PUT command by storing the payload into the matching MD.
+// Discovery pushes are handled by HandlePush.
+// An ACK is sent back if the peer asked for one and the MD allows it.
+func (client *LNetClient) HandlePut(ctx context.Context, remote *RemoteConn, message LNetMessage) error {
+	command := message.LNetCommand.(*LNetPutCommand)
+	if command.MatchBits == LNET_PROTO_PING_MATCHBITS && command.PortalIndex == LNET_RESERVED_PORTAL {
+		return client.HandlePush(ctx, remote, message)
+	}
+	source := ProcessID{NID: message.SourceNID, PID: message.SourcePID}
+	result, err := client.Portals.Match(LNET_MD_OP_PUT, command.PortalIndex, source, command.MatchBits, int(command.Offset), len(message.Payload))
+	if err != nil {
+		// Like Lustre, unmatched PUTs are dropped, and the peer times out waiting for its ACK
+		slog.Warn("dropping PUT", "error", err, "portal", command.PortalIndex, "matchBits", command.MatchBits, "source", message.SourceNID)
+		return nil
+	}
+	copy(result.Data(), message.Payload)
+	result.MD.notify(Event{
+		Type:            EVENT_PUT,
+		Initiator:       source,
+		Portal:          command.PortalIndex,
+		MatchBits:       command.MatchBits,
+		RequestedLength: len(message.Payload),
+		Length:          result.Length,
+		Offset:          result.Offset,
+		HeaderData:      command.HeaderData,
+		Unlinked:        result.Unlinked,
+	})
+	if MDHandle(command.AckWMD.ObjectCookie) == MD_HANDLE_NONE || result.MD.Options&LNET_MD_ACK_DISABLE != 0 {
+		return nil
+	}
+	ack := &LNetAckCommand{
+		DestWMD:       command.AckWMD,
+		MatchBits:     command.MatchBits,
+		MessageLength: uint32(result.Length),
+	}
+	ackMessage := client.newMessage(remote, LNET_MSG_ACK, ack, nil)
+	ackMessage.DestNID, ackMessage.DestPID = message.SourceNID, message.SourcePID
+	ackMessage.SourceNID = message.DestNID
+	return client.SendMessage(ctx, remote, ackMessage)
+}
+
+// HandleAck handles the ACK for one of our PUTs.
+func (client *LNetClient) HandleAck(ctx context.Context, remote *RemoteConn, message LNetMessage) error {
+	_ = ctx
+	command := message.LNetCommand.(*LNetAckCommand)
+	handle, err := client.localHandle(command.DestWMD)
+	if err != nil {
+		slog.Warn("dropping ACK", "error", err, "remote", remote)
+		return nil
+	}
+	event := Event{
+		Type:      EVENT_ACK,
+		Initiator: ProcessID{NID: message.SourceNID, PID: message.SourcePID},
+		MatchBits: command.MatchBits,
+		Length:    int(command.MessageLength),
+	}
+	if !client.operations.complete(handle, func() operationResult { return operationResult{length: event.Length, event: event} }) {
+		slog.Warn("dropping ACK for unknown operation", "handle", handle, "remote", remote)
+	}
+	return nil
+}
+
+// HandleReply handles the REPLY to one of our GETs by storing the payload into its MD.
+func (client *LNetClient) HandleReply(ctx context.Context, remote *RemoteConn, message LNetMessage) error {
+	_ = ctx
+	command := message.LNetCommand.(*LNetReplyCommand)
+	handle, err := client.localHandle(command.DestWMD)
+	if err != nil {
+		slog.Warn("dropping REPLY", "error", err, "remote", remote)
+		return nil
+	}
+	md, ok := client.Portals.Lookup(handle)
+	if !ok {
+		slog.Warn("dropping REPLY for unknown operation", "handle", handle, "remote", remote)
+		return nil
+	}
+	result := operationResult{event: Event{
+		Type:            EVENT_REPLY,
+		Initiator:       ProcessID{NID: message.SourceNID, PID: message.SourcePID},
+		RequestedLength: len(message.Payload),
+	}}
+	finish := func() operationResult {
+		if len(message.Payload) > len(md.Buffer) {
+			result.err = fmt.Errorf("REPLY of %d bytes does not fit into %d bytes: %w", len(message.Payload), len(md.Buffer), ErrMatchDropped)
+		} else {
+			result.length = copy(md.Buffer, message.Payload)
+		}
+		result.event.Length, result.event.Status = result.length, result.err
+		return result
+	}
+	if !client.operations.complete(handle, finish) {
+		slog.Warn("dropping REPLY for completed operation", "handle", handle, "remote", remote)
+	}
+	return nil
+}
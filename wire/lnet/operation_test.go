@@ -0,0 +1,94 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for outgoing PUT and GET operations.
+*/
+package lnet
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPutWithAck(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server := NewLNetClient()
+	events := make(chan Event, 1)
+	md := &MemoryDescriptor{
+		Buffer:    make([]byte, 8),
+		Threshold: LNET_MD_THRESH_INF,
+		Options:   LNET_MD_OP_PUT | LNET_MD_MANAGE_REMOTE | LNET_MD_TRUNCATE,
+		Handler:   func(event Event) { events <- event },
+	}
+	if _, err := server.Portals.Attach(10, &MatchEntry{MatchID: AnyProcess, MatchBits: 42}, md, true); err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+	nid := serveOnce(t, ctx, &server)
+
+	client := NewLNetClient()
+	remote, err := client.Dial(ctx, nid)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer remote.Close()
+	length, err := client.Put(ctx, remote, 10, 42, 2, 7, []byte("glimmering"), true)
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	// The MD truncates the 10 bytes to the 6 bytes after offset 2
+	if length != 6 {
+		t.Errorf("expected ACK for 6 bytes, got %d", length)
+	}
+	if !bytes.Equal(md.Buffer, []byte("\x00\x00glimme")) {
+		t.Errorf("unexpected MD contents: %q", md.Buffer)
+	}
+	event := <-events
+	if event.Type != EVENT_PUT || event.Length != 6 || event.RequestedLength != 10 || event.HeaderData != 7 {
+		t.Errorf("unexpected PUT event: %+v", event)
+	}
+}
+
+func TestGet(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server := NewLNetClient()
+	md := &MemoryDescriptor{Buffer: []byte("0123456789"), Threshold: LNET_MD_THRESH_INF, Options: LNET_MD_OP_GET | LNET_MD_MANAGE_REMOTE}
+	if _, err := server.Portals.Attach(11, &MatchEntry{MatchID: AnyProcess, MatchBits: 1}, md, true); err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+	nid := serveOnce(t, ctx, &server)
+
+	client := NewLNetClient()
+	remote, err := client.Dial(ctx, nid)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer remote.Close()
+	buffer := make([]byte, 4)
+	length, err := client.Get(ctx, remote, 11, 1, 3, buffer)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if length != 4 || string(buffer) != "3456" {
+		t.Errorf("expected 4 bytes \"3456\", got %d bytes %q", length, buffer[:length])
+	}
+
+	// Nothing matches, so the GET is dropped and times out
+	timeoutCtx, timeoutCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer timeoutCancel()
+	if _, err := client.Get(timeoutCtx, remote, 11, 2, 0, buffer); !errors.Is(err, ErrOperationTimeout) {
+		t.Errorf("expected ErrOperationTimeout, got %v", err)
+	}
+}
+
+func TestStaleWireHandle(t *testing.T) {
+	client := NewLNetClient()
+	if _, err := client.localHandle(LNetHandleWire{InterfaceCookie: client.Incarnation + 1, ObjectCookie: 1}); err == nil {
+		t.Error("expected a handle from another incarnation to be rejected")
+	}
+}
@@ -0,0 +1,402 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Peers and their connections (ksock_peer_ni in socklnd).
+
+A peer can have several connections, each with a type: CONTROL for small messages, BULK_IN
+and BULK_OUT for large ones in each direction, or ANY for everything. Messages are sent on the
+connection that best matches their size. When both sides connect to each other at the same
+time, the connection initiated by the peer with the higher NID wins, like in socklnd.
+A peer that restarts comes back with a new incarnation, which resets its state.
+A peer is forgotten once its last connection ends, unless it is a NID of a multi-rail peer
+or a gateway, whose health and credits are kept for path selection.
+*/
+package lnet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Messages of this size or larger prefer bulk connections (ksocklnd's min_bulk)
+const DEFAULT_MIN_BULK = 1 << 10
+
+// Tx credits of each peer NI (socklnd's peer_credits)
+const DEFAULT_PEER_CREDITS = 8
+
+// ErrConnRejected is returned when the peer rejects a connection, e.g., to resolve a race.
+var ErrConnRejected = errors.New("peer rejected connection")
+
+// Peer holds the connections to one peer.
+type Peer struct {
+	NID NID
+
+	mu sync.Mutex
+	// Incarnation of the peer, 0 until the first connection
+	incarnation uint64
+	conns       []*RemoteConn
+	// Our connection attempts in progress, by type
+	connecting map[ConnType]int
+	// Tx credits (peer_credits)
+	credits *creditPool
+	// Messages from the peer being handled, up to the peer_credits it should keep to
+	rxCredits *creditPool
+	// Health value, up to LNET_MAX_HEALTH_VALUE
+	health int
+	// Recovery pings while unhealthy (lpni_ping_count), and when the next one is due (lpni_next_ping)
+	pingCount int
+	nextPing  time.Time
+	// Number of times the peer NI was selected, for round robin
+	seq uint64
+	// Discovery was started for the peer NI
+	discovered bool
+}
+
+// Incarnation returns the incarnation of the peer.
+func (peer *Peer) Incarnation() uint64 {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	return peer.incarnation
+}
+
+// Conns returns the established connections to the peer.
+func (peer *Peer) Conns() []*RemoteConn {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	return append([]*RemoteConn(nil), peer.conns...)
+}
+
+// connMatch ranks connections for a message (SOCKNAL_MATCH_*)
+type connMatch int
+
+const (
+	matchNo connMatch = iota
+	matchMay
+	matchYes
+)
+
+// matchConn tells how well a connection of the given type suits sending a message of size bytes.
+func matchConn(connType ConnType, size int) connMatch {
+	bulk := size >= DEFAULT_MIN_BULK
+	switch connType {
+	case SOCKLND_CONN_ANY:
+		return matchYes
+	case SOCKLND_CONN_CONTROL:
+		if bulk {
+			return matchMay
+		}
+		return matchYes
+	case SOCKLND_CONN_BULK_OUT:
+		if bulk {
+			return matchYes
+		}
+		return matchMay
+	case SOCKLND_CONN_BULK_IN:
+		// Reserved for the peer's bulk data
+		if bulk {
+			return matchNo
+		}
+		return matchMay
+	}
+	return matchNo
+}
+
+// Credits returns the state of the tx credits of the peer NI.
+func (peer *Peer) Credits() CreditStats {
+	return peer.credits.stats()
+}
+
+// RxCredits returns the state of the credits of the messages received from the peer NI.
+func (peer *Peer) RxCredits() CreditStats {
+	return peer.rxCredits.stats()
+}
+
+// Conn returns the connection to send a message of size bytes on, or nil if there is none.
+func (peer *Peer) Conn(size int) *RemoteConn {
+	return peer.connFrom(size, nil)
+}
+
+// connFrom is like Conn, but only considers connections of the local NID (if not nil).
+func (peer *Peer) connFrom(size int, localNID NID) *RemoteConn {
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	var fallback *RemoteConn
+	for _, remote := range peer.conns {
+		if localNID != nil && !SameNID(remote.LocalNID, localNID) {
+			continue
+		}
+		switch matchConn(remote.ConnType, size) {
+		case matchYes:
+			return remote
+		case matchMay:
+			if fallback == nil {
+				fallback = remote
+			}
+		}
+	}
+	return fallback
+}
+
+// hasConnLocked reports whether an equivalent connection (same type and addresses) exists.
+// Connecting unix sockets are unnamed, so their addresses tell no connections apart.
+func (peer *Peer) hasConnLocked(remote *RemoteConn) bool {
+	conn := *remote.Conn
+	if _, ok := conn.LocalAddr().(*net.UnixAddr); ok {
+		return slices.Contains(peer.conns, remote)
+	}
+	for _, other := range peer.conns {
+		otherConn := *other.Conn
+		if other.ConnType == remote.ConnType &&
+			otherConn.RemoteAddr().String() == conn.RemoteAddr().String() &&
+			otherConn.LocalAddr().String() == conn.LocalAddr().String() {
+			return true
+		}
+	}
+	return false
+}
+
+// setIncarnationLocked records the incarnation of the peer.
+// If the peer restarted, its connections are stale and returned for closing.
+func (peer *Peer) setIncarnationLocked(incarnation uint64) (restarted bool, stale []*RemoteConn) {
+	if peer.incarnation != 0 && peer.incarnation != incarnation {
+		restarted = true
+		stale = peer.conns
+		peer.conns = nil
+	}
+	peer.incarnation = incarnation
+	return restarted, stale
+}
+
+// PeerTable tracks peers by NID.
+type PeerTable struct {
+	mu    sync.Mutex
+	peers map[string]*Peer
+	// Multi-rail peers by the key of each of their NIDs
+	multiRail map[string]*MultiRailPeer
+	// Tx credits of new peers
+	credits int
+}
+
+// NewPeerTable creates an empty PeerTable.
+func NewPeerTable() *PeerTable {
+	return &PeerTable{peers: make(map[string]*Peer), multiRail: make(map[string]*MultiRailPeer), credits: DEFAULT_PEER_CREDITS}
+}
+
+// peerKey identifies the peer of a NID. Like SameNID, it ignores the port.
+func peerKey(nid NID) string {
+	key, err := nid.ToBytes(binary.LittleEndian)
+	if err != nil {
+		return nid.String()
+	}
+	return string(key)
+}
+
+// compareNID orders NIDs like socklnd compares them when resolving connection races.
+func compareNID(a, b NID) int {
+	aBytes, _ := a.ToBytes(binary.BigEndian)
+	bBytes, _ := b.ToBytes(binary.BigEndian)
+	return bytes.Compare(aBytes, bBytes)
+}
+
+// Peer returns the peer with the given NID, or nil if it is unknown.
+func (table *PeerTable) Peer(nid NID) *Peer {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	return table.peers[peerKey(nid)]
+}
+
+// Peers returns all known peers.
+func (table *PeerTable) Peers() []*Peer {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	peers := make([]*Peer, 0, len(table.peers))
+	for _, peer := range table.peers {
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// peer returns the peer with the given NID, adding it if it is unknown.
+func (table *PeerTable) peer(nid NID) *Peer {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	return table.peerLocked(nid)
+}
+
+// lockPeer is like peer, but returns the peer locked, so that it cannot be forgotten until it is unlocked.
+func (table *PeerTable) lockPeer(nid NID) *Peer {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	peer := table.peerLocked(nid)
+	peer.mu.Lock()
+	return peer
+}
+
+func (table *PeerTable) peerLocked(nid NID) *Peer {
+	key := peerKey(nid)
+	peer, ok := table.peers[key]
+	if !ok {
+		peer = &Peer{NID: nid, connecting: make(map[ConnType]int), credits: newCreditPool(table.credits),
+			rxCredits: newCreditPool(table.credits), health: LNET_MAX_HEALTH_VALUE}
+		table.peers[key] = peer
+	}
+	return peer
+}
+
+// forget deletes the peer once it is idle: without connections, none being made, and not part
+// of a multi-rail peer. Unless keep is set, e.g., for a gateway. A peer that comes back starts afresh.
+func (table *PeerTable) forget(peer *Peer, keep bool) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	key := peerKey(peer.NID)
+	if keep || table.peers[key] != peer || table.multiRail[key] != nil {
+		return
+	}
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	if len(peer.conns) > 0 {
+		return
+	}
+	for _, count := range peer.connecting {
+		if count > 0 {
+			return
+		}
+	}
+	delete(table.peers, key)
+}
+
+// forgetIdle deletes the peer if it is idle (see PeerTable.forget) and no route goes through it.
+func (client *LNetClient) forgetIdle(peer *Peer) {
+	client.Peers.forget(peer, client.isGateway(peer.NID))
+}
+
+// isGateway reports whether a route goes through the NID.
+func (client *LNetClient) isGateway(nid NID) bool {
+	return slices.ContainsFunc(client.Routes.gateways(), func(gateway NID) bool { return SameNID(gateway, nid) })
+}
+
+// removeConn forgets a connection that ended, and the peer too if it is left idle.
+func (client *LNetClient) removeConn(remote *RemoteConn) {
+	if remote.NID == nil {
+		return
+	}
+	peer := client.Peers.Peer(remote.NID)
+	if peer == nil {
+		return
+	}
+	peer.mu.Lock()
+	peer.conns = slices.DeleteFunc(peer.conns, func(other *RemoteConn) bool { return other == remote })
+	peer.mu.Unlock()
+	client.forgetIdle(peer)
+}
+
+// admitConn decides whether to accept a connection from a peer once its HELLO was read,
+// and adds it to the peer table if so. Rejected connections are answered with SOCKLND_CONN_NONE.
+func (client *LNetClient) admitConn(remote *RemoteConn) error {
+	peer := client.Peers.lockPeer(remote.NID)
+	restarted, stale := peer.setIncarnationLocked(remote.Incarnation)
+	var err error
+	switch {
+	case peer.connecting[remote.ConnType] > 0 && compareNID(remote.NID, remote.LocalNID) < 0:
+		// Both sides are connecting, and our connection wins
+		err = fmt.Errorf("%w: connection race with %s", ErrConnRejected, remote.NID)
+	case peer.hasConnLocked(remote):
+		err = fmt.Errorf("%w: duplicate connection from %s", ErrConnRejected, remote.NID)
+	default:
+		peer.conns = append(peer.conns, remote)
+	}
+	peer.mu.Unlock()
+	if restarted {
+		client.resetPeer(peer, stale)
+	}
+	return err
+}
+
+// addConn adds a connection of the given type we initiated to the peer table, which ends the attempt.
+func (client *LNetClient) addConn(peer *Peer, connType ConnType, remote *RemoteConn) {
+	peer.mu.Lock()
+	peer.connecting[connType]--
+	restarted, stale := peer.setIncarnationLocked(remote.Incarnation)
+	peer.conns = append(peer.conns, remote)
+	peer.mu.Unlock()
+	if restarted {
+		client.resetPeer(peer, stale)
+	}
+	client.discoverNew(remote)
+}
+
+// resetPeer closes the connections of a restarted peer and publishes PEER_EVENT_RESET.
+func (client *LNetClient) resetPeer(peer *Peer, stale []*RemoteConn) {
+	slog.Info("peer restarted, closing its stale connections", "nid", peer.NID, "incarnation", peer.Incarnation(), "conns", len(stale))
+	for _, remote := range stale {
+		// Close waits for queued frames, which must not hold up the new connection
+		go func() { _ = remote.Close() }()
+	}
+	client.publishPeerEvent(PeerEvent{Type: PEER_EVENT_RESET, NID: peer.NID})
+}
+
+// PeerConn returns a connection to send a message of size bytes to the peer with the given NID.
+// With local NIs, the local NI and the NID of the peer are chosen by multi-rail selection
+// (see selectPath), so the connection may be to another NID of the same peer.
+// For a peer on a remote network, this is a connection to the gateway: use PutTo and GetFrom
+// to address the peer itself.
+// An existing connection is used if possible, otherwise a new one is dialed: a typed one
+// (CONTROL or BULK_OUT, depending on size) if client.TypedConns is set, or else SOCKLND_CONN_ANY.
+func (client *LNetClient) PeerConn(ctx context.Context, nid NID, size int) (*RemoteConn, error) {
+	remote, _, err := client.route(ctx, nid, size)
+	return remote, err
+}
+
+// route returns the connection to send a message of size bytes to the peer with the given NID,
+// and the NID to address the message to: the one of the peer that selectPath picked.
+func (client *LNetClient) route(ctx context.Context, nid NID, size int) (*RemoteConn, NID, error) {
+	path, err := client.selectPath(nid)
+	if err != nil {
+		return nil, nil, err
+	}
+	var localNID NID
+	if path.ni.Addr.IsValid() {
+		if localNID, err = path.ni.NID(client.Port); err != nil {
+			return nil, nil, err
+		}
+	}
+	hop := path.nextHop()
+	if peer := client.Peers.Peer(hop); peer != nil {
+		if remote := peer.connFrom(size, localNID); remote != nil {
+			return remote, path.dest(remote), nil
+		}
+	}
+	connType := SOCKLND_CONN_ANY
+	if client.TypedConns {
+		connType = SOCKLND_CONN_CONTROL
+		if size >= DEFAULT_MIN_BULK {
+			connType = SOCKLND_CONN_BULK_OUT
+		}
+	}
+	remote, err := client.dialType(ctx, hop, connType, path.ni)
+	if err != nil && !errors.Is(err, ErrConnRejected) && ctx.Err() == nil {
+		client.sendFailed(path.ni, hop, err)
+		err = &sendError{err}
+	}
+	if errors.Is(err, ErrConnRejected) {
+		// We lost a connection race, so the peer's connection should be there
+		if peer := client.Peers.Peer(hop); peer != nil {
+			if remote := peer.connFrom(size, localNID); remote != nil {
+				return remote, path.dest(remote), nil
+			}
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return remote, path.dest(remote), nil
+}
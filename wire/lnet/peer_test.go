@@ -0,0 +1,188 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for the peer table.
+*/
+package lnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestPeerConnSelection(t *testing.T) {
+	control := &RemoteConn{ConnType: SOCKLND_CONN_CONTROL}
+	bulkIn := &RemoteConn{ConnType: SOCKLND_CONN_BULK_IN}
+	bulkOut := &RemoteConn{ConnType: SOCKLND_CONN_BULK_OUT}
+	tests := []struct {
+		conns    []*RemoteConn
+		size     int
+		expected *RemoteConn
+	}{
+		{[]*RemoteConn{bulkIn, bulkOut, control}, 100, control},
+		{[]*RemoteConn{bulkIn, control, bulkOut}, DEFAULT_MIN_BULK, bulkOut},
+		{[]*RemoteConn{bulkIn, control}, 4096, control},
+		{[]*RemoteConn{bulkIn, bulkOut}, 100, bulkIn},
+		{[]*RemoteConn{bulkIn}, 4096, nil},
+		{nil, 100, nil},
+	}
+	for _, tt := range tests {
+		peer := &Peer{conns: tt.conns}
+		if remote := peer.Conn(tt.size); remote != tt.expected {
+			t.Errorf("Conn(%d) picked %p from %v; expected %p", tt.size, remote, tt.conns, tt.expected)
+		}
+	}
+}
+
+// TestConnRace checks that the connection from the peer with the higher NID wins.
+func TestConnRace(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server := NewLNetServer()
+	nid, _ := startServer(t, ctx, server)
+	defer server.Shutdown(ctx)
+
+	tests := []struct {
+		addr     string
+		rejected bool
+	}{
+		{"10.0.0.1", true},
+		{"192.168.0.1", false},
+	}
+	for _, tt := range tests {
+		client := NewLNetClient()
+		client.LocalAddrs = []netip.Addr{netip.MustParseAddr(tt.addr)}
+		clientNID, err := ParseNID(tt.addr + "@tcp0")
+		if err != nil {
+			t.Fatalf("ParseNID failed: %v", err)
+		}
+		// The server is connecting to the client at the same time
+		peer := server.Client.Peers.peer(clientNID)
+		peer.mu.Lock()
+		peer.connecting[SOCKLND_CONN_CONTROL]++
+		peer.mu.Unlock()
+
+		remote, err := client.DialType(ctx, nid, SOCKLND_CONN_CONTROL)
+		if tt.rejected {
+			if !errors.Is(err, ErrConnRejected) {
+				t.Errorf("Dial from %s returned %v; expected %v", tt.addr, err, ErrConnRejected)
+			}
+			if len(peer.Conns()) != 0 {
+				t.Errorf("Rejected connection from %s was added to the peer table", tt.addr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Dial from %s failed: %v", tt.addr, err)
+		}
+		defer remote.Close()
+		if conns := peer.Conns(); len(conns) != 1 || conns[0].ConnType != SOCKLND_CONN_CONTROL {
+			t.Errorf("Server has connections %v from %s; expected one CONTROL connection", conns, tt.addr)
+		}
+		if client.Peers.Peer(nid).Conn(100) != remote {
+			t.Errorf("Client did not add its connection to the peer table")
+		}
+	}
+}
+
+func TestPeerRestart(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server := NewLNetServer()
+	events := make(chan PeerEvent, 1)
+	server.Client.SubscribePeerEvents(func(event PeerEvent) { events <- event })
+	nid, _ := startServer(t, ctx, server)
+	defer server.Shutdown(ctx)
+
+	dial := func(incarnation uint64) (LNetClient, *RemoteConn) {
+		client := NewLNetClient()
+		client.LocalAddrs = []netip.Addr{netip.MustParseAddr("10.0.0.1")}
+		client.Incarnation = incarnation
+		remote, err := client.Dial(ctx, nid)
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		return client, remote
+	}
+	stale, before := dial(1)
+	defer before.Close()
+	_, after := dial(2)
+	defer after.Close()
+
+	select {
+	case event := <-events:
+		if event.Type != PEER_EVENT_RESET || event.NID.NetAddr() != netip.MustParseAddr("10.0.0.1") {
+			t.Errorf("Got event %+v; expected PEER_EVENT_RESET for 10.0.0.1", event)
+		}
+	case <-ctx.Done():
+		t.Fatal("No peer event was published")
+	}
+	peer := server.Client.Peers.Peer(after.LocalNID)
+	if peer.Incarnation() != 2 {
+		t.Errorf("Peer has incarnation %d; expected 2", peer.Incarnation())
+	}
+	if conns := peer.Conns(); len(conns) != 1 || conns[0].Incarnation != 2 {
+		t.Errorf("Peer has %d connections; expected only the new one", len(conns))
+	}
+	// The server closed the stale connection, which ends it on the client too
+	for peer := stale.Peers.Peer(nid); peer != nil && len(peer.Conns()) != 0; peer = stale.Peers.Peer(nid) {
+		if ctx.Err() != nil {
+			t.Fatal("Stale connection is still open")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestForgetIdlePeer checks that peers are forgotten once their last connection ends,
+// and that failed dials leave no peer behind, unless a route goes through it.
+func TestForgetIdlePeer(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server := NewLNetServer()
+	nid, _ := startServer(t, ctx, server)
+	defer server.Shutdown(ctx)
+
+	client := NewLNetClient()
+	remote, err := client.Dial(ctx, nid)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	if client.Peers.Peer(nid) == nil {
+		t.Fatalf("Dialed peer is not in the peer table")
+	}
+	remote.Close()
+	for client.Peers.Peer(nid) != nil || server.Client.Peers.Peer(remote.LocalNID) != nil {
+		if ctx.Err() != nil {
+			t.Fatalf("Peers without connections are still in the peer tables")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	dead := mustParseNID(t, fmt.Sprintf("127.0.0.1@tcp0#%d", free.Addr().(*net.TCPAddr).Port))
+	free.Close()
+	if _, err := client.Dial(ctx, dead); err == nil {
+		t.Fatalf("Dial to a closed port succeeded")
+	}
+	if client.Peers.Peer(dead) != nil {
+		t.Errorf("Failed dial added %s to the peer table", dead)
+	}
+	if err := client.Routes.Add(Route{NetType: NETWORK_TYPE_TCP, NetNum: 1, Gateway: dead}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if _, err := client.Dial(ctx, dead); err == nil {
+		t.Fatalf("Dial to a closed port succeeded")
+	}
+	if client.Peers.Peer(dead) == nil {
+		t.Errorf("Gateway %s was dropped from the peer table", dead)
+	}
+}
@@ -11,12 +11,20 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"log/slog"
+	"strings"
 )
 
 const LNET_PROTO_PING_MATCHBITS = 0x8000000000000000
 const LNET_PING_MAGIC uint32 = 0x70696E67 // "ping" in ASCII
 
+// Portal used by LNet itself (ping)
+const LNET_RESERVED_PORTAL uint32 = 0
+
+// Number of NIDs we first make room for when pinging a peer
+const DEFAULT_PING_NIDS = 16
+
 type PingStatus uint32
 type PingFeature uint32
 
@@ -38,6 +46,52 @@ const (
 	PING_FEATURE_METADATA      PingFeature = 1 << 7
 )
 
+var pingFeatureNames = []struct {
+	feature PingFeature
+	name    string
+}{
+	{PING_FEATURE_PING, "PING"},
+	{PING_FEATURE_NI_STATUS, "NI_STATUS"},
+	{PING_FEATURE_RTE_DISABLED, "RTE_DISABLED"},
+	{PING_FEATURE_MULTI_RAIL, "MULTI_RAIL"},
+	{PING_FEATURE_DISCOVERY, "DISCOVERY"},
+	{PING_FEATURE_LARGE_ADDRESS, "LARGE_ADDRESS"},
+	{PING_FEATURE_PRIMARY_LARGE, "PRIMARY_LARGE"},
+	{PING_FEATURE_METADATA, "METADATA"},
+}
+
+func (status PingStatus) String() string {
+	switch status {
+	case PING_NI_STATUS_UP:
+		return "UP"
+	case PING_NI_STATUS_DOWN:
+		return "DOWN"
+	case PING_NI_STATUS_INVALID:
+		return "INVALID"
+	default:
+		return fmt.Sprintf("unknown(0x%08x)", uint32(status))
+	}
+}
+
+// Names returns the names of all feature bits that are set.
+func (features PingFeature) Names() []string {
+	var names []string
+	for _, entry := range pingFeatureNames {
+		if features&entry.feature != 0 {
+			names = append(names, entry.name)
+			features &^= entry.feature
+		}
+	}
+	if features != 0 {
+		names = append(names, fmt.Sprintf("0x%x", uint32(features)))
+	}
+	return names
+}
+
+func (features PingFeature) String() string {
+	return strings.Join(features.Names(), "|")
+}
+
 type PingHeader struct {
 	Magic    uint32
 	Features uint32
@@ -48,71 +102,342 @@ type PingHeader struct {
 type PingResponse struct {
 	PingHeader
 	NIDStatuses []NIDStatus
+	// Opaque bytes after the NIs (with PING_FEATURE_METADATA), which are not interpreted
+	Metadata []byte
 }
 
 type NIDStatus struct {
 	NID         NID
 	Status      PingStatus
 	MessageSize uint32
+	// Listed as lnet_ni_large_status (with PING_FEATURE_LARGE_ADDRESS)
+	Large bool
 }
 
+// lnet-idl.h: struct lnet_ni_status
+type pingNIDStatus struct {
+	NID         RawNID64
+	Status      PingStatus
+	MessageSize uint32
+}
+
+// ToBytes encodes the ping buffer (see FromBytes).
+// NIDs that do not fit in 64 bits (and those marked Large) are listed as lnet_ni_large_status
+// after the lnet_ni_status entries, which sets PING_FEATURE_LARGE_ADDRESS.
 func (ping *PingResponse) ToBytes(byteOrder binary.ByteOrder) ([]byte, error) {
+	var statuses, largeStatuses []NIDStatus
+	for _, nidStatus := range ping.NIDStatuses {
+		if _, ok := nidStatus.NID.(NID64); ok && !nidStatus.Large {
+			statuses = append(statuses, nidStatus)
+		} else {
+			largeStatuses = append(largeStatuses, nidStatus)
+		}
+	}
+	ping.PingHeader.NIDCount = uint32(len(statuses))
+	if len(largeStatuses) > 0 {
+		ping.Features |= uint32(PING_FEATURE_LARGE_ADDRESS)
+	}
 	buf := new(bytes.Buffer)
-	ping.PingHeader.NIDCount = uint32(len(ping.NIDStatuses))
 	if err := binary.Write(buf, byteOrder, ping.PingHeader); err != nil {
 		return nil, fmt.Errorf("failed to write PingHeader: %w", err)
 	}
-	for _, nidStatus := range ping.NIDStatuses {
-		nidBytes, err := nidStatus.NID.ToBytes(byteOrder)
-		if err != nil {
-			return nil, fmt.Errorf("failed to write NIDStatus: %w", err)
+	for _, nidStatus := range statuses {
+		rawStatus := pingNIDStatus{
+			NID:         nidStatus.NID.(NID64).Raw(),
+			Status:      nidStatus.Status,
+			MessageSize: nidStatus.MessageSize,
 		}
-		if err := binary.Write(buf, byteOrder, nidBytes); err != nil {
+		if err := binary.Write(buf, byteOrder, rawStatus); err != nil {
 			return nil, fmt.Errorf("failed to write NIDStatus: %w", err)
 		}
+	}
+	for _, nidStatus := range largeStatuses {
+		// lnet-idl.h: struct lnet_ni_large_status, which only has NID_BYTES of the NID
 		if err := binary.Write(buf, byteOrder, nidStatus.Status); err != nil {
-			return nil, fmt.Errorf("failed to write NIDStatus: %w", err)
-		}
-		if err := binary.Write(buf, byteOrder, nidStatus.MessageSize); err != nil {
-			return nil, fmt.Errorf("failed to write NIDStatus: %w", err)
+			return nil, fmt.Errorf("failed to write large NIDStatus: %w", err)
 		}
+		buf.Write(appendLargeNID(nil, nidStatus.NID))
+	}
+	if PingFeature(ping.Features)&PING_FEATURE_METADATA != 0 {
+		buf.Write(ping.Metadata)
 	}
 	return buf.Bytes(), nil
 }
 
-// HandlePing handles a PING command.
-func (client *LNetClient) HandlePing(ctx context.Context, remote *RemoteConn, message LNetMessage, command LNetGetCommand) error {
-	slog.Info("Handling PING command", "remote", remote, "command", command)
-	if command.MatchBits != LNET_PROTO_PING_MATCHBITS {
-		return fmt.Errorf("LNET PING has invalid match bits: %d", command.MatchBits)
+// FromBytes decodes a ping buffer (as found in a REPLY payload).
+// The byte order is switched if the buffer was written by a peer with the opposite byte order.
+//
+// The buffer holds NIDCount lnet_ni_status entries (NID64).
+// With PING_FEATURE_LARGE_ADDRESS, lnet_ni_large_status entries (struct lnet_nid, any size) follow,
+// With PING_FEATURE_METADATA, the NIs are followed by metadata, whose layout we do not know:
+// the bytes from the first entry that does not decode are kept as opaque Metadata.
+func (ping *PingResponse) FromBytes(data []byte, byteOrder binary.ByteOrder) error {
+	reader := bytes.NewReader(data)
+	if err := binary.Read(reader, byteOrder, &ping.PingHeader); err != nil {
+		return fmt.Errorf("failed to read PingHeader: %w", err)
 	}
-	if command.PortalIndex != 0 {
-		slog.Warn("LNET PING has non-standard portal index", "portalIndex", command.PortalIndex)
+	switch ping.Magic {
+	case LNET_PING_MAGIC:
+	case Swab32(LNET_PING_MAGIC):
+		return ping.FromBytes(data, GetOppositeByteOrder(byteOrder))
+	default:
+		return fmt.Errorf("invalid ping magic: expected 0x%08x, got 0x%08x", LNET_PING_MAGIC, ping.Magic)
 	}
-	replyMessage := message.GetReply()
+	features := PingFeature(ping.Features)
+	statusSize := binary.Size(pingNIDStatus{})
+	if maxCount := reader.Len() / statusSize; int(ping.NIDCount) > maxCount {
+		return fmt.Errorf("ping buffer of %d bytes cannot hold %d NIDs: %w", len(data), ping.NIDCount, io.ErrUnexpectedEOF)
+	}
+	ping.NIDStatuses = make([]NIDStatus, 0, ping.NIDCount)
+	ping.Metadata = nil
+	for i := range ping.NIDCount {
+		var rawStatus pingNIDStatus
+		if err := binary.Read(reader, byteOrder, &rawStatus); err != nil {
+			return fmt.Errorf("failed to read NIDStatus %d: %w", i, err)
+		}
+		if err := validatePingStatus(features, rawStatus.Status); err != nil {
+			return fmt.Errorf("invalid NIDStatus %d: %w", i, err)
+		}
+		ping.NIDStatuses = append(ping.NIDStatuses, NIDStatus{
+			NID:         rawStatus.NID.ToNID64(),
+			Status:      rawStatus.Status,
+			MessageSize: rawStatus.MessageSize,
+		})
+	}
+
+	rest := data[len(data)-reader.Len():]
+	metadata := features&PING_FEATURE_METADATA != 0
+	if features&PING_FEATURE_LARGE_ADDRESS == 0 {
+		if metadata && len(rest) > 0 {
+			ping.Metadata = bytes.Clone(rest)
+			return nil
+		}
+		if len(rest) != 0 {
+			return fmt.Errorf("ping buffer has %d bytes after %d NIDs", len(rest), ping.NIDCount)
+		}
+		return nil
+	}
+	largeCount := 0
+	for len(rest) > 0 {
+		// lnet-idl.h: struct lnet_ni_large_status
+		nid, status, size, err := decodeLargeStatus(rest, byteOrder, features)
+		if err != nil {
+			if metadata {
+				ping.Metadata = bytes.Clone(rest)
+				break
+			}
+			return fmt.Errorf("failed to read large NIDStatus %d: %w", largeCount, err)
+		}
+		ping.NIDStatuses = append(ping.NIDStatuses, NIDStatus{NID: nid, Status: status, Large: true})
+		rest = rest[size:]
+		largeCount++
+	}
+	if features&PING_FEATURE_PRIMARY_LARGE != 0 && largeCount == 0 {
+		return fmt.Errorf("ping buffer claims a large primary NID, but has no large NIDs")
+	}
+	return nil
+}
+
+// validatePingStatus checks an NI status, which is only meaningful with PING_FEATURE_NI_STATUS.
+func validatePingStatus(features PingFeature, status PingStatus) error {
+	if features&PING_FEATURE_NI_STATUS == 0 {
+		return nil
+	}
+	switch status {
+	case PING_NI_STATUS_UP, PING_NI_STATUS_DOWN, PING_NI_STATUS_INVALID:
+		return nil
+	}
+	return fmt.Errorf("unknown NI status 0x%08x", uint32(status))
+}
+
+// decodeLargeStatus decodes an lnet_ni_large_status from the start of data,
+// and returns the number of bytes used.
+func decodeLargeStatus(data []byte, byteOrder binary.ByteOrder, features PingFeature) (NID, PingStatus, int, error) {
+	if len(data) < 4 {
+		return nil, 0, 0, io.ErrUnexpectedEOF
+	}
+	status := PingStatus(byteOrder.Uint32(data[:4]))
+	if err := validatePingStatus(features, status); err != nil {
+		return nil, 0, 0, err
+	}
+	nid, size, err := decodeLargeNID(data[4:])
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return nid, status, 4 + size, nil
+}
+
+// Primary returns the primary NID of the peer.
+// This is the first large NID with PING_FEATURE_PRIMARY_LARGE, otherwise the first non-loopback NID.
+func (ping *PingResponse) Primary() NID {
+	var primary NID
+	for _, nidStatus := range ping.NIDStatuses {
+		if nidStatus.Large && PingFeature(ping.Features)&PING_FEATURE_PRIMARY_LARGE != 0 {
+			return nidStatus.NID
+		}
+		if primary == nil && !nidStatus.Large && nidStatus.NID.Header().Type != NETWORK_TYPE_LO {
+			primary = nidStatus.NID
+		}
+	}
+	return primary
+}
+
+// Ping connects to the peer and fetches its ping buffer.
+func (client *LNetClient) Ping(ctx context.Context, nid NID) (PingResponse, error) {
+	remote, err := client.Dial(ctx, nid)
+	if err != nil {
+		return PingResponse{}, err
+	}
+	defer func() {
+		if err := remote.Close(); err != nil {
+			slog.Warn("error closing connection", "error", err, "remote", remote)
+		}
+	}()
+	return client.PingRemote(ctx, remote)
+}
+
+// PingRemote fetches the ping buffer of a connected peer with an LNET GET.
+func (client *LNetClient) PingRemote(ctx context.Context, remote *RemoteConn) (PingResponse, error) {
+	return client.ping(ctx, remote, remote.NID)
+}
+
+// PingNID fetches the ping buffer of the peer with the given NID, which may be on a remote network,
+// over the connection PeerConn would use.
+func (client *LNetClient) PingNID(ctx context.Context, nid NID) (PingResponse, error) {
+	var ping PingResponse
+	err := client.resend(ctx, nid, 0, func(ctx context.Context, remote *RemoteConn, dest NID) (err error) {
+		ping, err = client.ping(ctx, remote, dest)
+		return err
+	})
+	return ping, err
+}
+
+// ping fetches the ping buffer of dest over the connection to the remote.
+// The reply to a peer with more NIDs than we made room for is truncated, so a full buffer is
+// fetched again with room for the NIDs its header counts (at least twice as many), like Lustre does.
+func (client *LNetClient) ping(ctx context.Context, remote *RemoteConn, dest NID) (PingResponse, error) {
+	// Room for each NID as either lnet_ni_status or lnet_ni_large_status
+	statusSize := max(binary.Size(pingNIDStatus{}), 4+LNET_NID_SIZE)
+	headerSize := binary.Size(PingHeader{})
+	maxNIDs := (LNET_MTU - headerSize) / statusSize
+	nids := DEFAULT_PING_NIDS
+	for {
+		buffer := make([]byte, headerSize+nids*statusSize)
+		length, err := client.get(ctx, remote, dest, LNET_RESERVED_PORTAL, LNET_PROTO_PING_MATCHBITS, 0, buffer)
+		if err != nil {
+			return PingResponse{}, err
+		}
+		var ping PingResponse
+		err = ping.FromBytes(buffer[:length], remote.ByteOrder)
+		if next := min(max(int(ping.NIDCount), 2*nids), maxNIDs); length == len(buffer) && next > nids {
+			slog.Debug("ping reply may be truncated, fetching it again", "nid", dest, "nids", next)
+			nids = next
+			continue
+		}
+		if err != nil {
+			return PingResponse{}, fmt.Errorf("failed to decode ping reply: %w", err)
+		}
+		return ping, nil
+	}
+}
+
+// pingBuffer returns our ping buffer, as sent to the remote in ping replies and discovery pushes.
+func (client *LNetClient) pingBuffer(remote *RemoteConn, pid PID32) PingResponse {
 	pingResponse := PingResponse{
 		PingHeader: PingHeader{
 			Magic:    LNET_PING_MAGIC,
 			Features: uint32(PING_FEATURE_PING | PING_FEATURE_NI_STATUS),
-			PID:      message.DestPID,
+			PID:      pid,
 		},
-		NIDStatuses: make([]NIDStatus, len(client.LocalAddrs)),
 	}
-	for i, addr := range client.LocalAddrs {
-		nid, err := NIDFromAddr(addr, NETWORK_TYPE_TCP, 0, client.Port)
-		if err != nil {
-			return fmt.Errorf("error creating NID from address: %w", err)
+	pingResponse.NIDStatuses = client.pingStatuses(remote)
+	if len(client.NIs.NIs()) > 0 {
+		// Sends to peers are spread over the NIs by selectPath
+		pingResponse.Features |= uint32(PING_FEATURE_MULTI_RAIL)
+		// Without NIs, we have no NIDs of our own to be discovered by
+		if client.Discovery {
+			pingResponse.Features |= uint32(PING_FEATURE_DISCOVERY)
 		}
-		pingResponse.NIDStatuses[i] = NIDStatus{
-			NID:         nid,
-			Status:      PING_NI_STATUS_UP,
-			MessageSize: 0,
+	}
+	if !client.Forwarding {
+		// Peers must not use us as a gateway
+		pingResponse.Features |= uint32(PING_FEATURE_RTE_DISABLED)
+	}
+	if len(pingResponse.NIDStatuses) > 0 {
+		if _, ok := pingResponse.NIDStatuses[0].NID.(NID64); !ok {
+			pingResponse.Features |= uint32(PING_FEATURE_PRIMARY_LARGE)
 		}
 	}
+	return pingResponse
+}
+
+// HandlePing handles a PING command.
+func (client *LNetClient) HandlePing(ctx context.Context, remote *RemoteConn, message LNetMessage, command LNetGetCommand) error {
+	slog.Info("Handling PING command", "remote", remote, "command", command)
+	if command.MatchBits != LNET_PROTO_PING_MATCHBITS {
+		return fmt.Errorf("LNET PING has invalid match bits: %d", command.MatchBits)
+	}
+	if command.PortalIndex != 0 {
+		slog.Warn("LNET PING has non-standard portal index", "portalIndex", command.PortalIndex)
+	}
+	replyMessage := message.GetReply()
+	pingResponse := client.pingBuffer(remote, message.DestPID)
 	payload, err := pingResponse.ToBytes(remote.ByteOrder)
 	if err != nil {
 		return fmt.Errorf("failed to convert ping response to bytes: %w", err)
 	}
+	// Like Lustre's ping buffer (LNET_MD_TRUNCATE), the reply is cut to the room the peer made for it
+	if len(payload) > int(command.SinkLength) {
+		payload = payload[:command.SinkLength]
+	}
 	replyMessage.SetPayload(remote.ByteOrder, payload)
 	return client.SendMessage(ctx, remote, replyMessage)
 }
+
+// pingStatuses returns our NIDs for a ping reply: those of the configured NIs with their status
+// or, without NIs, one per local address on the network the peer reached us on.
+// Without either, this is the NID the peer connected to.
+func (client *LNetClient) pingStatuses(remote *RemoteConn) []NIDStatus {
+	var statuses []NIDStatus
+	unix := remote.driver().NetworkType() == NETWORK_TYPE_UNIX
+	for _, info := range client.NIs.Info() {
+		if info.NetType == NETWORK_TYPE_UNIX && !unix {
+			// Only peers on this node can use our sockets
+			continue
+		}
+		nid, err := info.NID(client.Port)
+		if err != nil {
+			slog.Warn("skipping NI in ping reply", "ni", info.LocalNI, "error", err)
+			continue
+		}
+		statuses = append(statuses, NIDStatus{NID: nid, Status: info.Status})
+	}
+	if len(statuses) > 0 {
+		return statuses
+	}
+	for _, nid := range client.addrNIDs(remote) {
+		statuses = append(statuses, NIDStatus{NID: nid, Status: PING_NI_STATUS_UP})
+	}
+	return statuses
+}
+
+// addrNIDs returns a NID per local address, on the network the peer reached us on.
+func (client *LNetClient) addrNIDs(remote *RemoteConn) []NID {
+	header := NIDHeader{Type: NETWORK_TYPE_TCP}
+	if remote.LocalNID != nil && !remote.LocalNID.IsAny() {
+		if len(client.LocalAddrs) == 0 || remote.LocalNID.Header().Type == NETWORK_TYPE_UNIX {
+			return []NID{remote.LocalNID}
+		}
+		header = remote.LocalNID.Header()
+	}
+	nids := make([]NID, 0, len(client.LocalAddrs))
+	for _, addr := range client.LocalAddrs {
+		nid, err := NIDFromAddr(addr, header.Type, header.NetworkIndex, client.Port)
+		if err != nil {
+			slog.Warn("skipping local address in ping reply", "addr", addr, "error", err)
+			continue
+		}
+		nids = append(nids, nid)
+	}
+	return nids
+}
@@ -0,0 +1,240 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for the LNet ping implementation.
+*/
+package lnet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPingResponseRoundTrip(t *testing.T) {
+	nid, err := ParseNID("192.168.1.2@tcp1")
+	if err != nil {
+		t.Fatalf("ParseNID failed: %v", err)
+	}
+	ping := PingResponse{
+		PingHeader: PingHeader{
+			Magic:    LNET_PING_MAGIC,
+			Features: uint32(PING_FEATURE_PING | PING_FEATURE_NI_STATUS),
+			PID:      PID_LUSTRE,
+		},
+		NIDStatuses: []NIDStatus{{NID: nid, Status: PING_NI_STATUS_UP}},
+	}
+	for _, byteOrder := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		data, err := ping.ToBytes(byteOrder)
+		if err != nil {
+			t.Fatalf("ToBytes failed: %v", err)
+		}
+		var decoded PingResponse
+		// Decoding with the wrong byte order must detect and switch it
+		if err := decoded.FromBytes(data, GetOppositeByteOrder(byteOrder)); err != nil {
+			t.Fatalf("FromBytes failed: %v", err)
+		}
+		if decoded.PingHeader != ping.PingHeader {
+			t.Errorf("expected header %+v, got %+v", ping.PingHeader, decoded.PingHeader)
+		}
+		if len(decoded.NIDStatuses) != 1 {
+			t.Fatalf("expected 1 NID, got %d", len(decoded.NIDStatuses))
+		}
+		if !SameNID(decoded.NIDStatuses[0].NID, nid) || decoded.NIDStatuses[0].Status != PING_NI_STATUS_UP {
+			t.Errorf("expected %s UP, got %s %s", nid, decoded.NIDStatuses[0].NID, decoded.NIDStatuses[0].Status)
+		}
+	}
+}
+
+// buildPingBuffer builds a ping buffer with one NID64 and one IPv6 large NID, like a Lustre 2.15+ node.
+func buildPingBuffer(byteOrder rwByteOrder, features PingFeature, metadata []byte) []byte {
+	var data []byte
+	data = byteOrder.AppendUint32(data, LNET_PING_MAGIC)
+	data = byteOrder.AppendUint32(data, uint32(features))
+	data = byteOrder.AppendUint32(data, uint32(PID_LUSTRE))
+	data = byteOrder.AppendUint32(data, 1)
+	// lnet_ni_status: 10.0.0.1@tcp
+	data = byteOrder.AppendUint64(data, uint64(NETWORK_TYPE_TCP)<<48|0x0a000001)
+	data = byteOrder.AppendUint32(data, uint32(PING_NI_STATUS_UP))
+	data = byteOrder.AppendUint32(data, 0)
+	// lnet_ni_large_status: fd00::1@tcp1
+	data = byteOrder.AppendUint32(data, uint32(PING_NI_STATUS_DOWN))
+	data = append(data, 12, byte(NETWORK_TYPE_TCP), 0, 1)
+	data = append(data, netip.MustParseAddr("fd00::1").AsSlice()...)
+	if features&PING_FEATURE_METADATA != 0 {
+		data = append(data, metadata...)
+	}
+	return data
+}
+
+func TestPingResponseFromBytesLarge(t *testing.T) {
+	features := PING_FEATURE_PING | PING_FEATURE_NI_STATUS | PING_FEATURE_LARGE_ADDRESS | PING_FEATURE_PRIMARY_LARGE | PING_FEATURE_METADATA
+	metadata := []byte("glimmer!")
+	for _, byteOrder := range []rwByteOrder{binary.LittleEndian, binary.BigEndian} {
+		var ping PingResponse
+		if err := ping.FromBytes(buildPingBuffer(byteOrder, features, metadata), DEFAULT_BYTE_ORDER); err != nil {
+			t.Fatalf("FromBytes (%v) failed: %v", byteOrder, err)
+		}
+		if len(ping.NIDStatuses) != 2 {
+			t.Fatalf("expected 2 NIDs, got %d", len(ping.NIDStatuses))
+		}
+		if addr := ping.NIDStatuses[0].NID.NetAddr(); addr != netip.MustParseAddr("10.0.0.1") {
+			t.Errorf("expected NID64 address 10.0.0.1, got %s", addr)
+		}
+		large := ping.NIDStatuses[1]
+		if !large.Large || large.Status != PING_NI_STATUS_DOWN {
+			t.Errorf("expected large NID to be DOWN, got %+v", large)
+		}
+		if addr := large.NID.NetAddr(); addr != netip.MustParseAddr("fd00::1") {
+			t.Errorf("expected large NID address fd00::1, got %s", addr)
+		}
+		if large.NID.Header().NetworkIndex != 1 {
+			t.Errorf("expected large NID on tcp1, got %s", large.NID)
+		}
+		if !SameNID(ping.Primary(), large.NID) {
+			t.Errorf("expected primary NID %s, got %s", large.NID, ping.Primary())
+		}
+		if string(ping.Metadata) != string(metadata) {
+			t.Errorf("expected metadata %q, got %q", metadata, ping.Metadata)
+		}
+	}
+}
+
+// TestPingResponseMetadata checks that metadata, whose layout we do not know, is kept as opaque
+// bytes after the NIs that decode, rather than failing the ping.
+func TestPingResponseMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		features PingFeature
+		metadata []byte
+		nids     int
+	}{
+		{"none", PING_FEATURE_LARGE_ADDRESS, nil, 2},
+		{"after large NIDs", PING_FEATURE_LARGE_ADDRESS, []byte{0xde, 0xad, 0xbe, 0xef, 0x07}, 2},
+		{"after NID64s", 0, []byte("glimmer"), 1},
+	}
+	for _, test := range tests {
+		data := buildPingBuffer(binary.LittleEndian, PING_FEATURE_PING|PING_FEATURE_NI_STATUS|PING_FEATURE_METADATA|test.features, test.metadata)
+		if test.features&PING_FEATURE_LARGE_ADDRESS == 0 {
+			// Drop the large NID
+			data = slices.Delete(data, 32, 56)
+		}
+		var ping PingResponse
+		if err := ping.FromBytes(data, binary.LittleEndian); err != nil {
+			t.Errorf("%s: FromBytes failed: %v", test.name, err)
+			continue
+		}
+		if len(ping.NIDStatuses) != test.nids || !bytes.Equal(ping.Metadata, test.metadata) {
+			t.Errorf("%s: got %d NIDs and metadata %x; expected %d NIDs and %x", test.name, len(ping.NIDStatuses), ping.Metadata, test.nids, test.metadata)
+		}
+	}
+}
+
+func TestPingResponseFromBytesInvalid(t *testing.T) {
+	features := PING_FEATURE_PING | PING_FEATURE_NI_STATUS | PING_FEATURE_LARGE_ADDRESS
+	valid := buildPingBuffer(binary.LittleEndian, features, nil)
+	tooManyNIDs := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint32(tooManyNIDs[12:], 5)
+	badStatus := append([]byte{}, valid...)
+	binary.LittleEndian.PutUint32(badStatus[24:], 0x12345678)
+	noLargeFeature := buildPingBuffer(binary.LittleEndian, PING_FEATURE_PING, nil)
+
+	var tests = []struct {
+		name      string
+		data      []byte
+		truncated bool
+	}{
+		{"header", valid[:10], true},
+		{"large NID", valid[:len(valid)-3], true},
+		{"NID count", tooManyNIDs, true},
+		{"status", badStatus, false},
+		{"trailing bytes", noLargeFeature, false},
+	}
+	for _, test := range tests {
+		var ping PingResponse
+		err := ping.FromBytes(test.data, binary.LittleEndian)
+		if err == nil {
+			t.Errorf("%s: expected an error", test.name)
+			continue
+		}
+		if test.truncated && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%s: expected a truncation error, got %v", test.name, err)
+		}
+	}
+}
+
+// TestPingBufferFeatures checks that DISCOVERY is only advertised by multi-rail nodes.
+func TestPingBufferFeatures(t *testing.T) {
+	client := NewLNetClient()
+	client.Discovery = true
+	if features := PingFeature(client.pingBuffer(&RemoteConn{}, client.PID).Features); features&(PING_FEATURE_MULTI_RAIL|PING_FEATURE_DISCOVERY) != 0 {
+		t.Errorf("Ping buffer without NIs has features %v; expected neither MULTI_RAIL nor DISCOVERY", features)
+	}
+	client.NIs.Set([]LocalNI{testNI(0, "10.0.0.1")})
+	if features := PingFeature(client.pingBuffer(&RemoteConn{}, client.PID).Features); features&PING_FEATURE_MULTI_RAIL == 0 || features&PING_FEATURE_DISCOVERY == 0 {
+		t.Errorf("Ping buffer with NIs has features %v; expected MULTI_RAIL and DISCOVERY", features)
+	}
+}
+
+func TestPingFeatureNames(t *testing.T) {
+	features := PING_FEATURE_PING | PING_FEATURE_DISCOVERY | PingFeature(1<<12)
+	if features.String() != "PING|DISCOVERY|0x1000" {
+		t.Errorf("unexpected feature string: %s", features)
+	}
+}
+
+func TestPing(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server := NewLNetClient()
+	server.LocalAddrs = []netip.Addr{netip.MustParseAddr("127.0.0.1")}
+	nid := serveOnce(t, ctx, &server)
+
+	client := NewLNetClient()
+	ping, err := client.Ping(ctx, nid)
+	if err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	if len(ping.NIDStatuses) != 1 || ping.NIDStatuses[0].NID.NetAddr() != server.LocalAddrs[0] {
+		t.Errorf("unexpected ping NIDs: %+v", ping.NIDStatuses)
+	}
+}
+
+// TestPingManyNIDs checks that the ping buffer of a peer with more NIDs than DEFAULT_PING_NIDS
+// is fetched whole.
+func TestPingManyNIDs(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server := NewLNetClient()
+	var nis []LocalNI
+	for i := range 30 {
+		nis = append(nis, testNI(0, fmt.Sprintf("10.0.0.%d", i+1)))
+	}
+	for i := range 10 {
+		nis = append(nis, testNI(0, fmt.Sprintf("fd00::%d", i+1)))
+	}
+	server.NIs.Set(nis)
+	nid := serveOnce(t, ctx, &server)
+
+	client := NewLNetClient()
+	ping, err := client.Ping(ctx, nid)
+	if err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	if len(ping.NIDStatuses) != len(nis) {
+		t.Fatalf("Ping returned %d NIDs; expected %d", len(ping.NIDStatuses), len(nis))
+	}
+	for i, status := range ping.NIDStatuses {
+		if status.NID.NetAddr() != nis[i].Addr {
+			t.Errorf("NID %d is %v; expected %v", i, status.NID, nis[i].Addr)
+		}
+	}
+}
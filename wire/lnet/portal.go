@@ -0,0 +1,286 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+LNet portal table: match entries (ME) and memory descriptors (MD).
+
+Incoming PUTs and GETs name a portal index and match bits.
+The match entries attached to the portal are searched in order,
+and the first one that matches (and whose MD accepts the operation) serves the request.
+*/
+package lnet
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// lnet-types.h
+const (
+	MAX_PORTALS = 64
+	// Threshold for MDs that are never exhausted
+	LNET_MD_THRESH_INF = -1
+	// Matches any PID
+	PID_ANY PID32 = 0xFFFFFFFF
+)
+
+// MDOptions control how a memory descriptor is matched and filled.
+type MDOptions uint32
+
+// lnet-types.h
+const (
+	// The MD accepts PUT operations
+	LNET_MD_OP_PUT MDOptions = 1 << 0
+	// The MD accepts GET operations
+	LNET_MD_OP_GET MDOptions = 1 << 1
+	// Use the offset from the request instead of the local offset
+	LNET_MD_MANAGE_REMOTE MDOptions = 1 << 2
+	// Accept requests that are larger than the MD, truncating them
+	LNET_MD_TRUNCATE MDOptions = 1 << 4
+	// Do not send ACKs for PUTs into this MD
+	LNET_MD_ACK_DISABLE MDOptions = 1 << 5
+	// Limit each operation to MaxSize bytes
+	LNET_MD_MAX_SIZE MDOptions = 1 << 7
+)
+
+// Portal matching errors
+var (
+	// No match entry on the portal accepts the request
+	ErrNoMatch = errors.New("no matching memory descriptor")
+	// A memory descriptor matched, but cannot take the request
+	ErrMatchDropped = errors.New("matching memory descriptor cannot take request")
+)
+
+// MDHandle identifies a memory descriptor.
+// It is sent to peers as the object cookie of an LNetHandleWire.
+type MDHandle uint64
+
+// LNET_WIRE_HANDLE_COOKIE_NONE
+const MD_HANDLE_NONE MDHandle = 0xFFFFFFFFFFFFFFFF
+
+// ProcessID identifies an LNet process (lnet_process_id).
+type ProcessID struct {
+	NID NID
+	PID PID32
+}
+
+// Matches reports whether the process ID (which may contain wildcards) matches the other.
+func (id ProcessID) Matches(other ProcessID) bool {
+	if id.NID != nil && !id.NID.IsAny() && !SameNID(id.NID, other.NID) {
+		return false
+	}
+	return id.PID == PID_ANY || id.PID == other.PID
+}
+
+// AnyProcess matches any NID and PID.
+var AnyProcess = ProcessID{NID: AnyNID, PID: PID_ANY}
+
+// MemoryDescriptor describes a buffer that peers can PUT into or GET from.
+type MemoryDescriptor struct {
+	Buffer []byte
+	// Local offset of the next operation (unless LNET_MD_MANAGE_REMOTE)
+	Offset int
+	// Number of operations before the MD is exhausted, or LNET_MD_THRESH_INF
+	Threshold int
+	// Maximum size of each operation (with LNET_MD_MAX_SIZE)
+	MaxSize int
+	Options MDOptions
+	// Arbitrary data for the owner of the MD
+	UserData any
+	// Called for each operation on the MD
+	Handler EventHandler
+
+	handle  MDHandle
+	entry   *MatchEntry
+	eventMu sync.Mutex
+}
+
+// Handle returns the handle of a bound MD.
+func (md *MemoryDescriptor) Handle() MDHandle {
+	return md.handle
+}
+
+// exhausted reports whether the MD can no longer take operations.
+func (md *MemoryDescriptor) exhausted() bool {
+	if md.Threshold == 0 {
+		return true
+	}
+	return md.Options&LNET_MD_MAX_SIZE != 0 && len(md.Buffer)-md.Offset < md.MaxSize
+}
+
+// MatchEntry selects requests on a portal for its MD.
+type MatchEntry struct {
+	// Only requests from this process match (AnyProcess matches everything)
+	MatchID ProcessID
+	// Requests match if their match bits equal MatchBits, except for IgnoreBits
+	MatchBits  uint64
+	IgnoreBits uint64
+	// Unlink the entry once its MD is exhausted (LNET_UNLINK)
+	Unlink bool
+
+	portal uint32
+	md     *MemoryDescriptor
+}
+
+// matches reports whether the request is for this match entry.
+func (entry *MatchEntry) matches(source ProcessID, matchBits uint64) bool {
+	return entry.MatchID.Matches(source) && (entry.MatchBits^matchBits)&^entry.IgnoreBits == 0
+}
+
+// MatchResult is the part of an MD selected for a request.
+type MatchResult struct {
+	MD     *MemoryDescriptor
+	Offset int
+	Length int
+	// The MD was unlinked because this request exhausted it
+	Unlinked bool
+}
+
+// Data returns the selected part of the MD buffer.
+func (result MatchResult) Data() []byte {
+	return result.MD.Buffer[result.Offset : result.Offset+result.Length]
+}
+
+// PortalTable holds the match entries for each portal and all bound memory descriptors.
+type PortalTable struct {
+	mu         sync.Mutex
+	portals    [MAX_PORTALS][]*MatchEntry
+	mds        map[MDHandle]*MemoryDescriptor
+	nextHandle MDHandle
+}
+
+// NewPortalTable creates an empty portal table.
+func NewPortalTable() *PortalTable {
+	return &PortalTable{mds: make(map[MDHandle]*MemoryDescriptor), nextHandle: 1}
+}
+
+// Bind registers an MD that is not attached to a portal (e.g., the source of a PUT).
+func (table *PortalTable) Bind(md *MemoryDescriptor) (MDHandle, error) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	return table.bindLocked(md)
+}
+
+func (table *PortalTable) bindLocked(md *MemoryDescriptor) (MDHandle, error) {
+	if md.handle != 0 {
+		return 0, fmt.Errorf("memory descriptor is already bound")
+	}
+	if md.Options&LNET_MD_MAX_SIZE != 0 && (md.MaxSize < 0 || md.MaxSize > len(md.Buffer)) {
+		return 0, fmt.Errorf("invalid memory descriptor max size %d for %d bytes", md.MaxSize, len(md.Buffer))
+	}
+	md.handle = table.nextHandle
+	table.nextHandle++
+	table.mds[md.handle] = md
+	return md.handle, nil
+}
+
+// Attach binds the MD and attaches it to the portal with the match entry.
+// With insertAfter, the entry is matched after existing entries, otherwise before them.
+func (table *PortalTable) Attach(portal uint32, entry *MatchEntry, md *MemoryDescriptor, insertAfter bool) (MDHandle, error) {
+	if portal >= MAX_PORTALS {
+		return 0, fmt.Errorf("invalid portal index %d", portal)
+	}
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	if entry.md != nil {
+		return 0, fmt.Errorf("match entry is already attached")
+	}
+	handle, err := table.bindLocked(md)
+	if err != nil {
+		return 0, err
+	}
+	entry.portal = portal
+	entry.md = md
+	md.entry = entry
+	if insertAfter {
+		table.portals[portal] = append(table.portals[portal], entry)
+	} else {
+		table.portals[portal] = append([]*MatchEntry{entry}, table.portals[portal]...)
+	}
+	return handle, nil
+}
+
+// Lookup returns the MD for a handle.
+func (table *PortalTable) Lookup(handle MDHandle) (*MemoryDescriptor, bool) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	md, ok := table.mds[handle]
+	return md, ok
+}
+
+// Unlink removes the MD (and its match entry, if any).
+func (table *PortalTable) Unlink(handle MDHandle) error {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	md, ok := table.mds[handle]
+	if !ok {
+		return fmt.Errorf("unknown memory descriptor handle %d", handle)
+	}
+	table.unlinkLocked(md)
+	return nil
+}
+
+func (table *PortalTable) unlinkLocked(md *MemoryDescriptor) {
+	delete(table.mds, md.handle)
+	if md.entry == nil {
+		return
+	}
+	entries := table.portals[md.entry.portal]
+	for i, entry := range entries {
+		if entry == md.entry {
+			table.portals[md.entry.portal] = append(entries[:i:i], entries[i+1:]...)
+			break
+		}
+	}
+	md.entry = nil
+}
+
+// Match finds the MD for an incoming request and reserves the part it uses.
+// op is LNET_MD_OP_PUT or LNET_MD_OP_GET, offset and length are requested by the peer.
+// This follows lnet_try_match_md in Lustre.
+func (table *PortalTable) Match(op MDOptions, portal uint32, source ProcessID, matchBits uint64, offset int, length int) (MatchResult, error) {
+	if portal >= MAX_PORTALS {
+		return MatchResult{}, fmt.Errorf("invalid portal index %d: %w", portal, ErrNoMatch)
+	}
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	for _, entry := range table.portals[portal] {
+		md := entry.md
+		if md.exhausted() || md.Options&op == 0 || !entry.matches(source, matchBits) {
+			continue
+		}
+		matchOffset := offset
+		if md.Options&LNET_MD_MANAGE_REMOTE == 0 {
+			matchOffset = md.Offset
+		}
+		if matchOffset < 0 || matchOffset > len(md.Buffer) {
+			slog.Warn("dropping request with invalid offset", "portal", portal, "offset", matchOffset, "size", len(md.Buffer))
+			return MatchResult{}, ErrMatchDropped
+		}
+		available := len(md.Buffer) - matchOffset
+		if md.Options&LNET_MD_MAX_SIZE != 0 {
+			available = min(md.MaxSize, available)
+		}
+		matchLength := length
+		if length > available {
+			if md.Options&LNET_MD_TRUNCATE == 0 {
+				slog.Warn("dropping request that is too large", "portal", portal, "matchBits", matchBits, "length", length, "available", available)
+				return MatchResult{}, ErrMatchDropped
+			}
+			matchLength = available
+		}
+		md.Offset = matchOffset + matchLength
+		if md.Threshold != LNET_MD_THRESH_INF {
+			md.Threshold--
+		}
+		result := MatchResult{MD: md, Offset: matchOffset, Length: matchLength}
+		if entry.Unlink && md.exhausted() {
+			table.unlinkLocked(md)
+			result.Unlinked = true
+		}
+		return result, nil
+	}
+	return MatchResult{}, ErrNoMatch
+}
@@ -0,0 +1,132 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for the LNet portal table.
+*/
+package lnet
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPortalMatchBits(t *testing.T) {
+	table := NewPortalTable()
+	md := &MemoryDescriptor{Buffer: make([]byte, 64), Threshold: LNET_MD_THRESH_INF, Options: LNET_MD_OP_PUT}
+	entry := &MatchEntry{MatchID: AnyProcess, MatchBits: 0x1200, IgnoreBits: 0xFF}
+	if _, err := table.Attach(4, entry, md, true); err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+	source := ProcessID{NID: AnyNID, PID: PID_LUSTRE}
+	if _, err := table.Match(LNET_MD_OP_PUT, 4, source, 0x12AB, 0, 8); err != nil {
+		t.Errorf("expected ignored bits to match: %v", err)
+	}
+	if _, err := table.Match(LNET_MD_OP_PUT, 4, source, 0x13AB, 0, 8); !errors.Is(err, ErrNoMatch) {
+		t.Errorf("expected ErrNoMatch for different match bits, got %v", err)
+	}
+	if _, err := table.Match(LNET_MD_OP_GET, 4, source, 0x1200, 0, 8); !errors.Is(err, ErrNoMatch) {
+		t.Errorf("expected ErrNoMatch for GET on a PUT MD, got %v", err)
+	}
+	if _, err := table.Match(LNET_MD_OP_PUT, 5, source, 0x1200, 0, 8); !errors.Is(err, ErrNoMatch) {
+		t.Errorf("expected ErrNoMatch on another portal, got %v", err)
+	}
+}
+
+func TestPortalMatchOrder(t *testing.T) {
+	table := NewPortalTable()
+	first := &MemoryDescriptor{Buffer: make([]byte, 8), Threshold: 1, Options: LNET_MD_OP_GET}
+	second := &MemoryDescriptor{Buffer: make([]byte, 8), Threshold: 1, Options: LNET_MD_OP_GET}
+	if _, err := table.Attach(1, &MatchEntry{MatchID: AnyProcess, Unlink: true}, second, true); err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+	if _, err := table.Attach(1, &MatchEntry{MatchID: AnyProcess, Unlink: true}, first, false); err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+	for _, expected := range []*MemoryDescriptor{first, second} {
+		result, err := table.Match(LNET_MD_OP_GET, 1, AnyProcess, 0, 0, 8)
+		if err != nil {
+			t.Fatalf("Match failed: %v", err)
+		}
+		if result.MD != expected || !result.Unlinked {
+			t.Errorf("expected exhausted MD %d to be matched and unlinked, got %d (unlinked: %v)", expected.Handle(), result.MD.Handle(), result.Unlinked)
+		}
+		if _, ok := table.Lookup(expected.Handle()); ok {
+			t.Errorf("expected MD %d to be unlinked", expected.Handle())
+		}
+	}
+	if _, err := table.Match(LNET_MD_OP_GET, 1, AnyProcess, 0, 0, 8); !errors.Is(err, ErrNoMatch) {
+		t.Errorf("expected ErrNoMatch once all MDs are unlinked, got %v", err)
+	}
+}
+
+func TestPortalMatchOffsets(t *testing.T) {
+	table := NewPortalTable()
+	local := &MemoryDescriptor{Buffer: make([]byte, 16), Threshold: LNET_MD_THRESH_INF, Options: LNET_MD_OP_PUT}
+	if _, err := table.Attach(2, &MatchEntry{MatchID: AnyProcess, MatchBits: 1}, local, true); err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+	// Locally managed offsets advance, and the remote offset is ignored
+	for _, expected := range []int{0, 6} {
+		result, err := table.Match(LNET_MD_OP_PUT, 2, AnyProcess, 1, 100, 6)
+		if err != nil {
+			t.Fatalf("Match failed: %v", err)
+		}
+		if result.Offset != expected {
+			t.Errorf("expected offset %d, got %d", expected, result.Offset)
+		}
+	}
+	// 4 bytes left, and the MD does not truncate
+	if _, err := table.Match(LNET_MD_OP_PUT, 2, AnyProcess, 1, 0, 6); !errors.Is(err, ErrMatchDropped) {
+		t.Errorf("expected ErrMatchDropped, got %v", err)
+	}
+
+	remote := &MemoryDescriptor{Buffer: make([]byte, 16), Threshold: LNET_MD_THRESH_INF, Options: LNET_MD_OP_GET | LNET_MD_MANAGE_REMOTE | LNET_MD_TRUNCATE}
+	if _, err := table.Attach(2, &MatchEntry{MatchID: AnyProcess, MatchBits: 2}, remote, true); err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+	result, err := table.Match(LNET_MD_OP_GET, 2, AnyProcess, 2, 12, 8)
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	if result.Offset != 12 || result.Length != 4 {
+		t.Errorf("expected truncated match at 12+4, got %d+%d", result.Offset, result.Length)
+	}
+}
+
+func TestPortalMatchMaxSize(t *testing.T) {
+	table := NewPortalTable()
+	md := &MemoryDescriptor{Buffer: make([]byte, 10), Threshold: LNET_MD_THRESH_INF, MaxSize: 4, Options: LNET_MD_OP_PUT | LNET_MD_MAX_SIZE}
+	if _, err := table.Attach(3, &MatchEntry{MatchID: AnyProcess, Unlink: true}, md, true); err != nil {
+		t.Fatalf("Attach failed: %v", err)
+	}
+	first, err := table.Match(LNET_MD_OP_PUT, 3, AnyProcess, 0, 0, 3)
+	if err != nil || first.Unlinked {
+		t.Fatalf("expected first match to keep the MD: %v", err)
+	}
+	second, err := table.Match(LNET_MD_OP_PUT, 3, AnyProcess, 0, 0, 4)
+	if err != nil {
+		t.Fatalf("Match failed: %v", err)
+	}
+	if second.Offset != 3 || !second.Unlinked {
+		t.Errorf("expected second match at offset 3 to exhaust the MD, got %+v", second)
+	}
+}
+
+func TestProcessIDMatches(t *testing.T) {
+	nid, err := ParseNID("10.0.0.1@tcp0")
+	if err != nil {
+		t.Fatalf("ParseNID failed: %v", err)
+	}
+	other, err := ParseNID("10.0.0.2@tcp0")
+	if err != nil {
+		t.Fatalf("ParseNID failed: %v", err)
+	}
+	id := ProcessID{NID: nid, PID: PID_ANY}
+	if !id.Matches(ProcessID{NID: nid, PID: PID_LUSTRE}) {
+		t.Error("expected PID_ANY to match any PID")
+	}
+	if id.Matches(ProcessID{NID: other, PID: PID_LUSTRE}) {
+		t.Error("expected a different NID not to match")
+	}
+}
@@ -9,9 +9,9 @@ package lnet
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"log/slog"
-	"math/rand/v2"
 	"strings"
 )
 
@@ -25,9 +25,10 @@ type ProtocolMagic uint32
 // nidstr.h
 const (
 	NETWORK_TYPE_INVALID NetworkType = iota
-	NETWORK_TYPE_TCP     NetworkType = 2 // SOCKLND
-	NETWORK_TYPE_O2IB    NetworkType = 5 // o2ib
-	NETWORK_TYPE_LO      NetworkType = 9 // loopback
+	NETWORK_TYPE_TCP     NetworkType = 2    // SOCKLND
+	NETWORK_TYPE_O2IB    NetworkType = 5    // o2ib
+	NETWORK_TYPE_LO      NetworkType = 9    // loopback
+	NETWORK_TYPE_UNIX    NetworkType = 0x55 // GlimmerFS Unix-domain sockets, unknown to Lustre
 	NETWORK_TYPE_ANY     NetworkType = 0xFF
 )
 
@@ -47,16 +48,62 @@ const (
 	PROTO_MAGIC_ACCEPTOR_REV ProtocolMagic = 0x0071ceac
 	// Unified LND protocol magic
 	PROTO_MAGIC_GENERIC ProtocolMagic = 0x45726963
+	// Reverse byte order
+	PROTO_MAGIC_GENERIC_REV ProtocolMagic = 0x63697245
 	// Normal TCP/SOCKLND magic
 	PROTO_MAGIC_TCP ProtocolMagic = 0xeebc0ded
 )
 
+// lnet-idl.h
+const (
+	// Acceptor connection request carrying a NID64
+	ACCEPTOR_VERSION_1 uint32 = 1
+	// Acceptor connection request carrying an ExtendedNID
+	ACCEPTOR_VERSION_2 uint32 = 2
+)
+
 // socklnd.h
 const (
 	KSOCK_MSG_NOOP uint32 = 0xc0
 	KSOCK_MSG_LNET uint32 = 0xc1
 )
 
+// socklnd.h
+const (
+	KSOCK_PROTO_V2 uint32 = 2
+	KSOCK_PROTO_V3 uint32 = 3
+	KSOCK_PROTO_V4 uint32 = 4 // ExtendedNID in HELLO and message headers
+)
+
+// ConnType is the socklnd connection type exchanged in HELLO.
+type ConnType uint32
+
+// socklnd.h
+const (
+	SOCKLND_CONN_NONE     ConnType = 0xFFFFFFFF // -1: rejected connection
+	SOCKLND_CONN_ANY      ConnType = 0
+	SOCKLND_CONN_CONTROL  ConnType = 1
+	SOCKLND_CONN_BULK_IN  ConnType = 2
+	SOCKLND_CONN_BULK_OUT ConnType = 3
+)
+
+func (connType ConnType) String() string {
+	switch connType {
+	case SOCKLND_CONN_NONE:
+		return "NONE"
+	case SOCKLND_CONN_ANY:
+		return "ANY"
+	case SOCKLND_CONN_CONTROL:
+		return "CONTROL"
+	case SOCKLND_CONN_BULK_IN:
+		return "BULK_IN"
+	case SOCKLND_CONN_BULK_OUT:
+		return "BULK_OUT"
+	default:
+		return fmt.Sprintf("unknown(%d)", uint32(connType))
+	}
+}
+
 func NetworkTypeFromString(s string) (NetworkType, error) {
 	s = strings.ToLower(s)
 	switch s {
@@ -66,6 +113,8 @@ func NetworkTypeFromString(s string) (NetworkType, error) {
 		return NETWORK_TYPE_O2IB, nil
 	case "lo":
 		return NETWORK_TYPE_LO, nil
+	case "unix":
+		return NETWORK_TYPE_UNIX, nil
 	}
 	return NETWORK_TYPE_INVALID, fmt.Errorf("unsupported network type: %s", s)
 }
@@ -78,6 +127,8 @@ func (netType NetworkType) String() string {
 		return "o2ib"
 	case NETWORK_TYPE_LO:
 		return "lo"
+	case NETWORK_TYPE_UNIX:
+		return "unix"
 	case NETWORK_TYPE_ANY:
 		return "any"
 	default:
@@ -97,11 +148,13 @@ type helloResponseCommonTail struct {
 	DestPID           PID32
 	SourceIncarnation uint64
 	DestIncarnation   uint64
-	ConnType          uint32
+	ConnType          ConnType
 	NIPs              uint32
 	// IPs uint32[] Unsupported (zero length array)
 }
 
+// helloResponse is a ksock_hello_msg with ExtendedNIDs (protocol version 4).
+// The layout is the same in both directions.
 type helloResponse struct {
 	Magic        ProtocolMagic
 	ProtoVersion uint32
@@ -110,6 +163,8 @@ type helloResponse struct {
 	helloResponseCommonTail
 }
 
+// helloResponseV2 is a ksock_hello_msg with NID64s (protocol versions 2 and 3).
+// The layout is the same in both directions.
 type helloResponseV2 struct {
 	Magic        ProtocolMagic
 	ProtoVersion uint32
@@ -120,13 +175,25 @@ type helloResponseV2 struct {
 
 // ProtocolUpgrade switches to a better protocol as part of Negotiation
 // This handles ksock_hello_msg from socklnd.h
+// The exchange is bounded by remote.Timeouts.Hello and by ctx.
 func ProtocolUpgrade(ctx context.Context, remote *RemoteConn) error {
-	_ = ctx
+	return withDeadline(ctx, remote.Timeouts.Hello, (*remote.Conn).SetDeadline, func() error {
+		return protocolUpgrade(remote)
+	})
+}
+
+func protocolUpgrade(remote *RemoteConn) error {
 	var protocolMagic ProtocolMagic
 	if err := binary.Read(*remote.Conn, remote.ByteOrder, &protocolMagic); err != nil {
 		return fmt.Errorf("failed to read protocol magic: %w", err)
 	}
 	switch protocolMagic {
+	case PROTO_MAGIC_GENERIC_REV:
+		// socklnd detects the byte order of HELLO separately from the acceptor request
+		slog.Info("Detected reverse byte order in hello, switching byte order for this connection")
+		remote.ByteOrder = GetOppositeByteOrder(remote.ByteOrder)
+		protocolMagic = PROTO_MAGIC_GENERIC
+		fallthrough
 	case PROTO_MAGIC_GENERIC:
 		slog.Info("Remote supports unified protocol, switching to TCP protocol")
 		remote.Protocol = PROTO_MAGIC_TCP
@@ -141,6 +208,7 @@ func ProtocolUpgrade(ctx context.Context, remote *RemoteConn) error {
 	if err := binary.Read(*remote.Conn, remote.ByteOrder, &protocolVersion); err != nil {
 		return fmt.Errorf("failed to read protocol version: %w", err)
 	}
+	remote.Version = protocolVersion
 
 	handleCommon := func() (helloResponseCommonTail, error) {
 		var commonTail helloResponseCommonTail
@@ -150,17 +218,30 @@ func ProtocolUpgrade(ctx context.Context, remote *RemoteConn) error {
 		if commonTail.NIPs != 0 {
 			return helloResponseCommonTail{}, fmt.Errorf("unsupported non-zero NIPs value: %d", commonTail.NIPs)
 		}
-		incarnation := commonTail.SourceIncarnation
-		commonTail.DestIncarnation = incarnation
-		commonTail.SourceIncarnation = rand.Uint64()
+		remote.PID = commonTail.SourcePID
+		remote.Incarnation = commonTail.SourceIncarnation
+		// The peer sends the type from its point of view
+		remote.ConnType = commonTail.ConnType.Invert()
 		commonTail.DestPID = commonTail.SourcePID
-		// commonTail.SourcePID = PID_GLIMMER | PID_USERLAND
-		if commonTail.ConnType == 2 {
-			commonTail.ConnType = 3
-		}
+		commonTail.DestIncarnation = commonTail.SourceIncarnation
+		commonTail.SourcePID = remote.localPID
+		commonTail.SourceIncarnation = remote.localIncarnation
+		commonTail.ConnType = remote.ConnType
 		return commonTail, nil
 	}
 
+	// admit tells the peer whether we accept the connection (SOCKLND_CONN_NONE if not)
+	admit := func(commonTail *helloResponseCommonTail) error {
+		if remote.admit == nil {
+			return nil
+		}
+		err := remote.admit(remote)
+		if err != nil {
+			commonTail.ConnType = SOCKLND_CONN_NONE
+		}
+		return err
+	}
+
 	switch protocolVersion {
 	case 2, 3:
 		slog.Info("Remote is using protocol version 2/3, expecting hello message with NID64 format", "version", protocolVersion)
@@ -176,6 +257,9 @@ func ProtocolUpgrade(ctx context.Context, remote *RemoteConn) error {
 		if err != nil {
 			return err
 		}
+		remote.NID = rawSourceNID64.ToNID64()
+		remote.LocalNID = rawDestNID64.ToNID64()
+		admitErr := admit(&commonTail)
 		response := helloResponseV2{
 			Magic:        protocolMagic,
 			ProtoVersion: protocolVersion,
@@ -187,6 +271,9 @@ func ProtocolUpgrade(ctx context.Context, remote *RemoteConn) error {
 		if err := binary.Write(*remote.Conn, remote.ByteOrder, &response); err != nil {
 			return fmt.Errorf("failed to write hello response in protocol version 2: %w", err)
 		}
+		if admitErr != nil {
+			return admitErr
+		}
 	case 4:
 		slog.Info("Remote is using protocol version 4, expecting hello message with ExtendedNID format")
 		var rawSourceENid RawExtendedNID
@@ -201,8 +288,15 @@ func ProtocolUpgrade(ctx context.Context, remote *RemoteConn) error {
 		if err != nil {
 			return err
 		}
+		if remote.NID, err = rawSourceENid.ToNID(); err != nil {
+			return fmt.Errorf("invalid source NID in protocol version 4: %w", err)
+		}
+		if remote.LocalNID, err = rawDestENid.ToNID(); err != nil {
+			return fmt.Errorf("invalid destination NID in protocol version 4: %w", err)
+		}
+		admitErr := admit(&commonTail)
 		response := helloResponse{
-			Magic:        remote.Protocol,
+			Magic:        protocolMagic,
 			ProtoVersion: protocolVersion,
 			// swap nids in response
 			SourceNID:               rawDestENid,
@@ -212,6 +306,9 @@ func ProtocolUpgrade(ctx context.Context, remote *RemoteConn) error {
 		if err := binary.Write(*remote.Conn, remote.ByteOrder, &response); err != nil {
 			return fmt.Errorf("failed to write hello response in protocol version 4: %w", err)
 		}
+		if admitErr != nil {
+			return admitErr
+		}
 	default:
 		return fmt.Errorf("unsupported protocol version: %d", protocolVersion)
 	}
@@ -219,7 +316,34 @@ func ProtocolUpgrade(ctx context.Context, remote *RemoteConn) error {
 }
 
 // Negotiate handles initial protocol negotiation with the remote peer.
+// The acceptor request is bounded by remote.Timeouts.Accept, the HELLO by remote.Timeouts.Hello,
+// and both by ctx.
 func Negotiate(ctx context.Context, remote *RemoteConn) error {
+	err := withDeadline(ctx, remote.Timeouts.Accept, (*remote.Conn).SetDeadline, func() error {
+		return readAcceptorRequest(remote)
+	})
+	if err != nil {
+		return err
+	}
+	return ProtocolUpgrade(ctx, remote)
+}
+
+// ErrGenericProtocol is returned by Negotiate for a peer that opened with the generic LNet
+// protocol magic instead of an acceptor request. Like Lustre, we answer with our acceptor magic
+// and version, which tells the peer to use the acceptor protocol, and end the connection.
+var ErrGenericProtocol = errors.New("peer queried the generic LNet protocol, answered with acceptor version")
+
+// replyGenericProtocol answers a generic protocol query (lnet_accept in Lustre).
+func replyGenericProtocol(remote *RemoteConn) error {
+	reply := acceptorConnRequest{Magic: PROTO_MAGIC_ACCEPTOR, Version: ACCEPTOR_VERSION_1}
+	if err := binary.Write(*remote.Conn, remote.ByteOrder, &reply); err != nil {
+		return fmt.Errorf("failed to answer generic protocol query: %w", err)
+	}
+	return ErrGenericProtocol
+}
+
+// readAcceptorRequest reads the acceptor connection request (lnet_acceptor_connreq).
+func readAcceptorRequest(remote *RemoteConn) error {
 	var acceptorMagic ProtocolMagic
 	if err := binary.Read(*remote.Conn, remote.ByteOrder, &acceptorMagic); err != nil {
 		return fmt.Errorf("failed to read acceptor magic: %w", err)
@@ -228,10 +352,12 @@ func Negotiate(ctx context.Context, remote *RemoteConn) error {
 	case PROTO_MAGIC_ACCEPTOR_REV:
 		slog.Info("Detected reverse byte order from remote, switching byte order for this connection")
 		remote.ByteOrder = GetOppositeByteOrder(remote.ByteOrder)
-	case PROTO_MAGIC_GENERIC:
-		return fmt.Errorf("Generic protocol not supported by LNetClient yet")
+	case PROTO_MAGIC_GENERIC, PROTO_MAGIC_GENERIC_REV:
+		return replyGenericProtocol(remote)
 	case PROTO_MAGIC_ACCEPTOR:
 		slog.Debug("Received valid acceptor magic from remote, proceeding with negotiation")
+	case PROTO_MAGIC_TCP, ProtocolMagic(Swab32(uint32(PROTO_MAGIC_TCP))):
+		return fmt.Errorf("refusing connection with 'old' socknal/tcpnal acceptor protocol")
 	default:
 		return fmt.Errorf("invalid acceptor magic: expected 0x%08x, got 0x%08x", PROTO_MAGIC_ACCEPTOR, acceptorMagic)
 	}
@@ -240,30 +366,25 @@ func Negotiate(ctx context.Context, remote *RemoteConn) error {
 		return fmt.Errorf("failed to read acceptor version: %w", err)
 	}
 
-	var sourceNID NID
+	var targetNID NID
 	var err error
 	switch acceptorVersion {
 	case 1:
 		slog.Info("Remote is using supported acceptor version 1, proceeding with negotiation")
-		sourceNID, err = ReadNID(*remote.Conn, remote.ByteOrder, acceptorVersion)
+		targetNID, err = ReadNID(*remote.Conn, remote.ByteOrder, false)
 		if err != nil {
-			return fmt.Errorf("failed to read source NID: %w", err)
-		}
-		if _, ok := sourceNID.(NID64); !ok {
-			slog.Warn("Remote sent non-NID64 source NID, which may not be compatible with Lustre peers", "remote_nid", sourceNID)
+			return fmt.Errorf("failed to read target NID: %w", err)
 		}
 	case 2:
 		slog.Info("Remote is using supported acceptor version 2, proceeding with negotiation")
-		sourceNID, err = ReadNID(*remote.Conn, remote.ByteOrder, acceptorVersion)
+		targetNID, err = ReadNID(*remote.Conn, remote.ByteOrder, true)
 		if err != nil {
-			return fmt.Errorf("failed to read source NID: %w", err)
-		}
-		if _, ok := sourceNID.(ExtendedNID); !ok {
-			slog.Warn("Remote sent non-ExtendedNID source NID in acceptor version 2, which may not be compatible with Lustre peers", "remote_nid", sourceNID)
+			return fmt.Errorf("failed to read target NID: %w", err)
 		}
 	default:
-		return fmt.Errorf("unsupported acceptor version: expected 1, got %d", acceptorVersion)
+		return fmt.Errorf("unsupported acceptor version: expected 1 or 2, got %d", acceptorVersion)
 	}
-	remote.NID = sourceNID
-	return ProtocolUpgrade(ctx, remote)
+	// The request names the NID the peer wants to reach, i.e., ours
+	remote.LocalNID = targetNID
+	return nil
 }
@@ -7,6 +7,16 @@ Network protocols and types.
 package lnet
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -15,3 +25,113 @@ func TestProtocolAcceptorMagic(t *testing.T) {
 		t.Errorf("PROTO_MAGIC_ACCEPTOR_REV (0x%08x) is not the byte-swapped version of PROTO_MAGIC_ACCEPTOR (0x%08x)", PROTO_MAGIC_ACCEPTOR_REV, PROTO_MAGIC_ACCEPTOR)
 	}
 }
+
+// readTranscript reads handshake bytes from a hex dump in testdata.
+// Everything after a # on a line is a comment.
+func readTranscript(t *testing.T, name string) []byte {
+	t.Helper()
+	text, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Reading transcript failed: %v", err)
+	}
+	var digits strings.Builder
+	for line := range strings.Lines(string(text)) {
+		line, _, _ = strings.Cut(line, "#")
+		digits.WriteString(strings.Join(strings.Fields(line), ""))
+	}
+	data, err := hex.DecodeString(digits.String())
+	if err != nil {
+		t.Fatalf("Decoding transcript %s failed: %v", name, err)
+	}
+	return data
+}
+
+// replay sends the transcript of a peer to Negotiate and returns what was sent back.
+func replay(t *testing.T, client *LNetClient, request []byte, replySize int) ([]byte, *RemoteConn, error) {
+	t.Helper()
+	local, peer := net.Pipe()
+	defer local.Close()
+	reply := make(chan []byte, 1)
+	go func() {
+		defer peer.Close()
+		if _, err := peer.Write(request); err != nil {
+			reply <- nil
+			return
+		}
+		data := make([]byte, replySize)
+		n, _ := io.ReadFull(peer, data)
+		reply <- data[:n]
+	}()
+	remote := client.newRemoteConn(local)
+	err := Negotiate(context.Background(), remote)
+	return <-reply, remote, err
+}
+
+func TestNegotiateTranscripts(t *testing.T) {
+	tests := []struct {
+		name      string
+		byteOrder binary.ByteOrder
+		connType  ConnType
+	}{
+		{"hello_v3_le", binary.LittleEndian, SOCKLND_CONN_CONTROL},
+		{"hello_v3_be", binary.BigEndian, SOCKLND_CONN_BULK_OUT},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := NewLNetClient()
+			client.ByteOrder = binary.LittleEndian
+			client.Incarnation = 0x186a4b2c9e000000
+			expected := readTranscript(t, tt.name+".reply.hex")
+			reply, remote, err := replay(t, &client, readTranscript(t, tt.name+".request.hex"), len(expected))
+			if err != nil {
+				t.Fatalf("Negotiate failed: %v", err)
+			}
+			if !bytes.Equal(reply, expected) {
+				t.Errorf("Replied\n%x\nexpected\n%x", reply, expected)
+			}
+			if remote.ByteOrder != tt.byteOrder || remote.Version != KSOCK_PROTO_V3 || remote.ConnType != tt.connType {
+				t.Errorf("Negotiated %v, version %d, %v; expected %v, version 3, %v",
+					remote.ByteOrder, remote.Version, remote.ConnType, tt.byteOrder, tt.connType)
+			}
+			if remote.NID.String() != "10.0.0.2@tcp0#988" || remote.Incarnation != 0x0005f1e2d3c4b5a6 {
+				t.Errorf("Negotiated with %v (incarnation 0x%x); expected 10.0.0.2@tcp0", remote.NID, remote.Incarnation)
+			}
+		})
+	}
+}
+
+func TestNegotiateGenericProtocol(t *testing.T) {
+	expected := readTranscript(t, "generic.reply.hex")
+	for _, name := range []string{"generic_le", "generic_be"} {
+		t.Run(name, func(t *testing.T) {
+			client := NewLNetClient()
+			client.ByteOrder = binary.LittleEndian
+			reply, _, err := replay(t, &client, readTranscript(t, name+".request.hex"), len(expected))
+			if !errors.Is(err, ErrGenericProtocol) {
+				t.Errorf("Negotiate returned %v; expected %v", err, ErrGenericProtocol)
+			}
+			if !bytes.Equal(reply, expected) {
+				t.Errorf("Replied %x; expected %x", reply, expected)
+			}
+		})
+	}
+}
+
+// TestNegotiateSwappedHello checks that the byte order of HELLO is detected on its own,
+// as socklnd does, even if it differs from that of the acceptor request.
+func TestNegotiateSwappedHello(t *testing.T) {
+	const acceptorRequestSize = 16
+	request := append(readTranscript(t, "hello_v3_le.request.hex")[:acceptorRequestSize],
+		readTranscript(t, "hello_v3_be.request.hex")[acceptorRequestSize:]...)
+	expected := readTranscript(t, "hello_v3_be.reply.hex")
+	client := NewLNetClient()
+	client.ByteOrder = binary.LittleEndian
+	client.Incarnation = 0x186a4b2c9e000000
+	reply, remote, err := replay(t, &client, request, len(expected))
+	if err != nil {
+		t.Fatalf("Negotiate failed: %v", err)
+	}
+	if !bytes.Equal(reply, expected) || remote.ByteOrder != binary.BigEndian {
+		t.Errorf("Replied %x in %v; expected %x in big-endian", reply, remote.ByteOrder, expected)
+	}
+}
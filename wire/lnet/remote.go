@@ -1,17 +1,252 @@
 /*
 Copyright © 2026 GlimmerFS Project
 SPDX-License-Identifier: GPL-2.0
+
+Connections to remote peers.
+
+Reads happen in handleCommands, while all writes after the handshake go through a
+send queue that is serviced by a single writer goroutine per connection.
+This keeps frames from concurrent senders from interleaving on the socket.
 */
 package lnet
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Number of frames that can wait for the writer before senders block
+const DEFAULT_SEND_QUEUE_SIZE = 64
+
+// ErrConnClosed is returned when sending on a closed connection.
+var ErrConnClosed = errors.New("LNet connection closed")
+
 type RemoteConn struct {
 	Conn      *net.Conn
 	ByteOrder binary.ByteOrder
 	Protocol  ProtocolMagic
 	NID       NID
+	// Our NID as known by the remote
+	LocalNID NID
+	// Negotiated during HELLO
+	Version     uint32   // socklnd protocol version
+	PID         PID32    // Remote process identifier
+	Incarnation uint64   // Remote incarnation (changes when the peer restarts)
+	ConnType    ConnType // Connection type from our point of view
+	// Maximum number of queued frames (DEFAULT_SEND_QUEUE_SIZE if 0)
+	SendQueueSize int
+	// Maximum size of a received LNet header and payload (DEFAULT_MAX_MESSAGE_SIZE if 0)
+	MaxMessageSize int
+	// Limits for reads and writes
+	Timeouts Timeouts
+	// Use of ksock checksums
+	Checksum ChecksumMode
+
+	writerOnce sync.Once
+	sendQueue  chan outgoingFrame
+	closing    chan struct{}
+	closeOnce  sync.Once
+	writerDone chan struct{}
+	writeErr   error // set by the writer before writerDone is closed
+	// Set by stopReading
+	readStopped atomic.Bool
+	// When the last frame was received (Unix nanoseconds)
+	lastReceived atomic.Int64
+	// Checksum counters of this connection and of the client
+	checksums       checksumCounters
+	clientChecksums *checksumCounters
+	// What we tell the peer in our HELLO reply
+	localPID         PID32
+	localIncarnation uint64
+	// Decides whether to accept the connection once the peer's HELLO was read (see admitConn)
+	admit func(*RemoteConn) error
+	// The LND that carries the connection
+	lnd LND
+}
+
+// outgoingFrame is a complete frame (or, without data, a flush marker) for the writer.
+type outgoingFrame struct {
+	// Context of the sender, which bounds the write
+	ctx     context.Context
+	data    []byte
+	written chan error
+}
+
+func (remote *RemoteConn) String() string {
+	if remote.Conn == nil {
+		return fmt.Sprintf("%v", remote.NID)
+	}
+	return fmt.Sprintf("%v(%v)", remote.NID, (*remote.Conn).RemoteAddr())
+}
+
+// newRemoteConn creates a RemoteConn for a connection that is about to negotiate.
+func (client *LNetClient) newRemoteConn(conn net.Conn) *RemoteConn {
+	return &RemoteConn{
+		Conn:             &conn,
+		ByteOrder:        client.ByteOrder,
+		SendQueueSize:    client.SendQueueSize,
+		MaxMessageSize:   client.MaxMessageSize,
+		Timeouts:         client.Timeouts,
+		Checksum:         client.Checksum,
+		clientChecksums:  client.checksums,
+		localPID:         client.PID,
+		localIncarnation: client.Incarnation,
+		admit:            client.admitConn,
+	}
+}
+
+// maxMessageSize returns the limit for received messages.
+func (remote *RemoteConn) maxMessageSize() int {
+	if remote.MaxMessageSize <= 0 {
+		if remote.largeHeaders() {
+			return DEFAULT_MAX_MESSAGE_SIZE - LNET_HDR_NID4_SIZE + LNET_HDR_NID16_SIZE
+		}
+		return DEFAULT_MAX_MESSAGE_SIZE
+	}
+	return remote.MaxMessageSize
+}
+
+// largeHeaders reports whether LNet headers on the connection have large NIDs (lnet_hdr_nid16).
+func (remote *RemoteConn) largeHeaders() bool {
+	return remote.Version >= KSOCK_PROTO_V4
+}
+
+// startWriter starts the writer goroutine (once).
+func (remote *RemoteConn) startWriter() {
+	remote.writerOnce.Do(func() {
+		size := remote.SendQueueSize
+		if size <= 0 {
+			size = DEFAULT_SEND_QUEUE_SIZE
+		}
+		remote.sendQueue = make(chan outgoingFrame, size)
+		remote.closing = make(chan struct{})
+		remote.writerDone = make(chan struct{})
+		go remote.writeFrames()
+	})
+}
+
+// writeFrames writes queued frames in order until the connection is closed.
+// Frames still queued when Close is called are written before the writer exits.
+func (remote *RemoteConn) writeFrames() {
+	var err error
+	conn := *remote.Conn
+	write := func(frame outgoingFrame) {
+		if err != nil || frame.data == nil {
+			frame.written <- err
+			return
+		}
+		if ctxErr := frame.ctx.Err(); ctxErr != nil {
+			// The sender gave up before anything was written, so the stream is still intact
+			frame.written <- ctxErr
+			return
+		}
+		writeErr := withDeadline(frame.ctx, remote.Timeouts.Write, conn.SetWriteDeadline, func() error {
+			_, err := conn.Write(frame.data)
+			return err
+		})
+		if writeErr != nil {
+			err = fmt.Errorf("failed to write LNet frame: %w", writeErr)
+			slog.Error("LNet writer failed", "error", err, "remote", remote)
+			// The stream is broken, so also stop the reader
+			_ = conn.Close()
+		}
+		frame.written <- err
+	}
+	defer func() {
+		remote.writeErr = err
+		close(remote.writerDone)
+	}()
+	for {
+		select {
+		case frame := <-remote.sendQueue:
+			write(frame)
+		case <-remote.closing:
+			for {
+				select {
+				case frame := <-remote.sendQueue:
+					write(frame)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// enqueue queues a frame for the writer and waits until it is written.
+// Blocks while the send queue is full (backpressure).
+func (remote *RemoteConn) enqueue(ctx context.Context, data []byte) error {
+	remote.startWriter()
+	frame := outgoingFrame{ctx: ctx, data: data, written: make(chan error, 1)}
+	select {
+	case <-remote.closing:
+		return ErrConnClosed
+	default:
+	}
+	select {
+	case remote.sendQueue <- frame:
+	case <-remote.closing:
+		return ErrConnClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-frame.written:
+		return err
+	case <-remote.writerDone:
+		// Frames queued after the writer drained the queue are never written
+		select {
+		case err := <-frame.written:
+			return err
+		default:
+			return ErrConnClosed
+		}
+	case <-ctx.Done():
+		// The writer drops the frame, unless it already started writing it
+		return ctx.Err()
+	}
+}
+
+// LastReceived returns when the last frame was received from the peer.
+func (remote *RemoteConn) LastReceived() time.Time {
+	return time.Unix(0, remote.lastReceived.Load())
+}
+
+// markReceived records that the peer was heard from.
+func (remote *RemoteConn) markReceived() {
+	remote.lastReceived.Store(time.Now().UnixNano())
+}
+
+// stopReading makes handleCommands return ErrConnClosed instead of waiting for the next frame.
+// A frame that is being read is still read and handled.
+func (remote *RemoteConn) stopReading() {
+	remote.readStopped.Store(true)
+	_ = (*remote.Conn).SetReadDeadline(aLongTimeAgo)
+}
+
+// Flush waits until all frames queued so far are written.
+func (remote *RemoteConn) Flush(ctx context.Context) error {
+	return remote.enqueue(ctx, nil)
+}
+
+// Close writes any queued frames, then closes the connection.
+// This also ends handleCommands for the connection.
+func (remote *RemoteConn) Close() error {
+	remote.startWriter()
+	remote.closeOnce.Do(func() {
+		close(remote.closing)
+	})
+	<-remote.writerDone
+	err := (*remote.Conn).Close()
+	if errors.Is(err, net.ErrClosed) {
+		return remote.writeErr
+	}
+	return errors.Join(remote.writeErr, err)
 }
@@ -0,0 +1,133 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for the per-connection send queue.
+*/
+package lnet
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSendConcurrent checks that frames from concurrent senders are not interleaved.
+func TestSendConcurrent(t *testing.T) {
+	const senders = 8
+	const frames = 50
+	const frameSize = 1000
+	local, peer := net.Pipe()
+	defer peer.Close()
+	remote := &RemoteConn{Conn: &local, ByteOrder: DEFAULT_BYTE_ORDER, SendQueueSize: 4}
+	defer remote.Close()
+
+	received := make(chan error, 1)
+	go func() {
+		counts := make(map[byte]int)
+		frame := make([]byte, frameSize)
+		for range senders * frames {
+			if _, err := io.ReadFull(peer, frame); err != nil {
+				received <- err
+				return
+			}
+			if !bytes.Equal(frame, bytes.Repeat(frame[:1], frameSize)) {
+				received <- errors.New("frames are interleaved")
+				return
+			}
+			counts[frame[0]]++
+		}
+		if len(counts) != senders {
+			received <- errors.New("frames are missing")
+			return
+		}
+		received <- nil
+	}()
+
+	ctx := context.Background()
+	var wg sync.WaitGroup
+	for sender := range senders {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			frame := bytes.Repeat([]byte{byte(sender)}, frameSize)
+			for range frames {
+				if err := remote.enqueue(ctx, frame); err != nil {
+					t.Errorf("enqueue failed: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := <-received; err != nil {
+		t.Errorf("Reading frames failed: %v", err)
+	}
+}
+
+// TestSendQueueBackpressure checks that senders block while the queue is full.
+func TestSendQueueBackpressure(t *testing.T) {
+	local, peer := net.Pipe()
+	defer peer.Close()
+	remote := &RemoteConn{Conn: &local, ByteOrder: DEFAULT_BYTE_ORDER, SendQueueSize: 1}
+
+	// Nothing reads from the pipe, so the writer blocks on the first frame
+	// and the second one fills the queue.
+	background := context.Background()
+	for range 2 {
+		go func() { _ = remote.enqueue(background, []byte("frame")) }()
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(background, 50*time.Millisecond)
+	defer cancel()
+	if err := remote.enqueue(ctx, []byte("frame")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("enqueue on a full queue returned %v; expected %v", err, context.DeadlineExceeded)
+	}
+
+	// Closing writes the queued frames, which fails once the peer is gone
+	peer.Close()
+	if err := remote.Close(); err == nil {
+		t.Errorf("Close succeeded; expected the failed write to be reported")
+	}
+}
+
+// TestCloseFlushesQueue checks that queued frames are written before the connection is closed.
+func TestCloseFlushesQueue(t *testing.T) {
+	local, peer := net.Pipe()
+	defer peer.Close()
+	remote := &RemoteConn{Conn: &local, ByteOrder: DEFAULT_BYTE_ORDER}
+
+	received := make(chan []byte, 1)
+	go func() {
+		data, _ := io.ReadAll(peer)
+		received <- data
+	}()
+
+	ctx := context.Background()
+	for _, frame := range []string{"one", "two"} {
+		if err := remote.enqueue(ctx, []byte(frame)); err != nil {
+			t.Fatalf("enqueue failed: %v", err)
+		}
+	}
+	if err := remote.Flush(ctx); err != nil {
+		t.Errorf("Flush failed: %v", err)
+	}
+	if err := remote.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if data := <-received; string(data) != "onetwo" {
+		t.Errorf("Received %q; expected %q", data, "onetwo")
+	}
+	if err := remote.enqueue(ctx, []byte("three")); !errors.Is(err, ErrConnClosed) {
+		t.Errorf("enqueue after Close returned %v; expected %v", err, ErrConnClosed)
+	}
+	if err := remote.Close(); err != nil {
+		t.Errorf("Second Close failed: %v", err)
+	}
+}
@@ -0,0 +1,342 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+LNet routing (router.c and the routing parts of lib-move.c).
+
+A route leads to a remote network through a gateway on one of our networks.
+Messages for a NID on a remote network are sent to the gateway with the DestNID unchanged,
+and a node with Forwarding set passes messages that are not for it on to their next hop.
+Gateways are pinged periodically, and routes through dead gateways are not used.
+*/
+package lnet
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Hop count of routes that do not give one
+	DEFAULT_ROUTE_HOPS = 1
+	// Time between pings of the gateways (live_router_check_interval)
+	DEFAULT_ROUTER_CHECK_INTERVAL = 60 * time.Second
+	// Maximum number of gateways a single NID range of a route may expand to
+	MAX_ROUTE_GATEWAYS = 256
+)
+
+// Route leads to a remote network through a gateway on a local network.
+type Route struct {
+	NetType NetworkType
+	NetNum  uint16
+	Gateway NID
+	// Number of routers to the remote network, including the gateway
+	Hops uint32
+	// Routes with lower priority values are preferred
+	Priority uint32
+}
+
+func (route Route) String() string {
+	return fmt.Sprintf("%s%d via %s hops %d priority %d", route.NetType, route.NetNum, route.Gateway, route.Hops, route.Priority)
+}
+
+// reaches reports whether the route leads to the network of the NID.
+func (route Route) reaches(nid NID) bool {
+	header := nid.Header()
+	return route.NetType == header.Type && route.NetNum == header.NetworkIndex
+}
+
+// RouteInfo is a route with the result of the last check of its gateway.
+type RouteInfo struct {
+	Route
+	Alive bool
+}
+
+// RouteTable holds the routes to remote networks.
+type RouteTable struct {
+	mu     sync.Mutex
+	routes []*routeState
+}
+
+type routeState struct {
+	Route
+	alive bool
+	// Incremented when the route is used, for round robin
+	seq uint64
+}
+
+// NewRouteTable creates an empty route table.
+func NewRouteTable() *RouteTable {
+	return &RouteTable{}
+}
+
+// Add adds a route, which is alive until a check of its gateway fails.
+// The gateway must not be on the remote network itself, and each gateway is only added once per network.
+func (table *RouteTable) Add(route Route) error {
+	if route.Gateway == nil || route.Gateway.IsAny() {
+		return fmt.Errorf("invalid route %s: no gateway", route)
+	}
+	if route.reaches(route.Gateway) {
+		return fmt.Errorf("invalid route %s: gateway is on the remote network", route)
+	}
+	if route.Hops == 0 {
+		route.Hops = DEFAULT_ROUTE_HOPS
+	}
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	for _, state := range table.routes {
+		if state.NetType == route.NetType && state.NetNum == route.NetNum && SameNID(state.Gateway, route.Gateway) {
+			return fmt.Errorf("route %s already exists", route)
+		}
+	}
+	table.routes = append(table.routes, &routeState{Route: route, alive: true})
+	return nil
+}
+
+// Remove removes the route to the network through the gateway, and reports whether there was one.
+func (table *RouteTable) Remove(netType NetworkType, netNum uint16, gateway NID) bool {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	count := len(table.routes)
+	table.routes = slices.DeleteFunc(table.routes, func(state *routeState) bool {
+		return state.NetType == netType && state.NetNum == netNum && SameNID(state.Gateway, gateway)
+	})
+	return len(table.routes) < count
+}
+
+// Routes returns all routes, in the order they were added.
+func (table *RouteTable) Routes() []RouteInfo {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	infos := make([]RouteInfo, 0, len(table.routes))
+	for _, state := range table.routes {
+		infos = append(infos, RouteInfo{Route: state.Route, Alive: state.alive})
+	}
+	return infos
+}
+
+// gateways returns the distinct gateways of all routes.
+func (table *RouteTable) gateways() []NID {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	var gateways []NID
+	for _, state := range table.routes {
+		if !slices.ContainsFunc(gateways, func(gateway NID) bool { return SameNID(gateway, state.Gateway) }) {
+			gateways = append(gateways, state.Gateway)
+		}
+	}
+	return gateways
+}
+
+// selectGateway picks the gateway to reach one of the NIDs (of a single peer) through:
+// of the live routes to their networks whose gateway is reachable, the lowest priority value,
+// then the fewest hops, then the least recently used.
+func (table *RouteTable) selectGateway(nids []NID, reachable func(gateway NID) bool) (NID, bool) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	var best *routeState
+	for _, state := range table.routes {
+		if !state.alive || !slices.ContainsFunc(nids, state.reaches) {
+			continue
+		}
+		if reachable != nil && !reachable(state.Gateway) {
+			continue
+		}
+		if best == nil || state.Priority < best.Priority ||
+			(state.Priority == best.Priority && (state.Hops < best.Hops ||
+				(state.Hops == best.Hops && state.seq < best.seq))) {
+			best = state
+		}
+	}
+	if best == nil {
+		return nil, false
+	}
+	best.seq++
+	return best.Gateway, true
+}
+
+// setGatewayAlive updates the routes through the gateway from the result of pinging it.
+// A gateway that does not answer, or does not forward (PING_FEATURE_RTE_DISABLED), is dead.
+// Otherwise a single hop route is alive unless all the gateway's NIs on the remote network are down.
+func (table *RouteTable) setGatewayAlive(gateway NID, ping PingResponse, pingErr error) {
+	table.mu.Lock()
+	defer table.mu.Unlock()
+	for _, state := range table.routes {
+		if !SameNID(state.Gateway, gateway) {
+			continue
+		}
+		alive := pingErr == nil && PingFeature(ping.Features)&PING_FEATURE_RTE_DISABLED == 0
+		if alive && state.Hops <= 1 {
+			found, up := false, false
+			for _, status := range ping.NIDStatuses {
+				if status.NID != nil && state.reaches(status.NID) {
+					found = true
+					up = up || status.Status == PING_NI_STATUS_UP
+				}
+			}
+			alive = up || !found
+		}
+		if alive != state.alive {
+			slog.Info("route changed state", "route", state.Route, "alive", alive, "error", pingErr)
+		}
+		state.alive = alive
+	}
+}
+
+// ParseRoutes parses a Lustre routes= setting: routes separated by ';' or newlines, each
+// "<net> [<hops>] <gateway>[:<priority>] ...", where a gateway may be a NID range,
+// e.g., "o2ib0 2 192.168.0.[1-4]@tcp0:1; tcp1 10.0.0.1@tcp0".
+func ParseRoutes(s string) ([]Route, error) {
+	var routes []Route
+	for _, entry := range strings.FieldsFunc(s, func(r rune) bool { return r == ';' || r == '\n' }) {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid route %q: expected <net> [<hops>] <gateway> ...", entry)
+		}
+		spec, err := parseNetworkSpec(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid route %q: %w", entry, err)
+		}
+		if len(spec.Interfaces) > 0 {
+			return nil, fmt.Errorf("invalid route %q: unexpected interfaces", entry)
+		}
+		hops := uint64(DEFAULT_ROUTE_HOPS)
+		gateways := fields[1:]
+		if n, err := strconv.ParseUint(gateways[0], 10, 32); err == nil {
+			hops, gateways = n, gateways[1:]
+		}
+		if hops == 0 || len(gateways) == 0 {
+			return nil, fmt.Errorf("invalid route %q: expected <net> [<hops>] <gateway> ...", entry)
+		}
+		for _, gateway := range gateways {
+			nids, priority, err := parseGateway(gateway)
+			if err != nil {
+				return nil, fmt.Errorf("invalid route %q: %w", entry, err)
+			}
+			for _, nid := range nids {
+				routes = append(routes, Route{NetType: spec.NetType, NetNum: spec.NetNum, Gateway: nid, Hops: uint32(hops), Priority: priority})
+			}
+		}
+	}
+	return routes, nil
+}
+
+// parseGateway parses "<nid or NID range>[:<priority>]".
+func parseGateway(s string) ([]NID, uint32, error) {
+	var priority uint64
+	if nidStr, priorityStr, ok := strings.Cut(s, ":"); ok && !strings.Contains(priorityStr, ":") {
+		var err error
+		if priority, err = strconv.ParseUint(priorityStr, 10, 32); err != nil {
+			return nil, 0, fmt.Errorf("invalid gateway priority %q", priorityStr)
+		}
+		s = nidStr
+	}
+	// Plain NIDs may have a port
+	if nid, err := ParseNID(s); err == nil {
+		return []NID{nid}, uint32(priority), nil
+	}
+	nidRange, err := ParseNIDRange(s)
+	if err != nil {
+		return nil, 0, err
+	}
+	nids, err := nidRange.Expand(MAX_ROUTE_GATEWAYS)
+	if err != nil {
+		return nil, 0, err
+	}
+	return nids, uint32(priority), nil
+}
+
+// AddRoutes adds the routes to client.Routes.
+func (client *LNetClient) AddRoutes(routes []Route) error {
+	for _, route := range routes {
+		if err := client.Routes.Add(route); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckRouters pings each gateway once, and marks the routes through it alive or dead.
+// Each ping is bounded by client.TransactionTimeout.
+func (client *LNetClient) CheckRouters(ctx context.Context) {
+	for _, gateway := range client.Routes.gateways() {
+		pingCtx, cancel := context.WithTimeout(ctx, client.TransactionTimeout)
+		remote, err := client.PeerConn(pingCtx, gateway, 0)
+		var ping PingResponse
+		if err == nil {
+			ping, err = client.PingRemote(pingCtx, remote)
+		}
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Debug("router check failed", "gateway", gateway, "error", err)
+		}
+		client.Routes.setGatewayAlive(gateway, ping, err)
+	}
+}
+
+// RunRouterChecks checks the gateways every interval (DEFAULT_ROUTER_CHECK_INTERVAL if 0),
+// until ctx is cancelled.
+func (client *LNetClient) RunRouterChecks(ctx context.Context, interval time.Duration) error {
+	if interval == 0 {
+		interval = DEFAULT_ROUTER_CHECK_INTERVAL
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		client.CheckRouters(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// isLocal reports whether a message received from the remote with the given DestNID is for us.
+// Without local NIs, we cannot tell, so all messages are.
+func (client *LNetClient) isLocal(remote *RemoteConn, dest NID) bool {
+	if dest == nil || dest.IsAny() || SameNID(dest, remote.LocalNID) {
+		return true
+	}
+	if _, ok := client.NIs.lookup(dest); ok {
+		return true
+	}
+	return len(client.NIs.NIs()) == 0
+}
+
+// forward sends a message that is not for us on to its next hop, with its header unchanged.
+func (client *LNetClient) forward(ctx context.Context, from *RemoteConn, message LNetMessage) error {
+	if !client.Forwarding {
+		return fmt.Errorf("not a router, dropping message for %s", message.DestNID)
+	}
+	dest := message.DestNID
+	if NIDPort(dest) == DEFAULT_PORT {
+		// NIDs on the wire have no port: the next hop likely listens on ours
+		dest = withPort(dest, client.Port)
+	}
+	remote, _, err := client.route(ctx, dest, len(message.Payload))
+	if err != nil {
+		return fmt.Errorf("failed to forward message for %s: %w", message.DestNID, err)
+	}
+	if remote == from {
+		return fmt.Errorf("not forwarding message for %s back to %s", message.DestNID, from.NID)
+	}
+	// Until a buffer is free, the sender waits, as we stop reading from it
+	if err := client.routerBuffers.acquire(ctx); err != nil {
+		return fmt.Errorf("no router buffer for message to %s: %w", message.DestNID, err)
+	}
+	defer client.routerBuffers.release()
+	return client.SendMessage(ctx, remote, message)
+}
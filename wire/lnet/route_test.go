@@ -0,0 +1,151 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for routes, gateway selection and forwarding.
+*/
+package lnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseRoutes(t *testing.T) {
+	routes, err := ParseRoutes("o2ib0 2 192.168.0.[1-2]@tcp0:1; tcp1 10.0.0.1@tcp0\ntcp2 10.0.0.1@tcp0#1234")
+	if err != nil {
+		t.Fatalf("ParseRoutes failed: %v", err)
+	}
+	expected := []string{
+		"o2ib0 via 192.168.0.1@tcp0#988 hops 2 priority 1",
+		"o2ib0 via 192.168.0.2@tcp0#988 hops 2 priority 1",
+		"tcp1 via 10.0.0.1@tcp0#988 hops 1 priority 0",
+		"tcp2 via 10.0.0.1@tcp0#1234 hops 1 priority 0",
+	}
+	if fmt.Sprint(routes) != fmt.Sprint(expected) {
+		t.Errorf("ParseRoutes returned %v; expected %v", routes, expected)
+	}
+
+	for _, s := range []string{"tcp1", "tcp1 0 10.0.0.1@tcp0", "tcp1(eth0) 10.0.0.1@tcp0", "tcp1 *@tcp0", "tcp1 10.0.0.1@tcp0:x"} {
+		if _, err := ParseRoutes(s); err == nil {
+			t.Errorf("ParseRoutes(%q) succeeded; expected an error", s)
+		}
+	}
+	table := NewRouteTable()
+	if err := table.Add(Route{NetType: NETWORK_TYPE_TCP, NetNum: 0, Gateway: mustParseNID(t, "10.0.0.1@tcp0")}); err == nil {
+		t.Errorf("Add accepted a gateway on the remote network")
+	}
+}
+
+func TestSelectGateway(t *testing.T) {
+	table := NewRouteTable()
+	gw1, gw2, gw3 := mustParseNID(t, "10.0.0.1@tcp0"), mustParseNID(t, "10.0.0.2@tcp0"), mustParseNID(t, "10.0.0.3@tcp0")
+	for _, route := range []Route{
+		{NetType: NETWORK_TYPE_TCP, NetNum: 1, Gateway: gw1},
+		{NetType: NETWORK_TYPE_TCP, NetNum: 1, Gateway: gw2},
+		{NetType: NETWORK_TYPE_TCP, NetNum: 1, Gateway: gw3, Hops: 2},
+	} {
+		if err := table.Add(route); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	dest := []NID{mustParseNID(t, "10.1.0.1@tcp1")}
+
+	// Round robin over the routes with the fewest hops
+	var picked []NID
+	for range 4 {
+		gateway, _ := table.selectGateway(dest, nil)
+		picked = append(picked, gateway)
+	}
+	if expected := []NID{gw1, gw2, gw1, gw2}; fmt.Sprint(picked) != fmt.Sprint(expected) {
+		t.Errorf("selectGateway picked %v; expected %v", picked, expected)
+	}
+
+	// Dead gateways and gateways without the remote network up are skipped
+	table.setGatewayAlive(gw1, PingResponse{}, errors.New("timeout"))
+	down := PingResponse{NIDStatuses: []NIDStatus{{NID: mustParseNID(t, "10.1.0.2@tcp1"), Status: PING_NI_STATUS_DOWN}}}
+	table.setGatewayAlive(gw2, down, nil)
+	if gateway, _ := table.selectGateway(dest, nil); !SameNID(gateway, gw3) {
+		t.Errorf("selectGateway picked %v; expected %v", gateway, gw3)
+	}
+	table.setGatewayAlive(gw3, PingResponse{PingHeader: PingHeader{Features: uint32(PING_FEATURE_RTE_DISABLED)}}, nil)
+	if gateway, ok := table.selectGateway(dest, nil); ok {
+		t.Errorf("selectGateway picked %v; expected none", gateway)
+	}
+	if table.Remove(NETWORK_TYPE_TCP, 1, gw1) != true || len(table.Routes()) != 2 {
+		t.Errorf("Remove did not remove the route")
+	}
+}
+
+// TestRouting reaches a server on tcp1 from a client on tcp0 through a router on both.
+func TestRouting(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	port := uint16(free.Addr().(*net.TCPAddr).Port)
+	free.Close()
+
+	// NIDs in LNet headers have no port, so all nodes use the same one
+	router := NewLNetServer().WithPort(port)
+	router.Client.Forwarding = true
+	router.Client.NIs.Set([]LocalNI{testNI(0, "127.0.0.3"), testNI(1, "127.0.0.4")})
+	go func() { _ = router.Listen(ctx) }()
+	defer router.Shutdown(ctx)
+	server := NewLNetServer().WithPort(port)
+	server.Client.NIs.Set([]LocalNI{testNI(1, "127.0.0.5")})
+	go func() { _ = server.Listen(ctx) }()
+	defer server.Shutdown(ctx)
+	serverNID := mustParseNID(t, fmt.Sprintf("127.0.0.5@tcp1#%d", port))
+
+	client := NewLNetClient().WithPort(port)
+	client.NIs.Set([]LocalNI{testNI(0, "127.0.0.2")})
+	if _, err := client.PingNID(ctx, serverNID); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("PingNID without a route returned %v; expected %v", err, ErrUnreachable)
+	}
+	gateway := mustParseNID(t, fmt.Sprintf("127.0.0.3@tcp0#%d", port))
+	if err := client.Routes.Add(Route{NetType: NETWORK_TYPE_TCP, NetNum: 1, Gateway: gateway}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	var ping PingResponse
+	for {
+		if ping, err = client.PingNID(ctx, serverNID); err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("PingNID failed: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if primary := ping.Primary(); primary == nil || primary.NetAddr().String() != "127.0.0.5" {
+		t.Errorf("Ping reply has primary NID %v; expected 127.0.0.5@tcp1", primary)
+	}
+	remote, err := client.PeerConn(ctx, serverNID, 0)
+	if err != nil || !SameNID(remote.NID, gateway) {
+		t.Errorf("PeerConn returned %v, %v; expected the connection to %v", remote, err, gateway)
+	}
+
+	// The router forwards, so the route stays alive
+	client.CheckRouters(ctx)
+	if routes := client.Routes.Routes(); len(routes) != 1 || !routes[0].Alive {
+		t.Fatalf("Routes are %+v; expected one live route", routes)
+	}
+	// A node that does not forward drops messages for others, and is not used as a gateway
+	router.Client.Forwarding = false
+	shortCtx, shortCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	_, err = client.PingNID(shortCtx, serverNID)
+	shortCancel()
+	if !errors.Is(err, ErrOperationTimeout) {
+		t.Errorf("PingNID through a non-router returned %v; expected %v", err, ErrOperationTimeout)
+	}
+	client.CheckRouters(ctx)
+	if _, err := client.PingNID(ctx, serverNID); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("PingNID through a dead route returned %v; expected %v", err, ErrUnreachable)
+	}
+}
@@ -6,20 +6,47 @@ package lnet
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
+	"net/netip"
+	"sync"
 )
 
+// ErrServerClosed is returned by Listen after Shutdown.
+var ErrServerClosed = errors.New("LNet server closed")
+
 type LNetServer struct {
 	// Underlying client for handling connections and messages
 	Client LNetClient
 	// Configuration for incoming connections
 	ListenConfig net.ListenConfig
+	// Called when a connection fails (optional), in addition to logging the error
+	OnConnError func(err *ConnError)
+
+	// Shared by copies from WithPort
+	state *serverState
+}
+
+// serverState tracks listeners and connections for Shutdown.
+type serverState struct {
+	mu        sync.Mutex
+	closing   bool
+	listeners map[net.Listener]struct{}
+	conns     map[*RemoteConn]struct{}
+	// Running connection handlers
+	handlers sync.WaitGroup
 }
 
 func NewLNetServer() *LNetServer {
-	return &LNetServer{Client: NewLNetClient()}
+	return &LNetServer{
+		Client: NewLNetClient(),
+		state: &serverState{
+			listeners: make(map[net.Listener]struct{}),
+			conns:     make(map[*RemoteConn]struct{}),
+		},
+	}
 }
 
 // WithPort returns a copy of the LNetServer with the specified port for incoming connections.
@@ -29,25 +56,167 @@ func (server LNetServer) WithPort(port uint16) LNetServer {
 }
 
 // Listen to connections and dispatch valid connections to handlers
+// With NIs (see LNetClient.ConfigureNetworks), the LND of each NI listens on its address (or socket),
+// following the changes of the NIs. Otherwise, the TCP LND listens on a wildcard address.
+// Cancelling ctx stops accepting connections, but existing connections are served until Shutdown.
 func (server *LNetServer) Listen(ctx context.Context) error {
-	// YAGNI: support more than just tcp? like o2ib?
-	listener, err := server.ListenConfig.Listen(ctx, "tcp", fmt.Sprintf(":%d", server.Client.Port))
+	if server.state == nil {
+		return fmt.Errorf("LNetServer must be created with NewLNetServer")
+	}
+	if len(server.Client.NIs.NIs()) > 0 {
+		return server.listenNIs(ctx)
+	}
+	lnd, err := server.Client.lnd(NETWORK_TYPE_TCP)
+	if err != nil {
+		return err
+	}
+	ni := LocalNI{NetType: NETWORK_TYPE_TCP}
+	listener, err := lnd.Startup(ctx, server.ListenConfig, ni, server.Client.Port)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err := lnd.Shutdown(ni); err != nil {
+			slog.Warn("LNetServer failed to shut down LND", "ni", ni, "error", err)
+		}
+	}()
+	return server.serve(ctx, listener, lnd)
+}
+
+// listenNIs serves a listener per NI address, opening and closing listeners as the NIs change.
+// NIs whose address cannot be bound are marked down, and retried on the next change.
+func (server *LNetServer) listenNIs(ctx context.Context) error {
+	// NIs on different nets of one type may share an address (or socket path), and so a listener
+	type listenAddr struct {
+		netType NetworkType
+		addr    netip.AddrPort
+		path    string
+	}
+	type serving struct {
+		lnd    LND
+		ni     LocalNI
+		cancel context.CancelFunc
+		done   chan struct{}
+	}
+	listeners := make(map[listenAddr]*serving)
+	closed := make(chan error, 1)
+	stop := func(listener *serving) {
+		listener.cancel()
+		<-listener.done
+		if err := listener.lnd.Shutdown(listener.ni); err != nil {
+			slog.Warn("LNetServer failed to shut down LND", "ni", listener.ni, "error", err)
+		}
+	}
+	defer func() {
+		for _, listener := range listeners {
+			stop(listener)
+		}
+	}()
+	for {
+		changed := server.Client.NIs.Changed()
+		addrs := make(map[listenAddr][]LocalNI)
+		for _, ni := range server.Client.NIs.NIs() {
+			addr := listenAddr{netType: ni.NetType, addr: netip.AddrPortFrom(ni.Addr, server.Client.Port)}
+			if ni.NetType == NETWORK_TYPE_UNIX {
+				addr = listenAddr{netType: ni.NetType, path: unixSocket(ni)}
+			}
+			addrs[addr] = append(addrs[addr], ni)
+		}
+		for addr, listener := range listeners {
+			if addrs[addr] == nil {
+				slog.Info("LNetServer closing listener of removed NI", "ni", listener.ni)
+				stop(listener)
+				delete(listeners, addr)
+			}
+		}
+		for addr, nis := range addrs {
+			if listeners[addr] != nil {
+				continue
+			}
+			lnd, err := server.Client.lnd(addr.netType)
+			var listener net.Listener
+			if err == nil {
+				listener, err = lnd.Startup(ctx, server.ListenConfig, nis[0], server.Client.Port)
+			}
+			status := PING_NI_STATUS_UP
+			if err != nil {
+				slog.Error("LNetServer cannot listen on NI", "ni", nis[0], "error", err)
+				status = PING_NI_STATUS_DOWN
+			}
+			for _, ni := range nis {
+				server.Client.NIs.SetStatus(ni, status)
+			}
+			if err != nil || listener == nil {
+				continue
+			}
+			listenCtx, cancel := context.WithCancel(ctx)
+			serving := &serving{lnd: lnd, ni: nis[0], cancel: cancel, done: make(chan struct{})}
+			listeners[addr] = serving
+			go func() {
+				defer close(serving.done)
+				if err := server.serve(listenCtx, listener, lnd); err != nil {
+					select {
+					case closed <- err:
+					default:
+					}
+				}
+			}()
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-closed:
+			// Shutdown or a failed Accept
+			return err
+		case <-changed:
+		}
+	}
+}
+
+// Serve accepts TCP or Unix-domain connections on the listener until ctx is cancelled
+// or Shutdown is called. The listener is closed when Serve returns.
+func (server *LNetServer) Serve(ctx context.Context, listener net.Listener) error {
+	netType := NETWORK_TYPE_TCP
+	if listener.Addr().Network() == "unix" {
+		netType = NETWORK_TYPE_UNIX
+	}
+	lnd, err := server.Client.lnd(netType)
+	if err != nil {
+		return err
+	}
+	return server.serve(ctx, listener, lnd)
+}
+
+// serve is Serve for connections of the given LND.
+func (server *LNetServer) serve(ctx context.Context, listener net.Listener, lnd LND) error {
+	state := server.state
 	// Ensure the listener is closed
 	// This can be called multiple times
 	closeListener := func() {
 		slog.Debug("LNetServer listener shutting down")
 		err := listener.Close()
-		if err != nil {
+		if err != nil && !errors.Is(err, net.ErrClosed) {
 			slog.Warn("error closing listener", "error", err)
 		}
 	}
 	defer closeListener()
-	slog.Info("LNetServer listening", "port", server.Client.Port)
+	state.mu.Lock()
+	if state.closing {
+		state.mu.Unlock()
+		return ErrServerClosed
+	}
+	state.listeners[listener] = struct{}{}
+	state.mu.Unlock()
+	defer func() {
+		state.mu.Lock()
+		delete(state.listeners, listener)
+		state.mu.Unlock()
+	}()
+	slog.Info("LNetServer listening", "addr", listener.Addr())
 	context.AfterFunc(ctx, closeListener)
 
+	// Connections outlive ctx, so that Shutdown can drain them
+	connCtx := context.WithoutCancel(ctx)
 	for {
 		slog.Debug("LNetServer waiting for connection")
 		conn, err := listener.Accept()
@@ -56,9 +225,122 @@ func (server *LNetServer) Listen(ctx context.Context) error {
 				// Context was cancelled, exit gracefully
 				return nil
 			}
+			if server.closing() {
+				return ErrServerClosed
+			}
 			slog.Error("Accept Error", "error", err)
 			return err
 		}
-		go server.Client.handleConnection(ctx, conn)
+		remote := server.Client.newRemoteConn(conn)
+		remote.lnd = lnd
+		if !server.track(remote) {
+			_ = conn.Close()
+			return ErrServerClosed
+		}
+		go server.serveConn(connCtx, remote)
+	}
+}
+
+// track registers a new connection, unless the server is shutting down.
+func (server *LNetServer) track(remote *RemoteConn) bool {
+	state := server.state
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	if state.closing {
+		return false
+	}
+	state.conns[remote] = struct{}{}
+	state.handlers.Add(1)
+	return true
+}
+
+func (server *LNetServer) closing() bool {
+	server.state.mu.Lock()
+	defer server.state.mu.Unlock()
+	return server.state.closing
+}
+
+// serveConn handles a connection until it ends, then closes it.
+func (server *LNetServer) serveConn(ctx context.Context, remote *RemoteConn) {
+	state := server.state
+	defer state.handlers.Done()
+	defer func() {
+		state.mu.Lock()
+		delete(state.conns, remote)
+		state.mu.Unlock()
+	}()
+
+	err := server.Client.handleConnection(ctx, remote)
+	var connErr *ConnError
+	errors.As(err, &connErr)
+	switch {
+	case server.closing():
+		// Shutdown interrupted the connection, so its error is expected.
+		// Peers are told we are alive until the end, unless the handshake did not finish.
+		if connErr == nil || connErr.Op != "negotiate" {
+			if err := server.Client.SendNoop(ctx, remote); err != nil {
+				slog.Debug("failed to send final NOOP", "error", err, "remote", remote)
+			}
+		}
+	case connErr != nil:
+		slog.Error("LNetClient connection failed", "error", connErr)
+		if server.OnConnError != nil {
+			server.OnConnError(connErr)
+		}
+	}
+	if err := remote.Close(); err != nil {
+		slog.Warn("error closing connection", "error", err, "remote", remote)
+	}
+}
+
+// Connections returns the connections that are currently being served.
+func (server *LNetServer) Connections() []*RemoteConn {
+	state := server.state
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	conns := make([]*RemoteConn, 0, len(state.conns))
+	for remote := range state.conns {
+		conns = append(conns, remote)
+	}
+	return conns
+}
+
+// Shutdown gracefully stops the server.
+// It stops accepting connections, lets running handlers finish, sends a final NOOP on each
+// connection and closes it, then waits for all connection goroutines to exit.
+// If ctx ends first, the remaining connections are closed forcibly and ctx.Err() is returned.
+func (server *LNetServer) Shutdown(ctx context.Context) error {
+	state := server.state
+	state.mu.Lock()
+	state.closing = true
+	for listener := range state.listeners {
+		if err := listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			slog.Warn("error closing listener", "error", err)
+		}
+	}
+	for remote := range state.conns {
+		// Interrupt the read that waits for the next frame.
+		// A handler that is running finishes before handleCommands reads again.
+		remote.stopReading()
+	}
+	state.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		state.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		slog.Info("LNetServer shut down")
+		return nil
+	case <-ctx.Done():
+	}
+	state.mu.Lock()
+	for remote := range state.conns {
+		_ = (*remote.Conn).Close()
 	}
+	state.mu.Unlock()
+	<-done
+	return ctx.Err()
 }
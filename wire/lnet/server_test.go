@@ -0,0 +1,106 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for serving incoming connections.
+*/
+package lnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// startServer serves on a local port and returns the NID to dial and the result of Serve.
+func startServer(t *testing.T, ctx context.Context, server *LNetServer) (NID, <-chan error) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- server.Serve(ctx, listener) }()
+	port := listener.Addr().(*net.TCPAddr).Port
+	nid, err := ParseNID(fmt.Sprintf("127.0.0.1@tcp0#%d", port))
+	if err != nil {
+		t.Fatalf("ParseNID failed: %v", err)
+	}
+	return nid, served
+}
+
+func TestServerShutdown(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server := NewLNetServer()
+	nid, served := startServer(t, ctx, server)
+
+	client := NewLNetClient()
+	remote, err := client.Dial(ctx, nid)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer remote.Close()
+	if _, err := client.PingRemote(ctx, remote); err != nil {
+		t.Fatalf("PingRemote failed: %v", err)
+	}
+	if conns := server.Connections(); len(conns) != 1 {
+		t.Errorf("Server has %d connections; expected 1", len(conns))
+	}
+
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed: %v", err)
+	}
+	if err := <-served; !errors.Is(err, ErrServerClosed) {
+		t.Errorf("Serve returned %v; expected %v", err, ErrServerClosed)
+	}
+	if conns := server.Connections(); len(conns) != 0 {
+		t.Errorf("Server has %d connections after Shutdown; expected 0", len(conns))
+	}
+	// The server closed the connection after its final NOOP
+	pingCtx, pingCancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer pingCancel()
+	if _, err := client.PingRemote(pingCtx, remote); err == nil {
+		t.Errorf("PingRemote succeeded after Shutdown")
+	}
+	if _, err := client.Dial(ctx, nid); err == nil {
+		t.Errorf("Dial succeeded after Shutdown")
+	}
+}
+
+func TestServerConnError(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server := NewLNetServer()
+	connErrors := make(chan *ConnError, 1)
+	server.OnConnError = func(err *ConnError) { connErrors <- err }
+	nid, _ := startServer(t, ctx, server)
+	defer server.Shutdown(ctx)
+
+	// A connection with a bad acceptor magic fails on its own
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", NIDPort(nid)))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("not lnet at all")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	select {
+	case connErr := <-connErrors:
+		if connErr.Op != "negotiate" {
+			t.Errorf("Connection failed in %q; expected %q", connErr.Op, "negotiate")
+		}
+	case <-ctx.Done():
+		t.Fatal("OnConnError was not called")
+	}
+
+	// Other connections are not affected
+	client := NewLNetClient()
+	if _, err := client.Ping(ctx, nid); err != nil {
+		t.Errorf("Ping failed: %v", err)
+	}
+}
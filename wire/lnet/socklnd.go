@@ -0,0 +1,122 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+The TCP LND (socklnd): the acceptor request and HELLO handshake of Negotiate and Initiate,
+then ksock frames (see frame.go) on the TCP connection.
+*/
+package lnet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// SockLND is the LND of tcp networks.
+type SockLND struct{}
+
+func (SockLND) NetworkType() NetworkType {
+	return NETWORK_TYPE_TCP
+}
+
+// Startup listens on the address of the NI. Without an address, a wildcard IPv6 socket also
+// takes IPv4 peers (as IPv4-mapped addresses), and hosts without IPv6 fall back to IPv4.
+func (SockLND) Startup(ctx context.Context, config net.ListenConfig, ni LocalNI, port uint16) (net.Listener, error) {
+	if ni.Addr.IsValid() {
+		return config.Listen(ctx, "tcp", netip.AddrPortFrom(ni.Addr, port).String())
+	}
+	listener, err := config.Listen(ctx, "tcp", net.JoinHostPort("::", strconv.Itoa(int(port))))
+	if err != nil {
+		var v4Err error
+		if listener, v4Err = config.Listen(ctx, "tcp4", net.JoinHostPort("0.0.0.0", strconv.Itoa(int(port)))); v4Err != nil {
+			return nil, errors.Join(err, v4Err)
+		}
+	}
+	return listener, nil
+}
+
+func (SockLND) Shutdown(ni LocalNI) error {
+	return nil
+}
+
+// Connect dials the address and port of the NID. Lustre peers in "secure" accept mode only take
+// connections from privileged ports, which can be requested through client.Dialer.LocalAddr.
+func (lnd SockLND) Connect(ctx context.Context, client *LNetClient, nid NID, connType ConnType, ni LocalNI) (*RemoteConn, error) {
+	dialer := client.Dialer
+	if ni.Addr.IsValid() && dialer.LocalAddr == nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: ni.Addr.AsSlice()}
+	}
+	address := netip.AddrPortFrom(nid.NetAddr(), NIDPort(nid))
+	conn, err := dialer.DialContext(ctx, "tcp", address.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", nid, err)
+	}
+	return initiate(ctx, client, lnd, conn, nid, connType)
+}
+
+// initiate performs the initiator side of the handshake on a new connection of the LND,
+// and closes the connection if it fails.
+func initiate(ctx context.Context, client *LNetClient, lnd LND, conn net.Conn, nid NID, connType ConnType) (*RemoteConn, error) {
+	remote := client.newRemoteConn(conn)
+	remote.lnd = lnd
+	if err := client.Initiate(ctx, remote, nid, connType); err != nil {
+		if closeErr := conn.Close(); closeErr != nil {
+			slog.Warn("error closing connection", "error", closeErr, "remote", conn.RemoteAddr())
+		}
+		return nil, err
+	}
+	return remote, nil
+}
+
+func (SockLND) Accept(ctx context.Context, remote *RemoteConn) error {
+	return Negotiate(ctx, remote)
+}
+
+// Send writes the message as a KSOCK_MSG_LNET frame.
+func (SockLND) Send(ctx context.Context, remote *RemoteConn, message LNetMessage) error {
+	data, err := message.encode(remote.ByteOrder, remote.largeHeaders())
+	if err != nil {
+		return fmt.Errorf("failed to convert LNet message to bytes: %w", err)
+	}
+	databuf := new(bytes.Buffer)
+	if err := binary.Write(databuf, remote.ByteOrder, KSockMessageHeader{Type: KSOCK_MSG_LNET}); err != nil {
+		return fmt.Errorf("failed to write message header: %w", err)
+	}
+	databuf.Write(data)
+	frame := databuf.Bytes()
+	remote.setChecksum(frame, remote.ByteOrder)
+	if err := remote.enqueue(ctx, frame); err != nil {
+		return fmt.Errorf("failed to write LNet message: %w", err)
+	}
+	return nil
+}
+
+// Receive reads frames until a KSOCK_MSG_LNET, as NOOPs only keep the connection alive.
+func (SockLND) Receive(ctx context.Context, remote *RemoteConn) (LNetMessage, error) {
+	for {
+		messageType, message, err := readMessage(ctx, remote)
+		if err != nil || messageType == KSOCK_MSG_LNET {
+			return message, err
+		}
+	}
+}
+
+// Query reports an NI down if its address can no longer be bound.
+func (SockLND) Query(ni LocalNI) PingStatus {
+	if !ni.Addr.IsValid() {
+		return PING_NI_STATUS_UP
+	}
+	listener, err := net.Listen("tcp", netip.AddrPortFrom(ni.Addr, 0).String())
+	if err != nil {
+		return PING_NI_STATUS_DOWN
+	}
+	_ = listener.Close()
+	return PING_NI_STATUS_UP
+}
@@ -0,0 +1,93 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Deadlines for connection I/O.
+
+Each read or write on a connection is bounded by the earlier of its timeout and the deadline
+of the context it runs for, and ending the context interrupts I/O that is blocked.
+*/
+package lnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Lustre defaults (accept_timeout and ksocklnd's sock_timeout)
+const (
+	DEFAULT_ACCEPT_TIMEOUT = 5 * time.Second
+	DEFAULT_HELLO_TIMEOUT  = 5 * time.Second
+	DEFAULT_SOCK_TIMEOUT   = 50 * time.Second
+)
+
+// Timeouts bound the I/O on a connection. A zero timeout means no limit.
+type Timeouts struct {
+	// Time for the acceptor connection request
+	Accept time.Duration
+	// Time for the HELLO exchange that follows the connection request
+	Hello time.Duration
+	// Time to read the rest of a frame once its ksock header arrived
+	Read time.Duration
+	// Time to write a frame
+	Write time.Duration
+	// Time to wait for the next frame, after which the peer is considered dead
+	Idle time.Duration
+	// Time without hearing from the peer before sending it a NOOP
+	Keepalive time.Duration
+}
+
+// DefaultTimeouts returns Lustre's timeouts.
+func DefaultTimeouts() Timeouts {
+	return Timeouts{
+		Accept:    DEFAULT_ACCEPT_TIMEOUT,
+		Hello:     DEFAULT_HELLO_TIMEOUT,
+		Read:      DEFAULT_SOCK_TIMEOUT,
+		Write:     DEFAULT_SOCK_TIMEOUT,
+		Idle:      DEFAULT_PEER_TIMEOUT,
+		Keepalive: DEFAULT_KEEPALIVE,
+	}
+}
+
+// A deadline in the past, which makes blocked I/O return immediately
+var aLongTimeAgo = time.Unix(1, 0)
+
+// deadline returns the earlier of now+timeout and the deadline of ctx (zero for none).
+func deadline(ctx context.Context, timeout time.Duration) time.Time {
+	var at time.Time
+	if timeout > 0 {
+		at = time.Now().Add(timeout)
+	}
+	if ctxDeadline, ok := ctx.Deadline(); ok && (at.IsZero() || ctxDeadline.Before(at)) {
+		at = ctxDeadline
+	}
+	return at
+}
+
+// withDeadline runs I/O under the deadline for ctx and timeout, set with setDeadline
+// (a SetDeadline method of the connection). When ctx ends, the I/O is interrupted.
+func withDeadline(ctx context.Context, timeout time.Duration, setDeadline func(time.Time) error, io func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := setDeadline(deadline(ctx, timeout)); err != nil {
+		return fmt.Errorf("failed to set deadline: %w", err)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = setDeadline(aLongTimeAgo)
+	})
+	err := io()
+	stop()
+	return ioError(ctx, err)
+}
+
+// ioError reports I/O interrupted by the end of ctx as the context error.
+func ioError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+		return fmt.Errorf("%w: %w", context.Cause(ctx), err)
+	}
+	return err
+}
@@ -0,0 +1,152 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for connection deadlines.
+*/
+package lnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestNegotiateAcceptTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server := NewLNetServer()
+	server.Client.Timeouts.Accept = 100 * time.Millisecond
+	connErrors := make(chan *ConnError, 1)
+	server.OnConnError = func(err *ConnError) { connErrors <- err }
+	nid, _ := startServer(t, ctx, server)
+	defer server.Shutdown(ctx)
+
+	// Connect, but send nothing
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", NIDPort(nid)))
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	select {
+	case connErr := <-connErrors:
+		if connErr.Op != "negotiate" || !errors.Is(connErr, os.ErrDeadlineExceeded) {
+			t.Errorf("Connection failed with %v; expected a negotiation timeout", connErr)
+		}
+	case <-ctx.Done():
+		t.Fatal("Silent connection was not timed out")
+	}
+}
+
+func TestNegotiateCancel(t *testing.T) {
+	local, peer := net.Pipe()
+	defer local.Close()
+	defer peer.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	remote := &RemoteConn{Conn: &local, ByteOrder: DEFAULT_BYTE_ORDER}
+	if err := Negotiate(ctx, remote); !errors.Is(err, context.Canceled) {
+		t.Errorf("Negotiate returned %v; expected %v", err, context.Canceled)
+	}
+}
+
+func TestDialHelloTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	defer listener.Close()
+	// Accept, but never answer the HELLO
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			_, _ = conn.Read(make([]byte, 1024))
+			time.Sleep(time.Second)
+		}
+	}()
+	nid, err := ParseNID(fmt.Sprintf("127.0.0.1@tcp0#%d", listener.Addr().(*net.TCPAddr).Port))
+	if err != nil {
+		t.Fatalf("ParseNID failed: %v", err)
+	}
+	client := NewLNetClient()
+	client.Timeouts.Hello = 100 * time.Millisecond
+	start := time.Now()
+	if _, err := client.Dial(context.Background(), nid); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Dial returned %v; expected %v", err, os.ErrDeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Dial took %v; expected the HELLO timeout to apply", elapsed)
+	}
+}
+
+func TestReadIdleTimeout(t *testing.T) {
+	local, peer := net.Pipe()
+	defer local.Close()
+	defer peer.Close()
+	remote := &RemoteConn{Conn: &local, ByteOrder: DEFAULT_BYTE_ORDER, Timeouts: Timeouts{Idle: 50 * time.Millisecond}}
+	if _, _, err := readMessage(context.Background(), remote); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("readMessage returned %v; expected %v", err, os.ErrDeadlineExceeded)
+	}
+
+	// The context interrupts reads without a timeout too
+	remote.Timeouts.Idle = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, _, err := readMessage(ctx, remote); !errors.Is(err, context.Canceled) {
+		t.Errorf("readMessage returned %v; expected %v", err, context.Canceled)
+	}
+}
+
+func TestWriteTimeout(t *testing.T) {
+	local, peer := net.Pipe()
+	defer peer.Close()
+	remote := &RemoteConn{Conn: &local, ByteOrder: DEFAULT_BYTE_ORDER, Timeouts: Timeouts{Write: 50 * time.Millisecond}}
+	defer remote.Close()
+
+	// Nothing reads from the pipe
+	ctx := context.Background()
+	if err := remote.enqueue(ctx, []byte("frame")); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("enqueue returned %v; expected %v", err, os.ErrDeadlineExceeded)
+	}
+	// A partly written frame breaks the stream for later frames
+	if err := remote.enqueue(ctx, []byte("frame")); err == nil {
+		t.Errorf("enqueue succeeded after a failed write")
+	}
+
+	// A sender that gives up before its frame is written does not break the stream
+	local, peer = net.Pipe()
+	defer peer.Close()
+	remote = &RemoteConn{Conn: &local, ByteOrder: DEFAULT_BYTE_ORDER, SendQueueSize: 1}
+	defer remote.Close()
+	go func() { _ = remote.enqueue(ctx, []byte("first")) }()
+	time.Sleep(20 * time.Millisecond)
+	cancelled, cancel := context.WithCancel(ctx)
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if err := remote.enqueue(cancelled, []byte("dropped")); !errors.Is(err, context.Canceled) {
+		t.Errorf("enqueue returned %v; expected %v", err, context.Canceled)
+	}
+	received := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 64)
+		var data []byte
+		for len(data) < len("firstlast") {
+			n, err := peer.Read(buf)
+			if err != nil {
+				break
+			}
+			data = append(data, buf[:n]...)
+		}
+		received <- string(data)
+	}()
+	if err := remote.enqueue(ctx, []byte("last")); err != nil {
+		t.Errorf("enqueue failed: %v", err)
+	}
+	if data := <-received; data != "firstlast" {
+		t.Errorf("Received %q; expected %q", data, "firstlast")
+	}
+}
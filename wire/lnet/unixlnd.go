@@ -0,0 +1,190 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+The Unix-domain socket LND (a GlimmerFS extension): socklnd's handshake and ksock frames
+on AF_UNIX sockets, for sidecars and other peers on the same node, without a TCP port or the
+pod network. Lustre has no such network, so it is refused in CompatMode.
+
+A unix NID is written "PATH@unixN", e.g., "/run/glimmer/lnet.sock@unix0". On the wire, it is a
+64-bit NID whose address is a hash of the path, so the paths of the NIDs we parsed or created
+are remembered to dial them and print them.
+*/
+package lnet
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DEFAULT_UNIX_SOCKET is the socket of unix networks without a path, e.g., "unix0".
+const DEFAULT_UNIX_SOCKET = "/run/glimmer/lnet.sock"
+
+// unixPaths holds the socket path of each unix NID address (hash).
+// Entries are never removed, as there is one per path parsed or listened on, which are few.
+var unixPaths sync.Map
+
+// UnixNID returns the NID of the Unix-domain socket on the net number.
+// The path must be absolute, or an abstract socket name starting with '@'.
+// Unix NIDs have no port, as the path is the whole address.
+func UnixNID(path string, netNum uint16) (NID, error) {
+	if !filepath.IsAbs(path) && !strings.HasPrefix(path, "@") {
+		return nil, fmt.Errorf("invalid unix socket path %q: expected an absolute path or an abstract name", path)
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(path))
+	addr := hash.Sum32()
+	if other, loaded := unixPaths.LoadOrStore(addr, path); loaded && other != path {
+		return nil, fmt.Errorf("unix socket path %q has the same NID as %q", path, other)
+	}
+	return NID64{NIDHeader: NIDHeader{Type: NETWORK_TYPE_UNIX, NetworkIndex: netNum}, Addr: [1]uint32{addr}, Port: DEFAULT_PORT}, nil
+}
+
+// UnixPath returns the socket path of a unix NID, if it is known to this process.
+func UnixPath(nid NID) (string, bool) {
+	nid64, ok := nid.(NID64)
+	if !ok || nid64.Type != NETWORK_TYPE_UNIX {
+		return "", false
+	}
+	path, ok := unixPaths.Load(nid64.Addr[0])
+	if !ok {
+		return "", false
+	}
+	return path.(string), true
+}
+
+// parseUnixNID parses the path and net number of "PATH@unixN".
+func parseUnixNID(path string, netNumStr string) (NID, error) {
+	netNum, err := strconv.ParseUint(netNumStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid network number in NID: %w", err)
+	}
+	return UnixNID(path, uint16(netNum))
+}
+
+// unixNIDString formats a unix NID with its path, or its hash if the path is unknown.
+func unixNIDString(nid NID64) string {
+	if path, ok := UnixPath(nid); ok {
+		return fmt.Sprintf("%s@%s%d", path, nid.Type, nid.NetworkIndex)
+	}
+	return fmt.Sprintf("%08x@%s%d", nid.Addr[0], nid.Type, nid.NetworkIndex)
+}
+
+// unixNID returns our NID on the unix net: the first NI on it, or else a name of this process.
+// Connecting sockets are unnamed, so that name is only an identity, and peers reply on the connection.
+func (client *LNetClient) unixNID(netNum uint16) (NID, error) {
+	for _, ni := range client.NIs.NIs() {
+		if ni.NetType == NETWORK_TYPE_UNIX && ni.NetNum == netNum {
+			return ni.NID(client.Port)
+		}
+	}
+	return UnixNID(fmt.Sprintf("@glimmer-%016x", client.Incarnation), netNum)
+}
+
+// UnixLND is the LND of unix networks. The socket path of an NI is its Interface.
+type UnixLND struct {
+	// Frames as on tcp
+	SockLND
+}
+
+func (UnixLND) NetworkType() NetworkType {
+	return NETWORK_TYPE_UNIX
+}
+
+// Startup listens on the socket of the NI, creating its directory.
+// A socket left behind by a process that is gone is replaced, but not one that is still served.
+func (UnixLND) Startup(ctx context.Context, config net.ListenConfig, ni LocalNI, port uint16) (net.Listener, error) {
+	path := unixSocket(ni)
+	if _, err := UnixNID(path, ni.NetNum); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(path, "@") {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create directory of unix socket: %w", err)
+		}
+		if err := removeStaleSocket(ctx, path); err != nil {
+			return nil, err
+		}
+	}
+	return config.Listen(ctx, "unix", path)
+}
+
+// Shutdown does nothing, as closing the listener removes its socket.
+func (UnixLND) Shutdown(ni LocalNI) error {
+	return nil
+}
+
+// Connect dials the socket of the NID, which must have been parsed or created by UnixNID.
+// The unix NIDs of peers, as sent in HELLOs and ping buffers, only carry the hash of the path,
+// so a peer that connected to us (or a NID it lists) cannot be dialed unless we know its path
+// from elsewhere, e.g., the config: health recovery and discovery skip those (see UnixPath).
+func (lnd UnixLND) Connect(ctx context.Context, client *LNetClient, nid NID, connType ConnType, ni LocalNI) (*RemoteConn, error) {
+	path, ok := UnixPath(nid)
+	if !ok {
+		return nil, fmt.Errorf("failed to connect to %s: unknown unix socket path", nid)
+	}
+	dialer := client.Dialer
+	// A TCP local address (see SockLND.Connect) does not apply
+	dialer.LocalAddr = nil
+	conn, err := dialer.DialContext(ctx, "unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", nid, err)
+	}
+	return initiate(ctx, client, lnd, conn, nid, connType)
+}
+
+func (UnixLND) Accept(ctx context.Context, remote *RemoteConn) error {
+	return Negotiate(ctx, remote)
+}
+
+// Query reports an NI down if the directory of its socket is gone.
+func (UnixLND) Query(ni LocalNI) PingStatus {
+	path := unixSocket(ni)
+	if strings.HasPrefix(path, "@") {
+		return PING_NI_STATUS_UP
+	}
+	if info, err := os.Stat(filepath.Dir(path)); err != nil || !info.IsDir() {
+		return PING_NI_STATUS_DOWN
+	}
+	return PING_NI_STATUS_UP
+}
+
+// unixSocket returns the socket path of the NI.
+func unixSocket(ni LocalNI) string {
+	if ni.Interface == "" {
+		return DEFAULT_UNIX_SOCKET
+	}
+	return ni.Interface
+}
+
+// removeStaleSocket removes the socket at path if nothing accepts connections on it.
+func removeStaleSocket(ctx context.Context, path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check unix socket: %w", err)
+	}
+	if info.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("failed to listen on %s: not a socket", path)
+	}
+	var dialer net.Dialer
+	if conn, err := dialer.DialContext(ctx, "unix", path); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("failed to listen on %s: socket in use", path)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale unix socket: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+/*
+Copyright © 2026 GlimmerFS Project
+SPDX-License-Identifier: GPL-2.0
+
+Tests for the Unix-domain socket LND.
+*/
+package lnet
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestUnixNID(t *testing.T) {
+	nid := mustParseNID(t, "/run/glimmer/lnet.sock@unix0")
+	if path, ok := UnixPath(nid); !ok || path != DEFAULT_UNIX_SOCKET || nid.String() != "/run/glimmer/lnet.sock@unix0" {
+		t.Errorf("ParseNID returned %v with path %q; expected %s", nid, path, DEFAULT_UNIX_SOCKET)
+	}
+	if nid := mustParseNID(t, "@glimmer@unix3"); nid.Header().NetworkIndex != 3 || nid.String() != "@glimmer@unix3" {
+		t.Errorf("ParseNID returned %v; expected @glimmer@unix3", nid)
+	}
+	if _, err := ParseNID("lnet.sock@unix0"); err == nil {
+		t.Errorf("ParseNID accepted a relative socket path")
+	}
+
+	config, err := ParseNetworkConfig("unix0,unix1(/tmp/a.sock)", "")
+	if err != nil {
+		t.Fatalf("ParseNetworkConfig failed: %v", err)
+	}
+	nis, err := config.Resolve(nil)
+	expected := []LocalNI{{NetType: NETWORK_TYPE_UNIX, Interface: DEFAULT_UNIX_SOCKET}, {NetType: NETWORK_TYPE_UNIX, NetNum: 1, Interface: "/tmp/a.sock"}}
+	if err != nil || !slices.Equal(nis, expected) {
+		t.Errorf("Resolve returned %v, %v; expected %v", nis, err, expected)
+	}
+	if nid, err := nis[1].NID(0); err != nil || nid.String() != "/tmp/a.sock@unix1" {
+		t.Errorf("NI %v has NID %v, %v; expected /tmp/a.sock@unix1", nis[1], nid, err)
+	}
+}
+
+// TestUnixLND checks that a client reaches a server on its socket, which replaces a stale one.
+func TestUnixLND(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	path := filepath.Join(t.TempDir(), "lnet.sock")
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	server := NewLNetServer()
+	ni := LocalNI{NetType: NETWORK_TYPE_UNIX, Interface: path}
+	server.Client.NIs.Set([]LocalNI{ni, testNI(0, "127.0.0.1")})
+	go func() { _ = server.Listen(ctx) }()
+	defer server.Shutdown(ctx)
+	nid, err := ni.NID(0)
+	if err != nil {
+		t.Fatalf("NID failed: %v", err)
+	}
+
+	client := NewLNetClient()
+	var ping PingResponse
+	for {
+		if ping, err = client.PingNID(ctx, nid); err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("PingNID failed: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(ping.NIDStatuses) != 2 || !SameNID(ping.NIDStatuses[0].NID, nid) {
+		t.Errorf("Ping returned %v; expected %s and the tcp NID", ping.NIDStatuses, nid)
+	}
+	if _, err := client.PutTo(ctx, nid, 10, 0, 0, 0, []byte("glimmer"), false); err != nil {
+		t.Errorf("PutTo failed: %v", err)
+	}
+
+	// Lustre has no unix networks
+	compat := NewLNetClient()
+	compat.CompatMode = true
+	if _, err := compat.Dial(ctx, nid); !errors.Is(err, ErrNoLND) {
+		t.Errorf("Dial in CompatMode returned %v; expected %v", err, ErrNoLND)
+	}
+}
+
+// TestUnixUnknownPath checks that unix NIDs whose path we do not know are not recorded by discovery,
+// nor dialed by health recovery.
+func TestUnixUnknownPath(t *testing.T) {
+	unknown := NID64{NIDHeader: NIDHeader{Type: NETWORK_TYPE_UNIX}, Addr: [1]uint32{0xdeadbeef}, Port: DEFAULT_PORT}
+	if _, ok := UnixPath(unknown); ok {
+		t.Fatalf("Path of %v is known; expected a NID as only received from a peer", unknown)
+	}
+	known := mustParseNID(t, "/run/glimmer/lnet.sock@unix0")
+	tcp := mustParseNID(t, "10.0.0.1@tcp0")
+	client := NewLNetClient()
+	ping := PingResponse{NIDStatuses: []NIDStatus{{NID: tcp}, {NID: known}, {NID: unknown}}}
+	mrPeer, err := client.Peers.mergePeer(ping, tcp)
+	if err != nil {
+		t.Fatalf("mergePeer failed: %v", err)
+	}
+	if nids := mrPeer.NIDs(); len(nids) != 2 || !SameNID(nids[0], tcp) || !SameNID(nids[1], known) {
+		t.Errorf("Merged peer has NIDs %v; expected %v and %v", nids, tcp, known)
+	}
+	if client.listens(unknown) || !client.listens(known) {
+		t.Errorf("listens is %v for %v and %v for %v; expected only the known path", client.listens(unknown), unknown, client.listens(known), known)
+	}
+}